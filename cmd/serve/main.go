@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"js-centralized-wallet/internal/constants"
 	"js-centralized-wallet/pkg/model"
 	"js-centralized-wallet/pkg/server"
+	"js-centralized-wallet/pkg/utils/token"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	SHUTDOWN_TIMEOUT_SECONDS = 30
 )
 
 func main() {
@@ -14,25 +24,75 @@ func main() {
 		},
 	})))
 
-	model := model.NewModel()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	rates, err := newRateProvider()
+	if err != nil {
+		slog.Error("failed to setup fx rates", "err", err)
+		os.Exit(1)
+	}
+
+	model := model.NewModel(model.WithRateProvider(rates))
 
-	err := model.Setup()
+	err = model.Setup()
 	if err != nil {
 		slog.Error("failed to setup model", "err", err)
 		os.Exit(1)
 	}
-	server := server.NewServer(model)
 
-	// To run sync wallet snapshot everyday to make sure wallet balance is correct based on the transaction logs
+	keys, err := token.ParseKeys(constants.AUTH_KEYS)
+	if err != nil {
+		slog.Error("failed to parse auth keys", "err", err)
+		os.Exit(1)
+	}
+
+	keyring, err := token.NewKeyring(constants.AUTH_ISSUER, constants.AUTH_SIGNING_KEY_ID, keys)
+	if err != nil {
+		slog.Error("failed to setup auth keyring", "err", err)
+		os.Exit(1)
+	}
+
+	server := server.NewServer(model, keyring)
+
+	// To run sync wallet snapshot everyday to check wallet balance tallies with the transaction logs
 	err = server.StartScheduler()
 	if err != nil {
 		slog.Error("failed to start schedule", "err", err)
 		os.Exit(1)
 	}
 
-	err = server.Run()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run()
+	}()
+
+	select {
+	case err = <-runErr:
+		if err != nil {
+			slog.Error("failed to run server", "err", err)
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down server")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		slog.Error("failed to run server", "err", err)
+		slog.Error("failed to shutdown server gracefully", "err", err)
 		os.Exit(1)
 	}
+
+	slog.Info("server shut down gracefully")
+}
+
+func newRateProvider() (model.RateProvider, error) {
+	if constants.FX_RATES_FILE != "" {
+		return model.NewFileRateProvider(constants.FX_RATES_FILE)
+	}
+
+	return model.NewStaticRateProvider(model.DEFAULT_FX_RATES)
 }
@@ -12,6 +12,22 @@ var (
 
 	REDIS_HOST string
 	REDIS_PORT string
+
+	// Verification keys in the form of "kid1:secret1,kid2:secret2", tokens are signed with AUTH_SIGNING_KEY_ID
+	AUTH_ISSUER         string
+	AUTH_KEYS           string
+	AUTH_SIGNING_KEY_ID string
+
+	// JSON file of FX rates in the form of {"USD/EUR": "0.92"}, falls back to model.DEFAULT_FX_RATES
+	FX_RATES_FILE string
+
+	// "true" seeds demo users with a known password and funded wallets, never set it in production
+	SEED_DEMO_DATA string
+
+	// "true" creates the first admin from BOOTSTRAP_ADMIN_EMAIL and BOOTSTRAP_ADMIN_PASSWORD, unless the email is taken
+	BOOTSTRAP_ADMIN          string
+	BOOTSTRAP_ADMIN_EMAIL    string
+	BOOTSTRAP_ADMIN_PASSWORD string
 )
 
 func init() {
@@ -22,4 +38,16 @@ func init() {
 
 	REDIS_HOST = os.Getenv("REDIS_HOST")
 	REDIS_PORT = os.Getenv("REDIS_PORT")
+
+	AUTH_ISSUER = os.Getenv("AUTH_ISSUER")
+	AUTH_KEYS = os.Getenv("AUTH_KEYS")
+	AUTH_SIGNING_KEY_ID = os.Getenv("AUTH_SIGNING_KEY_ID")
+
+	FX_RATES_FILE = os.Getenv("FX_RATES_FILE")
+
+	SEED_DEMO_DATA = os.Getenv("SEED_DEMO_DATA")
+
+	BOOTSTRAP_ADMIN = os.Getenv("BOOTSTRAP_ADMIN")
+	BOOTSTRAP_ADMIN_EMAIL = os.Getenv("BOOTSTRAP_ADMIN_EMAIL")
+	BOOTSTRAP_ADMIN_PASSWORD = os.Getenv("BOOTSTRAP_ADMIN_PASSWORD")
 }
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Credits a positive amount to or debits a negative amount from a user wallet, against SYSTEM_ACCOUNT_ADJUSTMENTS
+// Meant for corrections by admins, so limits do not apply and frozen wallets can still be adjusted, but never their own
+// The reason is the memo of both postings, the admin is recorded on the journal entry
+func (m *Model) AdjustBalance(ctx context.Context, walletId, actorUserId uint64, amount Money, reason string) (JournalEntry, Wallet, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var entry JournalEntry
+	var wallet Wallet
+
+	if amount.IsZero() {
+		return entry, wallet, ErrInvalidAmount
+	}
+
+	reason, err := normalizeMemo(reason)
+	if err != nil {
+		return entry, wallet, err
+	}
+
+	if reason == "" {
+		return entry, wallet, ErrBadInput
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND system_account = ?", walletId, "").First(&wallet).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// Four eyes, another admin has to adjust an admin's own wallet
+		if wallet.UserId == actorUserId {
+			return ErrForbidden
+		}
+
+		if wallet.Status == WALLET_STATUS_CLOSED {
+			return ErrWalletClosed
+		}
+
+		// Amounts without a currency are in the wallet's currency
+		if amount.Currency == "" {
+			amount.Currency = wallet.Currency
+		}
+
+		if amount.Currency != wallet.Currency {
+			return ErrCurrencyMismatch
+		}
+
+		offset, err := amount.Neg()
+		if err != nil {
+			return err
+		}
+
+		if amount.IsNegative() {
+			if err := checkSufficient(wallet.AvailableBalance, offset); err != nil {
+				return err
+			}
+		}
+
+		adjustmentsWallet, err := systemWallet(tx, SYSTEM_ACCOUNT_ADJUSTMENTS, wallet.Currency)
+		if err != nil {
+			return err
+		}
+
+		entry, err = postJournalEntry(tx, TRANSACTION_TYPE_ADJUSTMENT,
+			Posting{WalletId: wallet.Id, CounterpartyWalletId: adjustmentsWallet.Id, Amount: amount, Memo: reason},
+			Posting{WalletId: adjustmentsWallet.Id, CounterpartyWalletId: wallet.Id, Amount: offset, Memo: reason},
+		)
+		if err != nil {
+			return err
+		}
+
+		entry.ActorUserId = actorUserId
+		if err := tx.Model(&entry).Update("actor_user_id", actorUserId).Error; err != nil {
+			return err
+		}
+
+		if wallet.Balance, err = wallet.Balance.Add(amount); err != nil {
+			return err
+		}
+
+		wallet.AvailableBalance, err = wallet.AvailableBalance.Add(amount)
+		return err
+	})
+	if err != nil {
+		return entry, wallet, fmt.Errorf("failed to adjust balance: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("wallet_id %d adjusted by %s by user_id %d", walletId, amount, actorUserId))
+
+	return entry, wallet, nil
+}
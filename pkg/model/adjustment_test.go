@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdjustBalance(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	admin := User{
+		Name:  "Admin",
+		Email: "admin@crypto.com",
+		Role:  USER_ROLE_ADMIN,
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, u := range []*User{&user, &admin} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	walletId := user.Wallets[0].Id
+
+	entry, wallet, err := model.AdjustBalance(ctx, walletId, admin.Id, Money{Amount: 250}, "Refund of duplicated fee")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1250), wallet.Balance.Amount)
+
+	// Frozen wallets can still be corrected
+	_, err = model.FreezeWallet(ctx, walletId, admin.Id, "Fraud")
+	assert.NoError(t, err)
+
+	_, wallet, err = model.AdjustBalance(ctx, walletId, admin.Id, NewMoney(-1250, "USD"), "Chargeback")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), wallet.Balance.Amount)
+
+	entry, postings, err := model.GetJournalEntry(ctx, entry.TransactionUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSACTION_TYPE_ADJUSTMENT, entry.Type)
+	assert.Equal(t, admin.Id, entry.ActorUserId)
+	assert.Len(t, postings, 2)
+	assert.Equal(t, walletId, postings[0].DestWalletId)
+	assert.Equal(t, NewMoney(250, "USD"), postings[0].Amount)
+	assert.Equal(t, NewMoney(-250, "USD"), postings[1].Amount)
+	assert.Equal(t, "Refund of duplicated fee", postings[1].Memo)
+
+	_, _, err = model.GetJournalEntry(ctx, "no-such-uuid")
+	assert.True(t, errors.Is(err, ErrTransactionNotFound), "expected ErrTransactionNotFound, got %v", err)
+
+	tests := []struct {
+		name     string
+		walletId uint64
+		amount   Money
+		reason   string
+		want     error
+	}{
+		{"Zero amount", walletId, NewMoney(0, "USD"), "Fix", ErrInvalidAmount},
+		{"Without reason", walletId, NewMoney(1, "USD"), " ", ErrBadInput},
+		{"Below zero balance", walletId, NewMoney(-1, "USD"), "Fix", ErrBalanceInsufficient},
+		{"Other currency", walletId, NewMoney(1, "EUR"), "Fix", ErrCurrencyMismatch},
+		{"Unknown wallet", 9999, NewMoney(1, "USD"), "Fix", ErrWalletNotFound},
+		{"Own wallet", admin.Wallets[0].Id, NewMoney(1, "USD"), "Fix", ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := model.AdjustBalance(ctx, tt.walletId, admin.Id, tt.amount, tt.reason)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	assert.NoError(t, db.Model(&Wallet{}).Where("id = ?", walletId).Update("status", WALLET_STATUS_CLOSED).Error)
+	_, _, err = model.AdjustBalance(ctx, walletId, admin.Id, NewMoney(1, "USD"), "Fix")
+	assert.True(t, errors.Is(err, ErrWalletClosed), "expected ErrWalletClosed, got %v", err)
+}
@@ -12,3 +12,9 @@ type PageInfo struct {
 	Page     int `json:"page"`
 	PageSize int `json:"page_size"`
 }
+
+// Keyset pagination, Cursor is the opaque next_cursor of the previous page, empty for the first page
+type CursorInfo struct {
+	Cursor   string `json:"cursor"`
+	PageSize int    `json:"page_size"`
+}
@@ -16,9 +16,13 @@ func (m *Model) InvalidateWalletCache(ctx context.Context, userIds ...uint64) {
 			lg.Info(fmt.Sprintf("Failed to invalidate user %d balance cache: %v", userId, err))
 		}
 
-		key = fmt.Sprintf("transaction_history:%d-0-1-30", userId)
-		if err := m.GetRedis().Del(ctx, key).Err(); err != nil {
+		if err := m.GetRedis().Del(ctx, TransactionHistoryCacheKey(userId)).Err(); err != nil {
 			lg.Info(fmt.Sprintf("Failed to invalidate user %d history cache: %v", userId, err))
 		}
 	}
 }
+
+// Hash of every cached transaction history page of the user, keyed by currency, type, cursor and page size
+func TransactionHistoryCacheKey(userId uint64) string {
+	return fmt.Sprintf("transaction_history:%d", userId)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"context"
+	"fmt"
+)
+
+// Owner of a wallet on the other side of a transaction
+// UserId is 0 and SystemAccount is set for system wallets, e.g. deposits come from external_cash
+type Counterparty struct {
+	WalletId      uint64 `json:"wallet_id"`
+	UserId        uint64 `json:"user_id"`
+	Name          string `json:"name"`
+	SystemAccount string `json:"system_account"`
+}
+
+// Resolves the owners of all wallets in one query, keyed by wallet id
+// Callers pass the SourceWalletId of a whole page of transactions, never one at a time
+func (m *Model) GetCounterparties(ctx context.Context, walletIds []uint64) (map[uint64]Counterparty, error) {
+	counterparties := make(map[uint64]Counterparty, len(walletIds))
+
+	if len(walletIds) == 0 {
+		return counterparties, nil
+	}
+
+	var rows []Counterparty
+	if err := m.db.WithContext(ctx).
+		Model(&Wallet{}).
+		Select("wallets.id AS wallet_id, wallets.user_id, wallets.system_account, COALESCE(users.name, '') AS name").
+		Joins("LEFT JOIN users ON users.id = wallets.user_id AND wallets.system_account = ''").
+		Where("wallets.id IN ?", walletIds).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get counterparties: %w", err)
+	}
+
+	for _, row := range rows {
+		counterparties[row.WalletId] = row
+	}
+
+	return counterparties, nil
+}
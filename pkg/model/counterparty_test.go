@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCounterparties(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{Name: "User A", Email: "user_a@crypto.com"}
+	dest := User{Name: "User B", Email: "user_b@crypto.com"}
+	assert.NoError(t, db.Create(&source).Error)
+	assert.NoError(t, db.Create(&dest).Error)
+
+	_, err := model.Deposit(context.Background(), source.Id, NewMoney(500, "USD"))
+	assert.NoError(t, err)
+	_, err = model.Deposit(context.Background(), dest.Id, NewMoney(1, "USD"))
+	assert.NoError(t, err)
+
+	err = model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(150, "USD"), "  lunch  ")
+	assert.NoError(t, err)
+
+	transactions, _, err := model.GetTransactionHistory(context.Background(), dest.Id, "USD", TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 2)
+
+	walletIds := make([]uint64, len(transactions))
+	for i, transaction := range transactions {
+		walletIds[i] = transaction.SourceWalletId
+	}
+
+	counterparties, err := model.GetCounterparties(context.Background(), walletIds)
+	assert.NoError(t, err)
+
+	// Newest first, the transfer from User A then the deposit from external cash
+	assert.Equal(t, "lunch", transactions[0].Memo)
+	assert.Equal(t, source.Id, counterparties[transactions[0].SourceWalletId].UserId)
+	assert.Equal(t, "User A", counterparties[transactions[0].SourceWalletId].Name)
+
+	assert.Empty(t, transactions[1].Memo)
+	assert.Equal(t, uint64(0), counterparties[transactions[1].SourceWalletId].UserId)
+	assert.Equal(t, SYSTEM_ACCOUNT_EXTERNAL_CASH, counterparties[transactions[1].SourceWalletId].SystemAccount)
+
+	// The sender sees the same memo
+	transactions, _, err = model.GetTransactionHistory(context.Background(), source.Id, "USD", TransactionFilter{Type: TRANSACTION_TYPE_TRANSFER})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "lunch", transactions[0].Memo)
+
+	err = model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(150, "USD"), strings.Repeat("a", MAX_MEMO_LENGTH+1))
+	assert.ErrorIs(t, err, ErrMemoTooLong)
+
+	counterparties, err = model.GetCounterparties(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, counterparties)
+}
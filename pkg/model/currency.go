@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	DEFAULT_CURRENCY = "USD"
+)
+
+// ISO 4217 currency, MinorUnits is the number of decimal places, e.g. 2 for USD cents
+type Currency struct {
+	Code       string `json:"code"`
+	MinorUnits int    `json:"minor_units"`
+}
+
+var currencies = map[string]Currency{
+	"USD": {Code: "USD", MinorUnits: 2},
+	"EUR": {Code: "EUR", MinorUnits: 2},
+	"GBP": {Code: "GBP", MinorUnits: 2},
+	"SGD": {Code: "SGD", MinorUnits: 2},
+	"MYR": {Code: "MYR", MinorUnits: 2},
+	"JPY": {Code: "JPY", MinorUnits: 0},
+	"KRW": {Code: "KRW", MinorUnits: 0},
+	"BHD": {Code: "BHD", MinorUnits: 3},
+}
+
+// Empty code falls back to DEFAULT_CURRENCY, for clients that predate multi-currency wallets
+func ParseCurrency(code string) (Currency, error) {
+	if code == "" {
+		code = DEFAULT_CURRENCY
+	}
+
+	currency, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return currency, ErrUnsupportedCurrency
+	}
+
+	return currency, nil
+}
+
+func SupportedCurrencies() []Currency {
+	supported := make([]Currency, 0, len(currencies))
+	for _, currency := range currencies {
+		supported = append(supported, currency)
+	}
+	slices.SortFunc(supported, func(a, b Currency) int {
+		return strings.Compare(a.Code, b.Code)
+	})
+
+	return supported
+}
+
+// Formats minor units as a decimal amount in major units, e.g. 1170 USD as "11.70"
+func (c Currency) FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+
+	abs := uint64(amount)
+	if amount < 0 {
+		abs = uint64(-(amount + 1)) + 1
+	}
+
+	if c.MinorUnits == 0 {
+		return fmt.Sprintf("%s%d", sign, abs)
+	}
+
+	scale := uint64(1)
+	for range c.MinorUnits {
+		scale *= 10
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/scale, c.MinorUnits, abs%scale)
+}
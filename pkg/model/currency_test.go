@@ -0,0 +1,60 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		code       string
+		want       string
+		minorUnits int
+		err        error
+	}{
+		{"", "USD", 2, nil},
+		{"USD", "USD", 2, nil},
+		{"eur", "EUR", 2, nil},
+		{"JPY", "JPY", 0, nil},
+		{"BHD", "BHD", 3, nil},
+		{"XXX", "", 0, ErrUnsupportedCurrency},
+	}
+
+	for _, tt := range tests {
+		currency, err := ParseCurrency(tt.code)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("code %q: expected error %v, got %v", tt.code, tt.err, err)
+			continue
+		}
+		if currency.Code != tt.want || currency.MinorUnits != tt.minorUnits {
+			t.Errorf("code %q: expected %s with %d minor units, got %s with %d", tt.code, tt.want, tt.minorUnits, currency.Code, currency.MinorUnits)
+		}
+	}
+}
+
+func TestCurrencyFormatAmount(t *testing.T) {
+	usd, _ := ParseCurrency("USD")
+	jpy, _ := ParseCurrency("JPY")
+	bhd, _ := ParseCurrency("BHD")
+
+	tests := []struct {
+		currency Currency
+		amount   int64
+		want     string
+	}{
+		{usd, 1170, "11.70"},
+		{usd, 5, "0.05"},
+		{usd, -1003, "-10.03"},
+		{usd, 0, "0.00"},
+		{jpy, -1170, "-1170"},
+		{bhd, 1001, "1.001"},
+		{usd, math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.currency.FormatAmount(tt.amount); got != tt.want {
+			t.Errorf("%d %s: expected %s, got %s", tt.amount, tt.currency.Code, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Position of the last row of a page, ordered by (created_at, id)
+type cursor struct {
+	CreatedAt time.Time
+	Id        uint64
+}
+
+func encodeCursor(createdAt time.Time, id uint64) string {
+	raw := fmt.Sprintf("%d:%d", createdAt.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return c, ErrInvalidCursor
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	c.Id, err = strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	c.CreatedAt = time.Unix(0, unixNano).UTC()
+
+	return c, nil
+}
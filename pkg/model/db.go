@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"js-centralized-wallet/internal/constants"
 	"log/slog"
@@ -60,15 +61,97 @@ func (m *Model) connectDB() error {
 func (m *Model) migrate() error {
 	slog.Info("Running GORM AutoMigrate")
 
-	err := m.db.AutoMigrate(&User{}, &Wallet{}, &Transaction{})
+	// Wallets predating holds have nothing reserved, their whole balance is available
+	backfillAvailableBalance := m.db.Migrator().HasTable(&Wallet{}) && !m.db.Migrator().HasColumn(&Wallet{}, "available_balance")
+
+	// Snapshots predating transaction_id were windowed on created_at, carry on from the last posting they could have summed
+	backfillSnapshotTransactionId := m.db.Migrator().HasTable(&WalletAmountSnapshot{}) && !m.db.Migrator().HasColumn(&WalletAmountSnapshot{}, "transaction_id")
+
+	// The unique email index cannot be created over duplicates, emails differing only in case count as duplicates
+	if m.db.Migrator().HasTable(&User{}) {
+		if err := dedupeUserEmails(m.db); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	err := m.db.AutoMigrate(&User{}, &Wallet{}, &Transaction{}, &WalletAmountSnapshot{}, &WalletDiscrepancy{}, &JournalEntry{}, &IdempotencyKey{}, &PendingTransfer{}, &DeadLetterTransfer{}, &FxQuote{}, &TransactionLimit{}, &WalletStatusEvent{}, &Hold{}, &ScheduledTransfer{}, &ScheduledTransferRun{}, &PaymentRequest{})
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if backfillAvailableBalance {
+		if err := m.db.Exec("UPDATE wallets SET available_balance = balance").Error; err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	if backfillSnapshotTransactionId {
+		if err := m.db.Exec("UPDATE wallet_snapshots SET transaction_id = COALESCE((SELECT MAX(id) FROM transactions WHERE transactions.dest_wallet_id = wallet_snapshots.wallet_id AND transactions.created_at <= wallet_snapshots.created_at), 0)").Error; err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	// Wallets predating system accounts got a NULL system_account, which ownedBy and idx_wallets_user_currency never match
+	if err := m.db.Exec("UPDATE wallets SET system_account = '' WHERE system_account IS NULL").Error; err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	// Replaced by idx_users_email_unique, login looks users up by email
+	if m.db.Migrator().HasIndex(&User{}, "idx_users_email") {
+		if err := m.db.Migrator().DropIndex(&User{}, "idx_users_email"); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	// Replaced by idx_wallets_system_account_currency, system accounts now have one wallet per currency
+	if m.db.Migrator().HasIndex(&Wallet{}, "idx_wallets_system_account") {
+		if err := m.db.Migrator().DropIndex(&Wallet{}, "idx_wallets_system_account"); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	// Replaced by the transactions composite indexes, which all lead with dest_wallet_id
+	if m.db.Migrator().HasIndex(&Transaction{}, "idx_transactions_dest_wallet_id") {
+		if err := m.db.Migrator().DropIndex(&Transaction{}, "idx_transactions_dest_wallet_id"); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	if err := createIndexes(m.db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
 	slog.Info("Database migrated successfully")
 
-	if err := m.seed(); err != nil {
-		return fmt.Errorf("failed to seed database: %w", err)
+	// Demo accounts share a known password, only for local development and testing
+	if constants.SEED_DEMO_DATA == "true" {
+		if err := m.seed(); err != nil {
+			return fmt.Errorf("failed to seed database: %w", err)
+		}
+	}
+
+	if constants.BOOTSTRAP_ADMIN == "true" {
+		if err := m.bootstrapAdmin(context.Background(), constants.BOOTSTRAP_ADMIN_EMAIL, constants.BOOTSTRAP_ADMIN_PASSWORD); err != nil {
+			return fmt.Errorf("failed to bootstrap admin: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Composite indexes over columns of the embedded Base, which cannot be declared with gorm tags
+// Transaction history always filters on dest_wallet_id and pages through (created_at, id)
+var compositeIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_transactions_wallet_created ON transactions (dest_wallet_id, created_at, id)",
+	"CREATE INDEX IF NOT EXISTS idx_transactions_wallet_type_created ON transactions (dest_wallet_id, type, created_at, id)",
+	"CREATE INDEX IF NOT EXISTS idx_transactions_wallet_counterparty_created ON transactions (dest_wallet_id, source_wallet_id, created_at, id)",
+}
+
+func createIndexes(db *gorm.DB) error {
+	for _, index := range compositeIndexes {
+		if err := db.Exec(index).Error; err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
 	}
 
 	return nil
@@ -80,9 +163,15 @@ func (m *Model) seed() error {
 	var userCount int64
 	m.db.Model(&User{}).Count(&userCount)
 	if userCount == 0 {
+		// Same password for seeded users, only ever seeded with SEED_DEMO_DATA
+		passwordHash, err := HashPassword("password")
+		if err != nil {
+			return fmt.Errorf("failed to seed users: %w", err)
+		}
+
 		users := []User{
-			{Name: "User A", Email: "user_a@crypto.com"},
-			{Name: "User B", Email: "user_a@crypto.com"},
+			{Name: "User A", Email: "user_a@crypto.com", PasswordHash: passwordHash},
+			{Name: "User B", Email: "user_b@crypto.com", PasswordHash: passwordHash},
 		}
 
 		if err := m.db.Create(&users).Error; err != nil {
@@ -92,19 +181,55 @@ func (m *Model) seed() error {
 	}
 
 	var walletCount int64
-	m.db.Model(&Wallet{}).Count(&walletCount)
+	m.db.Model(&Wallet{}).Where("system_account = ?", "").Count(&walletCount)
 	if walletCount == 0 {
-		wallets := []Wallet{
-			{UserId: 1, Balance: 1_000_000_000_000},
-			{UserId: 2, Balance: 1_000_000_000_000},
+		// The demo users, not whoever holds ids 1 and 2, e.g. a bootstrap admin
+		var demoUserIds []uint64
+		if err := m.db.Model(&User{}).Where("email IN ?", []string{"user_a@crypto.com", "user_b@crypto.com"}).Order("id").Pluck("id", &demoUserIds).Error; err != nil {
+			return fmt.Errorf("failed to seed wallets: %w", err)
+		}
+
+		var wallets []Wallet
+		for _, userId := range demoUserIds {
+			wallets = append(wallets, Wallet{UserId: userId, Currency: "USD"}, Wallet{UserId: userId, Currency: "EUR"})
+		}
+
+		if len(wallets) == 0 {
+			return nil
 		}
 
 		if err := m.db.Create(&wallets).Error; err != nil {
 			return fmt.Errorf("failed to seed wallets: %w", err)
 		}
+
+		// Opening balances go through the ledger as deposits, so they tally with the transaction logs
+		for _, wallet := range wallets {
+			if _, err := m.Deposit(context.Background(), wallet.UserId, NewMoney(1_000_000_000_000, wallet.Currency)); err != nil {
+				return fmt.Errorf("failed to seed wallet opening balance: %w", err)
+			}
+		}
 		slog.Info("Wallets seeded successfully")
 	}
 
 	slog.Info("Database seeding completed")
 	return nil
 }
+
+// Creates the first admin, there is no other way to get one on a new database
+// Does nothing if the email is taken, so a restart never resets the password
+func (m *Model) bootstrapAdmin(ctx context.Context, email, password string) error {
+	user, err := m.CreateUser(ctx, "Admin", email, password)
+	if errors.Is(err, ErrEmailTaken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.db.WithContext(ctx).Model(&user).Update("role", USER_ROLE_ADMIN).Error; err != nil {
+		return fmt.Errorf("failed to grant admin role: %w", err)
+	}
+
+	slog.Info("Bootstrap admin created", "user_id", user.Id)
+	return nil
+}
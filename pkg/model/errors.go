@@ -1,28 +1,130 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// For http 4xx client errors
+// For http 4xx client errors, Code is stable for clients to match on and Message is safe to show
 type ClientError struct {
-	Code string
+	Status  int
+	Code    string
+	Message string
 }
 
 var _ = error(&ClientError{})
 
 func (e *ClientError) Error() string {
-	return fmt.Sprintf("[%s]", e.Code)
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// Matches any client error with the same code, so wrapped or re-created errors still compare equal
+func (e *ClientError) Is(target error) bool {
+	t, ok := target.(*ClientError)
+	return ok && t.Code == e.Code
 }
 
-func newClientError(code string) error {
-	return fmt.Errorf("client error: %w", &ClientError{code})
+func newClientError(status int, code, message string) *ClientError {
+	return &ClientError{Status: status, Code: code, Message: message}
 }
 
 var (
-	ErrBadInput            = newClientError("bad_input")
-	ErrInvalidAmount       = newClientError("invalid_amount")
-	ErrUnauthorized        = newClientError("unauthorized")
-	ErrBalanceInsufficient = newClientError("balance_insufficient")
-	ErrSelfTransferInvalid = newClientError("self_transfer_invalid")
+	ErrBadInput            = newClientError(http.StatusBadRequest, "bad_input", "The request is malformed or has invalid parameters")
+	ErrInvalidAmount       = newClientError(http.StatusBadRequest, "invalid_amount", "The amount is invalid")
+	ErrInvalidCursor       = newClientError(http.StatusBadRequest, "invalid_cursor", "The cursor is invalid")
+	ErrMemoTooLong         = newClientError(http.StatusBadRequest, "memo_too_long", fmt.Sprintf("The memo must be at most %d characters", MAX_MEMO_LENGTH))
+	ErrUnsupportedCurrency = newClientError(http.StatusBadRequest, "unsupported_currency", "The currency is not supported")
+	ErrSelfTransferInvalid = newClientError(http.StatusBadRequest, "self_transfer_invalid", "Cannot transfer to yourself")
+
+	ErrUnauthorized       = newClientError(http.StatusUnauthorized, "unauthorized", "Authentication is required")
+	ErrInvalidCredentials = newClientError(http.StatusUnauthorized, "invalid_credentials", "The email or password is incorrect")
+	ErrForbidden          = newClientError(http.StatusForbidden, "forbidden", "Not allowed to access this resource")
+
+	ErrUserNotFound        = newClientError(http.StatusNotFound, "user_not_found", "The user does not exist")
+	ErrWalletNotFound      = newClientError(http.StatusNotFound, "wallet_not_found", "No wallet in this currency")
+	ErrDestinationNotFound = newClientError(http.StatusNotFound, "destination_not_found", "The destination user does not exist or holds no wallet")
+	ErrTransferNotFound    = newClientError(http.StatusNotFound, "transfer_not_found", "The transfer does not exist")
+	ErrTransactionNotFound = newClientError(http.StatusNotFound, "transaction_not_found", "The transaction does not exist")
+
+	ErrWalletFrozen           = newClientError(http.StatusConflict, "wallet_frozen", "The wallet is frozen")
+	ErrWalletClosed           = newClientError(http.StatusGone, "wallet_closed", "The wallet is closed")
+	ErrDestinationUnavailable = newClientError(http.StatusUnprocessableEntity, "destination_unavailable", "The destination wallet cannot receive funds")
+	ErrWalletOpenRestricted   = newClientError(http.StatusForbidden, "wallet_open_restricted", "No new wallet can be opened while another one is frozen or closed")
+
+	ErrBalanceInsufficient = newClientError(http.StatusUnprocessableEntity, "balance_insufficient", "The balance is insufficient")
+	ErrBalanceOverflow     = newClientError(http.StatusUnprocessableEntity, "balance_overflow", "The balance would exceed the maximum amount")
+	ErrAmountOverflow      = newClientError(http.StatusUnprocessableEntity, "amount_overflow", "The amount is out of range")
+	ErrCurrencyMismatch    = newClientError(http.StatusUnprocessableEntity, "currency_mismatch", "The currencies do not match")
+	ErrLimitExceeded       = newClientError(http.StatusUnprocessableEntity, "limit_exceeded", "A transaction limit would be exceeded")
+
+	ErrRateUnavailable = newClientError(http.StatusUnprocessableEntity, "rate_unavailable", "No exchange rate for this currency pair")
+	ErrFxSameCurrency  = newClientError(http.StatusUnprocessableEntity, "fx_same_currency", "The source and destination currencies must differ")
+	ErrFxQuoteNotFound = newClientError(http.StatusNotFound, "fx_quote_not_found", "The quote does not exist")
+	ErrFxQuoteExpired  = newClientError(http.StatusGone, "fx_quote_expired", "The quote has expired, request a new one")
+	ErrFxQuoteUsed     = newClientError(http.StatusConflict, "fx_quote_used", "The quote has already been used")
+
+	ErrEmailTaken = newClientError(http.StatusConflict, "email_taken", "A user with this email already exists")
+
+	ErrTransferAlreadyReplayed = newClientError(http.StatusConflict, "transfer_already_replayed", "The transfer has already been replayed")
+
+	ErrTransactionAlreadyReversed = newClientError(http.StatusConflict, "transaction_already_reversed", "The transaction has already been reversed")
+	ErrTransactionNotReversible   = newClientError(http.StatusUnprocessableEntity, "transaction_not_reversible", "Reversals cannot be reversed")
+
+	ErrHoldNotFound       = newClientError(http.StatusNotFound, "hold_not_found", "The hold does not exist")
+	ErrHoldExpired        = newClientError(http.StatusGone, "hold_expired", "The hold has expired")
+	ErrHoldNotActive      = newClientError(http.StatusConflict, "hold_not_active", "The hold has already been captured or released")
+	ErrCaptureExceedsHold = newClientError(http.StatusUnprocessableEntity, "capture_exceeds_hold", "The amount is more than the hold")
+
+	ErrScheduledTransferNotFound  = newClientError(http.StatusNotFound, "scheduled_transfer_not_found", "The scheduled transfer does not exist")
+	ErrScheduledTransferNotActive = newClientError(http.StatusConflict, "scheduled_transfer_not_active", "The scheduled transfer has already been completed or cancelled")
+	ErrInvalidSchedule            = newClientError(http.StatusUnprocessableEntity, "invalid_schedule", "The schedule is invalid, never runs or runs more than once an hour")
+
+	ErrPaymentRequestNotFound   = newClientError(http.StatusNotFound, "payment_request_not_found", "The payment request does not exist")
+	ErrPaymentRequestExpired    = newClientError(http.StatusGone, "payment_request_expired", "The payment request has expired")
+	ErrPaymentRequestNotPending = newClientError(http.StatusConflict, "payment_request_not_pending", "The payment request has already been accepted, declined or cancelled")
+
+	ErrIdempotencyKeyReused     = newClientError(http.StatusUnprocessableEntity, "idempotency_key_reused", "The idempotency key was used with a different request")
+	ErrIdempotencyKeyInProgress = newClientError(http.StatusConflict, "idempotency_key_in_progress", "A request with this idempotency key is still in progress")
 )
+
+// Postgres error codes worth retrying, the same statement might succeed on the next attempt
+var transientPgErrorCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57014": true, // query_canceled, statement timeout
+}
+
+// Whether the error is caused by contention or timeouts rather than the request itself
+// Client errors are never transient
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	clientErr := &ClientError{}
+	if errors.As(err, &clientErr) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientPgErrorCodes[pgErr.Code]
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientErrorIs(t *testing.T) {
+	wrapped := fmt.Errorf("failed to transfer: %w", ErrBalanceInsufficient)
+	assert.True(t, errors.Is(wrapped, ErrBalanceInsufficient))
+	assert.False(t, errors.Is(wrapped, ErrInvalidAmount))
+
+	// Same code compares equal even when it is not the same value
+	assert.True(t, errors.Is(&ClientError{Code: "balance_insufficient"}, ErrBalanceInsufficient))
+
+	clientErr := &ClientError{}
+	assert.True(t, errors.As(wrapped, &clientErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, clientErr.Status)
+	assert.Equal(t, "balance_insufficient", clientErr.Code)
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    *ClientError
+		status int
+	}{
+		{ErrBadInput, http.StatusBadRequest},
+		{ErrUnauthorized, http.StatusUnauthorized},
+		{ErrUserNotFound, http.StatusNotFound},
+		{ErrWalletNotFound, http.StatusNotFound},
+		{ErrFxQuoteUsed, http.StatusConflict},
+		{ErrWalletFrozen, http.StatusConflict},
+		{ErrWalletClosed, http.StatusGone},
+		{ErrIdempotencyKeyInProgress, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Code, func(t *testing.T) {
+			assert.Equal(t, tt.status, tt.err.Status)
+			assert.NotEmpty(t, tt.err.Message)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How long a quoted rate is honoured
+	FX_QUOTE_TTL = 30 * time.Second
+
+	// Charged on the source amount, 50 basis points = 0.5%
+	FX_SPREAD_BPS = 50
+)
+
+// Locks in a rate for a user, used once by an FX transfer before it expires
+// Amounts are in minor units, DestAmount is what the recipient gets after the spread
+type FxQuote struct {
+	Base
+	QuoteUUID      string `gorm:"uniqueIndex" json:"quote_uuid"`
+	UserId         uint64 `gorm:"index" json:"user_id"`
+	SourceCurrency string `json:"source_currency"`
+	DestCurrency   string `json:"dest_currency"`
+
+	// Mid market rate in major units, informational only, the transfer moves the quoted amounts
+	Rate         string `json:"rate"`
+	SpreadBps    int64  `json:"spread_bps"`
+	SourceAmount Money  `json:"source_amount"`
+	SpreadAmount Money  `json:"spread_amount"`
+	DestAmount   Money  `json:"dest_amount"`
+
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+
+	// Journal entry of the FX transfer that used the quote
+	TransactionUUID string `json:"transaction_uuid"`
+}
+
+func (*FxQuote) TableName() string {
+	return "fx_quotes"
+}
+
+func (q *FxQuote) AfterFind(tx *gorm.DB) error {
+	q.SourceAmount.Currency = q.SourceCurrency
+	q.SpreadAmount.Currency = q.SourceCurrency
+	q.DestAmount.Currency = q.DestCurrency
+	return nil
+}
+
+func (m *Model) CreateFxQuote(ctx context.Context, userId uint64, sourceAmount Money, destCurrency string) (FxQuote, error) {
+	var quote FxQuote
+
+	sourceAmount, err := validateAmount(sourceAmount)
+	if err != nil {
+		return quote, err
+	}
+
+	from, err := ParseCurrency(sourceAmount.Currency)
+	if err != nil {
+		return quote, err
+	}
+
+	to, err := ParseCurrency(destCurrency)
+	if err != nil {
+		return quote, err
+	}
+
+	if from.Code == to.Code {
+		return quote, ErrFxSameCurrency
+	}
+
+	if m.rates == nil {
+		return quote, ErrRateUnavailable
+	}
+
+	rate, err := m.rates.GetRate(ctx, from.Code, to.Code)
+	if err != nil {
+		return quote, err
+	}
+
+	spreadAmount := spreadOf(sourceAmount, FX_SPREAD_BPS)
+
+	convertedAmount, err := sourceAmount.Sub(spreadAmount)
+	if err != nil {
+		return quote, err
+	}
+
+	destAmount, err := convertAmount(convertedAmount, rate, from, to)
+	if err != nil {
+		return quote, err
+	}
+
+	quote = FxQuote{
+		QuoteUUID:      uuid.New().String(),
+		UserId:         userId,
+		SourceCurrency: from.Code,
+		DestCurrency:   to.Code,
+		Rate:           rate.FloatString(8),
+		SpreadBps:      FX_SPREAD_BPS,
+		SourceAmount:   sourceAmount,
+		SpreadAmount:   spreadAmount,
+		DestAmount:     destAmount,
+		ExpiresAt:      time.Now().Add(FX_QUOTE_TTL),
+	}
+
+	if err := m.db.WithContext(ctx).Create(&quote).Error; err != nil {
+		return quote, fmt.Errorf("failed to create fx quote: %w", err)
+	}
+
+	return quote, nil
+}
+
+// Debits the quoted source amount from the source user and credits the quoted dest amount to the dest user
+// Both currencies go through the fx_clearing account and the spread is kept in the fx_spread account
+func (m *Model) TransferFx(ctx context.Context, sourceUserId, destUserId uint64, quoteUUID string) (FxQuote, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var quote FxQuote
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		// Locked so concurrent transfers cannot both use the quote
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("quote_uuid = ? AND user_id = ?", quoteUUID, sourceUserId).
+			First(&quote).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFxQuoteNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if quote.UsedAt != nil {
+			return ErrFxQuoteUsed
+		}
+
+		if time.Now().After(quote.ExpiresAt) {
+			return ErrFxQuoteExpired
+		}
+
+		lg.Info(fmt.Sprintf("Starts transferring %s from user_id %d as %s to user_id %d",
+			quote.SourceAmount, sourceUserId, quote.DestAmount, destUserId))
+
+		if err := checkDestination(tx, destUserId, quote.DestCurrency); err != nil {
+			return err
+		}
+
+		wallets, err := lockWallets(tx,
+			walletKey{UserId: sourceUserId, Currency: quote.SourceCurrency},
+			walletKey{UserId: destUserId, Currency: quote.DestCurrency},
+		)
+		if err != nil {
+			return err
+		}
+		sourceWallet, destWallet := wallets[0], wallets[1]
+
+		if err := checkTransferWallets(&sourceWallet, &destWallet); err != nil {
+			return err
+		}
+
+		if err := checkSufficient(sourceWallet.AvailableBalance, quote.SourceAmount); err != nil {
+			return err
+		}
+
+		// Limited like any other transfer, FX must not be a way around them
+		if err := checkLimits(tx, sourceUserId, sourceWallet, TRANSACTION_TYPE_TRANSFER, quote.SourceAmount); err != nil {
+			return err
+		}
+
+		sourceDebit, err := quote.SourceAmount.Neg()
+		if err != nil {
+			return err
+		}
+
+		destDebit, err := quote.DestAmount.Neg()
+		if err != nil {
+			return err
+		}
+
+		clearingCredit, err := quote.SourceAmount.Sub(quote.SpreadAmount)
+		if err != nil {
+			return err
+		}
+
+		sourceClearing, err := systemWallet(tx, SYSTEM_ACCOUNT_FX_CLEARING, quote.SourceCurrency)
+		if err != nil {
+			return err
+		}
+
+		destClearing, err := systemWallet(tx, SYSTEM_ACCOUNT_FX_CLEARING, quote.DestCurrency)
+		if err != nil {
+			return err
+		}
+
+		// Each currency balances on its own, fx_clearing takes the position between them
+		// User postings name each other as counterparty, so they show up in history filtered by counterparty
+		postings := []Posting{
+			{WalletId: sourceWallet.Id, CounterpartyWalletId: destWallet.Id, Amount: sourceDebit},
+			{WalletId: sourceClearing.Id, CounterpartyWalletId: sourceWallet.Id, Amount: clearingCredit},
+			{WalletId: destClearing.Id, CounterpartyWalletId: destWallet.Id, Amount: destDebit},
+			{WalletId: destWallet.Id, CounterpartyWalletId: sourceWallet.Id, Amount: quote.DestAmount},
+		}
+
+		// Too small an amount to charge a spread on
+		if quote.SpreadAmount.IsPositive() {
+			spreadWallet, err := systemWallet(tx, SYSTEM_ACCOUNT_FX_SPREAD, quote.SourceCurrency)
+			if err != nil {
+				return err
+			}
+
+			postings = append(postings, Posting{WalletId: spreadWallet.Id, CounterpartyWalletId: sourceWallet.Id, Amount: quote.SpreadAmount})
+		}
+
+		entry, err := postJournalEntry(tx, TRANSACTION_TYPE_FX_TRANSFER, postings...)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		quote.UsedAt = &now
+		quote.TransactionUUID = entry.TransactionUUID
+
+		return tx.Model(&quote).Updates(map[string]interface{}{
+			"used_at":          quote.UsedAt,
+			"transaction_uuid": quote.TransactionUUID,
+		}).Error
+	})
+
+	return quote, err
+}
+
+// Converts minor units of one currency into minor units of another, rounded down in favour of the house
+func convertAmount(amount Money, rate *big.Rat, from, to Currency) (Money, error) {
+	converted := new(big.Rat).SetInt64(amount.Amount)
+	converted.Mul(converted, rate)
+	converted.Mul(converted, new(big.Rat).SetFrac(pow10(to.MinorUnits), pow10(from.MinorUnits)))
+
+	destAmount := new(big.Int).Quo(converted.Num(), converted.Denom())
+	if !destAmount.IsInt64() {
+		return Money{Currency: to.Code}, ErrAmountOverflow
+	}
+	if destAmount.Int64() < 1 {
+		return Money{Currency: to.Code}, ErrInvalidAmount
+	}
+
+	return NewMoney(destAmount.Int64(), to.Code), nil
+}
+
+// Basis points of the amount rounded down, split so the multiplication cannot overflow
+func spreadOf(amount Money, bps int64) Money {
+	spread := amount.Amount/10_000*bps + amount.Amount%10_000*bps/10_000
+	return NewMoney(spread, amount.Currency)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupFxUsers(t *testing.T, model *Model) (User, User) {
+	source := User{Name: "User A", Email: "user_a@crypto.com"}
+	dest := User{Name: "User B", Email: "user_b@crypto.com"}
+	assert.NoError(t, model.db.Create(&source).Error)
+	assert.NoError(t, model.db.Create(&dest).Error)
+
+	_, err := model.Deposit(context.Background(), source.Id, NewMoney(10_000, "USD"))
+	assert.NoError(t, err)
+	_, err = model.Deposit(context.Background(), dest.Id, NewMoney(1, "JPY"))
+	assert.NoError(t, err)
+
+	return source, dest
+}
+
+func newFxTestModel(t *testing.T) (*Model, func()) {
+	db, cleanup := setupTestDB(t)
+
+	rates, err := NewStaticRateProvider(map[string]string{"USD/JPY": "150", "USD/EUR": "0.9"})
+	assert.NoError(t, err)
+
+	return &Model{db: db, rates: rates}, cleanup
+}
+
+func TestTransferFx(t *testing.T) {
+	model, cleanup := newFxTestModel(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	source, dest := setupFxUsers(t, model)
+
+	// $100.00 less 0.5% spread is $99.50, at 150 JPY per USD
+	quote, err := model.CreateFxQuote(ctx, source.Id, NewMoney(10_000, "USD"), "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), quote.SpreadAmount.Amount)
+	assert.Equal(t, int64(14_925), quote.DestAmount.Amount)
+
+	_, err = model.TransferFx(ctx, source.Id, dest.Id, quote.QuoteUUID)
+	assert.NoError(t, err)
+
+	balance, err := model.GetWalletBalance(ctx, source.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), balance.Amount)
+
+	balance, err = model.GetWalletBalance(ctx, dest.Id, "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(14_926), balance.Amount)
+
+	var spread Wallet
+	assert.NoError(t, model.db.Where("system_account = ? AND currency = ?", SYSTEM_ACCOUNT_FX_SPREAD, "USD").First(&spread).Error)
+	assert.Equal(t, int64(50), spread.Balance.Amount)
+
+	// Every currency still sums up to zero across all wallets
+	for _, currency := range []string{"USD", "JPY"} {
+		var total int64
+		model.db.Model(&Wallet{}).Select("SUM(balance)").Where("currency = ?", currency).Scan(&total)
+		assert.Equal(t, int64(0), total, "%s wallets do not sum to zero", currency)
+	}
+
+	// Quotes are single use
+	_, err = model.TransferFx(ctx, source.Id, dest.Id, quote.QuoteUUID)
+	assert.True(t, errors.Is(err, ErrFxQuoteUsed), "expected ErrFxQuoteUsed, got %v", err)
+}
+
+func TestTransferFxRejectsInvalidQuote(t *testing.T) {
+	model, cleanup := newFxTestModel(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	source, dest := setupFxUsers(t, model)
+
+	quote, err := model.CreateFxQuote(ctx, source.Id, NewMoney(1_000, "USD"), "JPY")
+	assert.NoError(t, err)
+
+	// Only the user who asked for the quote can use it
+	_, err = model.TransferFx(ctx, dest.Id, source.Id, quote.QuoteUUID)
+	assert.True(t, errors.Is(err, ErrFxQuoteNotFound), "expected ErrFxQuoteNotFound, got %v", err)
+
+	assert.NoError(t, model.db.Model(&quote).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	_, err = model.TransferFx(ctx, source.Id, dest.Id, quote.QuoteUUID)
+	assert.True(t, errors.Is(err, ErrFxQuoteExpired), "expected ErrFxQuoteExpired, got %v", err)
+
+	// Recipient holds no EUR wallet
+	quote, err = model.CreateFxQuote(ctx, source.Id, NewMoney(1_000, "USD"), "EUR")
+	assert.NoError(t, err)
+	_, err = model.TransferFx(ctx, source.Id, dest.Id, quote.QuoteUUID)
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch), "expected ErrCurrencyMismatch, got %v", err)
+
+	balance, err := model.GetWalletBalance(ctx, source.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10_000), balance.Amount)
+
+	_, err = model.CreateFxQuote(ctx, source.Id, NewMoney(1_000, "USD"), "GBP")
+	assert.True(t, errors.Is(err, ErrRateUnavailable), "expected ErrRateUnavailable, got %v", err)
+
+	_, err = model.CreateFxQuote(ctx, source.Id, NewMoney(1_000, "USD"), "USD")
+	assert.True(t, errors.Is(err, ErrFxSameCurrency), "expected ErrFxSameCurrency, got %v", err)
+}
+
+func TestTransferFxLimits(t *testing.T) {
+	model, cleanup := newFxTestModel(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	source, dest := setupFxUsers(t, model)
+
+	_, err := model.SetTransactionLimit(ctx, TransactionLimit{UserId: source.Id, Currency: "USD", MaxSingleTransfer: NewMoney(5_000, "USD")})
+	assert.NoError(t, err)
+
+	quote, err := model.CreateFxQuote(ctx, source.Id, NewMoney(6_000, "USD"), "JPY")
+	assert.NoError(t, err)
+
+	_, err = model.TransferFx(ctx, source.Id, dest.Id, quote.QuoteUUID)
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+
+	balance, err := model.GetWalletBalance(ctx, source.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10_000), balance.Amount)
+}
+
+func TestConvertAmount(t *testing.T) {
+	usd, _ := ParseCurrency("USD")
+	jpy, _ := ParseCurrency("JPY")
+	bhd, _ := ParseCurrency("BHD")
+
+	tests := []struct {
+		amount   int64
+		rate     string
+		from, to Currency
+		want     int64
+		err      error
+	}{
+		{100, "150", usd, jpy, 150, nil},
+		{150, "1/150", jpy, usd, 100, nil},
+		{999, "0.376", usd, bhd, 3_756, nil},
+		{1, "1/150", jpy, usd, 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		got, err := convertAmount(NewMoney(tt.amount, tt.from.Code), rate, tt.from, tt.to)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%d %s at %s: expected error %v, got %v", tt.amount, tt.from.Code, tt.rate, tt.err, err)
+			continue
+		}
+		if got.Amount != tt.want {
+			t.Errorf("%d %s at %s: expected %d %s, got %d", tt.amount, tt.from.Code, tt.rate, tt.want, tt.to.Code, got.Amount)
+		}
+	}
+}
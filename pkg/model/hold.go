@@ -0,0 +1,349 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	HOLD_DEFAULT_TTL = 7 * 24 * time.Hour
+	HOLD_MAX_TTL     = 30 * 24 * time.Hour
+
+	// Expired holds released per scheduler run
+	HOLD_EXPIRE_BATCH_SIZE = 100
+)
+
+type HoldStatus int
+
+const (
+	HOLD_STATUS_ACTIVE HoldStatus = iota + 1
+	HOLD_STATUS_CAPTURED
+	HOLD_STATUS_RELEASED
+	HOLD_STATUS_EXPIRED
+)
+
+func (s HoldStatus) String() string {
+	switch s {
+	case HOLD_STATUS_ACTIVE:
+		return "active"
+	case HOLD_STATUS_CAPTURED:
+		return "captured"
+	case HOLD_STATUS_RELEASED:
+		return "released"
+	case HOLD_STATUS_EXPIRED:
+		return "expired"
+	default:
+		return "-"
+	}
+}
+
+// Funds of a user reserved for a merchant, card-style authorization
+// While active the amount is taken out of the wallet's available balance, the balance itself is untouched
+// The merchant captures all or part of it as a transfer, whatever is not captured goes back to available
+type Hold struct {
+	Base
+	HoldUUID       string     `gorm:"uniqueIndex" json:"hold_uuid"`
+	UserId         uint64     `gorm:"index" json:"user_id"`
+	MerchantUserId uint64     `gorm:"index" json:"merchant_user_id"`
+	WalletId       uint64     `json:"wallet_id"`
+	Currency       string     `json:"currency"`
+	Amount         Money      `json:"amount"`
+	CapturedAmount Money      `json:"captured_amount"`
+	Memo           string     `json:"memo"`
+	Status         HoldStatus `gorm:"index:idx_holds_status_expires_at" json:"status"`
+	ExpiresAt      time.Time  `gorm:"index:idx_holds_status_expires_at" json:"expires_at"`
+
+	// Journal entry of the transfer the hold was captured into
+	TransactionUUID string `json:"transaction_uuid,omitempty"`
+}
+
+func (*Hold) TableName() string {
+	return "holds"
+}
+
+func (h *Hold) AfterFind(tx *gorm.DB) error {
+	h.Amount.Currency = h.Currency
+	h.CapturedAmount.Currency = h.Currency
+	return nil
+}
+
+// Reserves amount of the user's available balance for the merchant until expiresAt
+// Checked like a transfer to the merchant, so a hold that could never be captured is refused up front
+func (m *Model) PlaceHold(ctx context.Context, userId, merchantUserId uint64, amount Money, expiresAt time.Time, memo string) (Hold, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var hold Hold
+
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return hold, err
+	}
+
+	if userId == merchantUserId {
+		return hold, ErrSelfTransferInvalid
+	}
+
+	if !expiresAt.After(time.Now()) || time.Until(expiresAt) > HOLD_MAX_TTL {
+		return hold, ErrBadInput
+	}
+
+	memo, err = normalizeMemo(memo)
+	if err != nil {
+		return hold, err
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkDestination(tx, merchantUserId, amount.Currency); err != nil {
+			return err
+		}
+
+		// Merchant wallet too, a hold for a frozen or closed merchant could never be captured
+		wallet, merchantWallet, err := LockWalletsBalanceByUserId(ctx, userId, merchantUserId, amount.Currency, tx)
+		if err != nil {
+			return err
+		}
+
+		if err := checkTransferWallets(&wallet, &merchantWallet); err != nil {
+			return err
+		}
+
+		if err := checkSufficient(wallet.AvailableBalance, amount); err != nil {
+			return err
+		}
+
+		if err := checkLimits(tx, userId, wallet, TRANSACTION_TYPE_TRANSFER, amount); err != nil {
+			return err
+		}
+
+		hold = Hold{
+			HoldUUID:       uuid.New().String(),
+			UserId:         userId,
+			MerchantUserId: merchantUserId,
+			WalletId:       wallet.Id,
+			Currency:       amount.Currency,
+			Amount:         amount,
+			CapturedAmount: Money{Currency: amount.Currency},
+			Memo:           memo,
+			Status:         HOLD_STATUS_ACTIVE,
+			ExpiresAt:      expiresAt,
+		}
+		if err := tx.Create(&hold).Error; err != nil {
+			return err
+		}
+
+		debit, err := amount.Neg()
+		if err != nil {
+			return err
+		}
+
+		return adjustAvailableBalance(tx, wallet.Id, debit)
+	})
+	if err != nil {
+		return hold, fmt.Errorf("failed to place hold: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("hold %s of %s placed by user_id %d for user_id %d", hold.HoldUUID, amount, userId, merchantUserId))
+
+	return hold, nil
+}
+
+// Either side of the hold can look it up
+func (m *Model) GetHold(ctx context.Context, userId uint64, holdUUID string) (Hold, error) {
+	var hold Hold
+
+	err := m.db.WithContext(ctx).Where("hold_uuid = ? AND (user_id = ? OR merchant_user_id = ?)", holdUUID, userId, userId).First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, ErrHoldNotFound
+	}
+	if err != nil {
+		return hold, fmt.Errorf("failed to get hold: %w", err)
+	}
+
+	return hold, nil
+}
+
+// Merchant only, transfers amount of the hold to the merchant and releases the rest, a zero amount captures it all
+// The hold is released and the transfer made in the same DB transaction, so the funds are never spendable in between
+func (m *Model) CaptureHold(ctx context.Context, merchantUserId uint64, holdUUID string, amount Money) (Hold, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var hold Hold
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		hold, err = lockActiveHold(tx, holdUUID)
+		if err != nil {
+			return err
+		}
+
+		if hold.MerchantUserId != merchantUserId {
+			return ErrHoldNotFound
+		}
+
+		if amount.IsZero() {
+			amount = hold.Amount
+		}
+
+		if amount.Currency == "" {
+			amount.Currency = hold.Currency
+		}
+
+		if !amount.IsPositive() {
+			return ErrInvalidAmount
+		}
+
+		cmp, err := amount.Cmp(hold.Amount)
+		if err != nil {
+			return err
+		}
+		if cmp > 0 {
+			return ErrCaptureExceedsHold
+		}
+
+		// Both wallets locked in the usual order before the payer's available balance is touched
+		if _, _, err := LockWalletsBalanceByUserId(ctx, hold.UserId, hold.MerchantUserId, hold.Currency, tx); err != nil {
+			return err
+		}
+
+		if err := adjustAvailableBalance(tx, hold.WalletId, hold.Amount); err != nil {
+			return err
+		}
+
+		// Checked against the limits when the hold was placed, the capture is never more than that
+		entry, err := m.postTransfer(ctx, tx, hold.UserId, hold.MerchantUserId, amount, hold.Memo, false)
+		if err != nil {
+			return err
+		}
+
+		hold.Status = HOLD_STATUS_CAPTURED
+		hold.CapturedAmount = amount
+		hold.TransactionUUID = entry.TransactionUUID
+
+		return tx.Model(&hold).Updates(map[string]interface{}{
+			"status":           hold.Status,
+			"captured_amount":  hold.CapturedAmount,
+			"transaction_uuid": hold.TransactionUUID,
+		}).Error
+	})
+	if err != nil {
+		return hold, fmt.Errorf("failed to capture hold: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("hold %s captured %s of %s", holdUUID, amount, hold.Amount))
+
+	return hold, nil
+}
+
+// Either side of the hold can release it, the whole amount goes back to the payer's available balance
+func (m *Model) ReleaseHold(ctx context.Context, userId uint64, holdUUID string) (Hold, error) {
+	var hold Hold
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		hold, err = lockActiveHold(tx, holdUUID)
+		if err != nil {
+			return err
+		}
+
+		if hold.UserId != userId && hold.MerchantUserId != userId {
+			return ErrHoldNotFound
+		}
+
+		return releaseHold(tx, &hold, HOLD_STATUS_RELEASED)
+	})
+	if err != nil {
+		return hold, fmt.Errorf("failed to release hold: %w", err)
+	}
+
+	return hold, nil
+}
+
+// Releases active holds past their expiry, one DB transaction per hold so one failure does not hold back the rest
+// Returns the payers whose available balance went back up, once each, so their cached balances can be invalidated
+func (m *Model) ExpireHolds(ctx context.Context, now time.Time) ([]uint64, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var holdUUIDs []string
+	if err := m.db.WithContext(ctx).
+		Model(&Hold{}).
+		Where("status = ? AND expires_at <= ?", HOLD_STATUS_ACTIVE, now).
+		Order("expires_at").
+		Limit(HOLD_EXPIRE_BATCH_SIZE).
+		Pluck("hold_uuid", &holdUUIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get expired holds: %w", err)
+	}
+
+	var userIds []uint64
+	for _, holdUUID := range holdUUIDs {
+		var hold Hold
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hold_uuid = ?", holdUUID).First(&hold).Error
+			if err != nil {
+				return err
+			}
+
+			// Captured or released since it was picked up
+			if hold.Status != HOLD_STATUS_ACTIVE {
+				return nil
+			}
+
+			return releaseHold(tx, &hold, HOLD_STATUS_EXPIRED)
+		})
+		if err != nil {
+			lg.Error(fmt.Sprintf("failed to expire hold %s: %v", holdUUID, err))
+			continue
+		}
+
+		if hold.Status == HOLD_STATUS_EXPIRED && !slices.Contains(userIds, hold.UserId) {
+			userIds = append(userIds, hold.UserId)
+		}
+	}
+
+	return userIds, nil
+}
+
+// Row locks the hold, captures and releases of the same hold queue up behind each other
+func lockActiveHold(tx *gorm.DB, holdUUID string) (Hold, error) {
+	var hold Hold
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hold_uuid = ?", holdUUID).First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, ErrHoldNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+
+	if hold.Status != HOLD_STATUS_ACTIVE {
+		return hold, ErrHoldNotActive
+	}
+
+	// Not released by the scheduler yet, but no longer capturable
+	if !time.Now().Before(hold.ExpiresAt) {
+		return hold, ErrHoldExpired
+	}
+
+	return hold, nil
+}
+
+func releaseHold(tx *gorm.DB, hold *Hold, status HoldStatus) error {
+	if err := adjustAvailableBalance(tx, hold.WalletId, hold.Amount); err != nil {
+		return err
+	}
+
+	hold.Status = status
+	return tx.Model(hold).Update("status", hold.Status).Error
+}
+
+// Moves the wallet's available balance by the amount, its balance stays as it is
+func adjustAvailableBalance(tx *gorm.DB, walletId uint64, amount Money) error {
+	return tx.Model(&Wallet{}).Where("id = ?", walletId).Update("available_balance", gorm.Expr("available_balance + ?", amount.Amount)).Error
+}
@@ -0,0 +1,328 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHolds(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	payer := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	merchant := User{
+		Name:  "Merchant",
+		Email: "merchant@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	other := User{
+		Name:  "User C",
+		Email: "user_c@crypto.com",
+	}
+	for _, user := range []*User{&payer, &merchant, &other} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	walletOf := func(walletId uint64) Wallet {
+		var wallet Wallet
+		assert.NoError(t, db.First(&wallet, walletId).Error)
+		return wallet
+	}
+
+	hold, err := model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(600, "USD"), expiresAt, "Hotel")
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_ACTIVE, hold.Status)
+
+	wallet := walletOf(payer.Wallets[0].Id)
+	assert.Equal(t, int64(1000), wallet.Balance.Amount)
+	assert.Equal(t, int64(400), wallet.AvailableBalance.Amount)
+
+	// Held funds cannot be spent elsewhere
+	_, err = model.Withdraw(ctx, payer.Id, NewMoney(500, "USD"))
+	assert.True(t, errors.Is(err, ErrBalanceInsufficient), "expected ErrBalanceInsufficient, got %v", err)
+
+	err = model.ValidateTransfer(ctx, payer.Id, merchant.Id, NewMoney(500, "USD"))
+	assert.True(t, errors.Is(err, ErrBalanceInsufficient), "expected ErrBalanceInsufficient, got %v", err)
+
+	_, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(500, "USD"), expiresAt, "")
+	assert.True(t, errors.Is(err, ErrBalanceInsufficient), "expected ErrBalanceInsufficient, got %v", err)
+
+	tests := []struct {
+		name           string
+		merchantUserId uint64
+		amount         Money
+		want           error
+	}{
+		{"Not the merchant", payer.Id, Money{}, ErrHoldNotFound},
+		{"More than held", merchant.Id, NewMoney(601, "USD"), ErrCaptureExceedsHold},
+		{"Negative amount", merchant.Id, NewMoney(-1, "USD"), ErrInvalidAmount},
+		{"Other currency", merchant.Id, NewMoney(100, "EUR"), ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.CaptureHold(ctx, tt.merchantUserId, hold.HoldUUID, tt.amount)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// Partial capture, the rest goes back to available
+	hold, err = model.CaptureHold(ctx, merchant.Id, hold.HoldUUID, NewMoney(450, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_CAPTURED, hold.Status)
+	assert.Equal(t, int64(450), hold.CapturedAmount.Amount)
+	assert.NotEmpty(t, hold.TransactionUUID)
+
+	wallet = walletOf(payer.Wallets[0].Id)
+	assert.Equal(t, int64(550), wallet.Balance.Amount)
+	assert.Equal(t, int64(550), wallet.AvailableBalance.Amount)
+
+	wallet = walletOf(merchant.Wallets[0].Id)
+	assert.Equal(t, int64(450), wallet.Balance.Amount)
+	assert.Equal(t, int64(450), wallet.AvailableBalance.Amount)
+
+	entry, postings, err := model.GetJournalEntry(ctx, hold.TransactionUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSACTION_TYPE_TRANSFER, entry.Type)
+	assert.Len(t, postings, 2)
+	assert.Equal(t, "Hotel", postings[0].Memo)
+
+	_, err = model.CaptureHold(ctx, merchant.Id, hold.HoldUUID, Money{})
+	assert.True(t, errors.Is(err, ErrHoldNotActive), "expected ErrHoldNotActive, got %v", err)
+
+	_, err = model.ReleaseHold(ctx, payer.Id, hold.HoldUUID)
+	assert.True(t, errors.Is(err, ErrHoldNotActive), "expected ErrHoldNotActive, got %v", err)
+
+	// Released by the payer
+	hold, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(200, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(350), walletOf(payer.Wallets[0].Id).AvailableBalance.Amount)
+
+	_, err = model.ReleaseHold(ctx, other.Id, hold.HoldUUID)
+	assert.True(t, errors.Is(err, ErrHoldNotFound), "expected ErrHoldNotFound, got %v", err)
+
+	_, err = model.GetHold(ctx, other.Id, hold.HoldUUID)
+	assert.True(t, errors.Is(err, ErrHoldNotFound), "expected ErrHoldNotFound, got %v", err)
+
+	hold, err = model.ReleaseHold(ctx, payer.Id, hold.HoldUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_RELEASED, hold.Status)
+	assert.Equal(t, int64(550), walletOf(payer.Wallets[0].Id).AvailableBalance.Amount)
+
+	hold, err = model.GetHold(ctx, merchant.Id, hold.HoldUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_RELEASED, hold.Status)
+	assert.Equal(t, int64(0), hold.CapturedAmount.Amount)
+
+	_, err = model.PlaceHold(ctx, payer.Id, other.Id, NewMoney(100, "USD"), expiresAt, "")
+	assert.True(t, errors.Is(err, ErrDestinationNotFound), "expected ErrDestinationNotFound, got %v", err)
+
+	_, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(100, "USD"), time.Now().Add(-time.Minute), "")
+	assert.True(t, errors.Is(err, ErrBadInput), "expected ErrBadInput, got %v", err)
+
+	_, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(100, "USD"), time.Now().Add(HOLD_MAX_TTL+time.Hour), "")
+	assert.True(t, errors.Is(err, ErrBadInput), "expected ErrBadInput, got %v", err)
+}
+
+func TestPlaceHoldMerchantUnavailable(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	payer := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	merchant := User{
+		Name:  "Merchant",
+		Email: "merchant@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&payer, &merchant} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	for _, status := range []WalletStatus{WALLET_STATUS_FROZEN, WALLET_STATUS_CLOSED} {
+		t.Run(status.String(), func(t *testing.T) {
+			assert.NoError(t, db.Model(&Wallet{}).Where("id = ?", merchant.Wallets[0].Id).Update("status", status).Error)
+
+			_, err := model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(100, "USD"), expiresAt, "")
+			assert.True(t, errors.Is(err, ErrDestinationUnavailable), "expected ErrDestinationUnavailable, got %v", err)
+		})
+	}
+
+	// Nothing reserved
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, payer.Wallets[0].Id).Error)
+	assert.Equal(t, int64(1000), wallet.AvailableBalance.Amount)
+
+	var count int64
+	db.Model(&Hold{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestHoldLimits(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	payer := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(2000, "USD")},
+		},
+	}
+	merchant := User{
+		Name:  "Merchant",
+		Email: "merchant@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&payer, &merchant} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, err := model.SetTransactionLimit(ctx, TransactionLimit{UserId: payer.Id, Currency: "USD", DailyOutgoing: NewMoney(1000, "USD")})
+	assert.NoError(t, err)
+
+	hold, err := model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(700, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+
+	// Active holds count towards the daily outgoing limit
+	_, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(400, "USD"), expiresAt, "")
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+
+	err = model.ValidateTransfer(ctx, payer.Id, merchant.Id, NewMoney(400, "USD"))
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+
+	_, err = model.Withdraw(ctx, payer.Id, NewMoney(400, "USD"))
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+
+	// The reserved amount is not counted twice on capture
+	hold, err = model.CaptureHold(ctx, merchant.Id, hold.HoldUUID, Money{})
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_CAPTURED, hold.Status)
+
+	assert.NoError(t, model.ValidateTransfer(ctx, payer.Id, merchant.Id, NewMoney(300, "USD")))
+
+	err = model.ValidateTransfer(ctx, payer.Id, merchant.Id, NewMoney(301, "USD"))
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+
+	// Released holds no longer count
+	hold, err = model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(300, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+
+	_, err = model.ReleaseHold(ctx, payer.Id, hold.HoldUUID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, model.ValidateTransfer(ctx, payer.Id, merchant.Id, NewMoney(300, "USD")))
+}
+
+func TestExpireHolds(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	payer := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	merchant := User{
+		Name:  "Merchant",
+		Email: "merchant@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&payer, &merchant} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+
+	shortHold, err := model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(300, "USD"), time.Now().Add(time.Minute), "")
+	assert.NoError(t, err)
+
+	longHold, err := model.PlaceHold(ctx, payer.Id, merchant.Id, NewMoney(200, "USD"), time.Now().Add(time.Hour), "")
+	assert.NoError(t, err)
+
+	// Past its expiry but not released yet
+	assert.NoError(t, db.Model(&Hold{}).Where("id = ?", shortHold.Id).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+	_, err = model.CaptureHold(ctx, merchant.Id, shortHold.HoldUUID, Money{})
+	assert.True(t, errors.Is(err, ErrHoldExpired), "expected ErrHoldExpired, got %v", err)
+
+	userIds, err := model.ExpireHolds(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{payer.Id}, userIds)
+
+	shortHold, err = model.GetHold(ctx, payer.Id, shortHold.HoldUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_EXPIRED, shortHold.Status)
+
+	longHold, err = model.GetHold(ctx, payer.Id, longHold.HoldUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, HOLD_STATUS_ACTIVE, longHold.Status)
+
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, payer.Wallets[0].Id).Error)
+	assert.Equal(t, int64(1000), wallet.Balance.Amount)
+	assert.Equal(t, int64(800), wallet.AvailableBalance.Amount)
+
+	// Nothing left to expire
+	userIds, err = model.ExpireHolds(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, userIds)
+}
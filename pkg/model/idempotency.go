@@ -0,0 +1,87 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Stored response of a money moving request, replayed when the client retries with the same Idempotency-Key
+type IdempotencyKey struct {
+	Base
+	UserId      uint64 `gorm:"uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string `gorm:"uniqueIndex:idx_idempotency_keys_user_key"`
+	RequestHash string
+	// 0 while the first request is still in flight
+	StatusCode int
+	Response   []byte
+}
+
+func (*IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+// Reserves the key for the request, or returns the stored record if the key was used before
+// Returned record with StatusCode 0 means the caller owns the key and should process the request
+func (m *Model) ReserveIdempotencyKey(ctx context.Context, userId uint64, key, requestHash string) (IdempotencyKey, error) {
+	record := IdempotencyKey{
+		UserId:      userId,
+		Key:         key,
+		RequestHash: requestHash,
+	}
+
+	result := m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return record, fmt.Errorf("failed to reserve idempotency key: %w", result.Error)
+	}
+
+	if result.RowsAffected == 1 {
+		return record, nil
+	}
+
+	var existing IdempotencyKey
+	if err := m.db.WithContext(ctx).Where("user_id = ? AND key = ?", userId, key).First(&existing).Error; err != nil {
+		return existing, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	if existing.RequestHash != requestHash {
+		return existing, ErrIdempotencyKeyReused
+	}
+
+	if existing.StatusCode == 0 {
+		return existing, ErrIdempotencyKeyInProgress
+	}
+
+	return existing, nil
+}
+
+func (m *Model) CompleteIdempotencyKey(ctx context.Context, record IdempotencyKey, statusCode int, response []byte) error {
+	if err := m.db.WithContext(ctx).Model(&record).Updates(IdempotencyKey{
+		StatusCode: statusCode,
+		Response:   response,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// Frees the key so the client can retry, used when the request failed without moving money
+func (m *Model) ReleaseIdempotencyKey(ctx context.Context, record IdempotencyKey) error {
+	if err := m.db.WithContext(ctx).Delete(&record).Error; err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Model) PurgeIdempotencyKeys(ctx context.Context, olderThan time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Where("created_at < ?", olderThan).Delete(&IdempotencyKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	ctx := context.Background()
+
+	record, err := model.ReserveIdempotencyKey(ctx, 1, "key-1", "hash-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, record.StatusCode)
+
+	// First request still in flight
+	_, err = model.ReserveIdempotencyKey(ctx, 1, "key-1", "hash-a")
+	assert.True(t, errors.Is(err, ErrIdempotencyKeyInProgress), "expected ErrIdempotencyKeyInProgress, got %v", err)
+
+	err = model.CompleteIdempotencyKey(ctx, record, http.StatusOK, []byte(`{"balance":100}`))
+	assert.NoError(t, err)
+
+	replay, err := model.ReserveIdempotencyKey(ctx, 1, "key-1", "hash-a")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, replay.StatusCode)
+	assert.Equal(t, `{"balance":100}`, string(replay.Response))
+
+	_, err = model.ReserveIdempotencyKey(ctx, 1, "key-1", "hash-b")
+	assert.True(t, errors.Is(err, ErrIdempotencyKeyReused), "expected ErrIdempotencyKeyReused, got %v", err)
+
+	// Keys are scoped per user
+	other, err := model.ReserveIdempotencyKey(ctx, 2, "key-1", "hash-b")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, other.StatusCode)
+
+	// Released keys can be reserved again
+	err = model.ReleaseIdempotencyKey(ctx, other)
+	assert.NoError(t, err)
+	_, err = model.ReserveIdempotencyKey(ctx, 2, "key-1", "hash-c")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Where deposits come from and withdrawals go to, balance goes negative as cash enters the system
+	SYSTEM_ACCOUNT_EXTERNAL_CASH = "external_cash"
+
+	// Sells one currency and buys another on FX transfers, holds a position per currency
+	SYSTEM_ACCOUNT_FX_CLEARING = "fx_clearing"
+
+	// House account earning the spread charged on FX transfers
+	SYSTEM_ACCOUNT_FX_SPREAD = "fx_spread"
+
+	// Other side of manual balance adjustments made by admins
+	SYSTEM_ACCOUNT_ADJUSTMENTS = "adjustments"
+)
+
+var ErrUnbalancedEntry = errors.New("journal entry is unbalanced")
+
+// Groups all postings of a money movement under a single TransactionUUID
+// Each posting is stored as a Transaction row, DestWalletId being the wallet the posting belongs to
+type JournalEntry struct {
+	Base
+	TransactionUUID string          `gorm:"uniqueIndex" json:"transaction_uuid"`
+	Type            TransactionType `json:"type"`
+
+	// Admin who posted the entry, zero for entries made by users themselves
+	ActorUserId uint64 `json:"actor_user_id,omitempty"`
+
+	// TransactionUUID of the entry a reversal moved the funds of back, unique so an entry is reversed at most once
+	ReversalOf string `gorm:"uniqueIndex:idx_journal_entries_reversal_of,where:reversal_of <> ''" json:"reversal_of,omitempty"`
+}
+
+func (*JournalEntry) TableName() string {
+	return "journal_entries"
+}
+
+// Journal entry with every one of its postings, system wallets included
+func (m *Model) GetJournalEntry(ctx context.Context, transactionUUID string) (JournalEntry, []Transaction, error) {
+	var entry JournalEntry
+	var postings []Transaction
+
+	err := m.db.WithContext(ctx).Where("transaction_uuid = ?", transactionUUID).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return entry, nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return entry, nil, fmt.Errorf("failed to get journal entry: %w", err)
+	}
+
+	if err := m.db.WithContext(ctx).Where("transaction_uuid = ?", transactionUUID).Order("id").Find(&postings).Error; err != nil {
+		return entry, nil, fmt.Errorf("failed to get journal entry postings: %w", err)
+	}
+
+	return entry, postings, nil
+}
+
+// One leg of a journal entry, Amount is credited (positive) or debited (negative) to WalletId
+// Amount must be in the currency of WalletId
+type Posting struct {
+	WalletId             uint64
+	CounterpartyWalletId uint64
+	Amount               Money
+	Memo                 string
+}
+
+// Writes a journal entry with its postings and applies them to wallet balances
+// Refuses any entry whose postings do not sum up to zero in every currency
+// Caller is responsible for locking and checking wallet balances beforehand
+func postJournalEntry(tx *gorm.DB, transactionType TransactionType, postings ...Posting) (JournalEntry, error) {
+	entry := JournalEntry{
+		TransactionUUID: uuid.New().String(),
+		Type:            transactionType,
+	}
+
+	if err := checkBalanced(postings); err != nil {
+		return entry, err
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return entry, err
+	}
+
+	// Wallet first, then its posting, so holding a wallet's row lock means no uncommitted posting to it exists
+	// Snapshots rely on it, see syncWalletSnapshot
+	for _, posting := range postings {
+		// Only applied while the new balance still fits in an int64, available balance is never above balance so it fits too
+		result := tx.Model(&Wallet{}).
+			Where("id = ? AND currency = ?", posting.WalletId, posting.Amount.Currency).
+			Scopes(balanceFits(posting.Amount)).
+			Updates(map[string]interface{}{
+				"balance":           gorm.Expr("balance + ?", posting.Amount.Amount),
+				"available_balance": gorm.Expr("available_balance + ?", posting.Amount.Amount),
+			})
+		if result.Error != nil {
+			return entry, result.Error
+		}
+
+		if result.RowsAffected != 1 {
+			var count int64
+			if err := tx.Model(&Wallet{}).Where("id = ? AND currency = ?", posting.WalletId, posting.Amount.Currency).Count(&count).Error; err != nil {
+				return entry, err
+			}
+
+			if count == 1 {
+				return entry, ErrBalanceOverflow
+			}
+
+			return entry, fmt.Errorf("%w: wallet %d does not hold %s", ErrUnbalancedEntry, posting.WalletId, posting.Amount.Currency)
+		}
+
+		transaction := Transaction{
+			TransactionUUID: entry.TransactionUUID,
+			SourceWalletId:  posting.CounterpartyWalletId,
+			DestWalletId:    posting.WalletId,
+			Currency:        posting.Amount.Currency,
+			Amount:          posting.Amount,
+			Type:            transactionType,
+			Memo:            posting.Memo,
+		}
+
+		if err := tx.Create(&transaction).Error; err != nil {
+			return entry, err
+		}
+	}
+
+	return entry, nil
+}
+
+// Bounds computed in Go, so the check itself cannot overflow in the database
+func balanceFits(amount Money) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if amount.IsPositive() {
+			return db.Where("balance <= ?", math.MaxInt64-amount.Amount)
+		}
+
+		return db.Where("balance >= ?", math.MinInt64-amount.Amount)
+	}
+}
+
+func checkBalanced(postings []Posting) error {
+	if len(postings) < 2 {
+		return fmt.Errorf("%w: requires at least 2 postings, got %d", ErrUnbalancedEntry, len(postings))
+	}
+
+	// Amounts in different currencies cannot offset each other
+	sums := make(map[string]Money)
+	for _, posting := range postings {
+		if posting.Amount.IsZero() {
+			return fmt.Errorf("%w: zero amount posting to wallet %d", ErrUnbalancedEntry, posting.WalletId)
+		}
+		if posting.Amount.Currency == "" {
+			return fmt.Errorf("%w: posting to wallet %d has no currency", ErrUnbalancedEntry, posting.WalletId)
+		}
+
+		sum, err := sums[posting.Amount.Currency].Add(posting.Amount)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUnbalancedEntry, err)
+		}
+		sums[posting.Amount.Currency] = sum
+	}
+
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("%w: %s postings sum up to %d", ErrUnbalancedEntry, currency, sum.Amount)
+		}
+	}
+
+	return nil
+}
+
+// System wallets are not owned by any user, one per currency, created on first use
+func systemWallet(tx *gorm.DB, account, currency string) (Wallet, error) {
+	// Concurrent first uses both insert, the loser is skipped by idx_wallets_system_account_currency and reads the winner's wallet
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Wallet{
+		SystemAccount: account,
+		Currency:      currency,
+	}).Error
+	if err != nil {
+		return Wallet{}, err
+	}
+
+	var wallet Wallet
+	err = tx.Where("system_account = ? AND currency = ?", account, currency).First(&wallet).Error
+
+	return wallet, err
+}
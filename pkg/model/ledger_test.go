@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostJournalEntryUnbalanced(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	walletA := Wallet{UserId: 1, Balance: NewMoney(100, "USD")}
+	walletB := Wallet{UserId: 2, Balance: NewMoney(100, "USD")}
+	assert.NoError(t, db.Create(&walletA).Error)
+	assert.NoError(t, db.Create(&walletB).Error)
+
+	tests := []struct {
+		name     string
+		postings []Posting
+	}{
+		{"no postings", nil},
+		{"single posting", []Posting{{WalletId: walletA.Id, Amount: NewMoney(10, "USD")}}},
+		{"does not sum to zero", []Posting{
+			{WalletId: walletA.Id, CounterpartyWalletId: walletB.Id, Amount: NewMoney(-10, "USD")},
+			{WalletId: walletB.Id, CounterpartyWalletId: walletA.Id, Amount: NewMoney(11, "USD")},
+		}},
+		{"zero amount", []Posting{
+			{WalletId: walletA.Id, CounterpartyWalletId: walletB.Id, Amount: NewMoney(0, "USD")},
+			{WalletId: walletB.Id, CounterpartyWalletId: walletA.Id, Amount: NewMoney(0, "USD")},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := postJournalEntry(db, TRANSACTION_TYPE_TRANSFER, tt.postings...)
+			assert.True(t, errors.Is(err, ErrUnbalancedEntry), "expected ErrUnbalancedEntry, got %v", err)
+		})
+	}
+
+	var count int64
+	db.Model(&JournalEntry{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&Transaction{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestLedgerPostingsSumToZero(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{Name: "User A", Email: "user_a@crypto.com"}
+	dest := User{Name: "User B", Email: "user_b@crypto.com"}
+	assert.NoError(t, db.Create(&source).Error)
+	assert.NoError(t, db.Create(&dest).Error)
+	assert.NoError(t, db.Create(&Wallet{UserId: source.Id}).Error)
+	assert.NoError(t, db.Create(&Wallet{UserId: dest.Id}).Error)
+
+	_, err := model.Deposit(context.Background(), source.Id, NewMoney(500, "USD"))
+	assert.NoError(t, err)
+	_, err = model.Withdraw(context.Background(), source.Id, NewMoney(100, "USD"))
+	assert.NoError(t, err)
+	err = model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(150, "USD"), "")
+	assert.NoError(t, err)
+
+	var entries []JournalEntry
+	assert.NoError(t, db.Find(&entries).Error)
+	assert.Len(t, entries, 3)
+
+	for _, entry := range entries {
+		var sum int64
+		db.Model(&Transaction{}).Select("SUM(amount)").Where("transaction_uuid = ?", entry.TransactionUUID).Scan(&sum)
+		assert.Equal(t, int64(0), sum, "entry %s does not sum to zero", entry.Type)
+	}
+
+	// Every cent in user wallets came from the external cash account
+	var cash Wallet
+	assert.NoError(t, db.Where("system_account = ?", SYSTEM_ACCOUNT_EXTERNAL_CASH).First(&cash).Error)
+	assert.Equal(t, int64(-400), cash.Balance.Amount)
+
+	var total int64
+	db.Model(&Wallet{}).Select("SUM(balance)").Scan(&total)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestSystemWallet(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first, err := systemWallet(db, SYSTEM_ACCOUNT_EXTERNAL_CASH, "USD")
+	assert.NoError(t, err)
+	assert.NotZero(t, first.Id)
+	assert.Equal(t, SYSTEM_ACCOUNT_EXTERNAL_CASH, first.SystemAccount)
+
+	// Already created, the insert is skipped and the same wallet comes back
+	again, err := systemWallet(db, SYSTEM_ACCOUNT_EXTERNAL_CASH, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Id, again.Id)
+
+	euro, err := systemWallet(db, SYSTEM_ACCOUNT_EXTERNAL_CASH, "EUR")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Id, euro.Id)
+
+	var count int64
+	db.Model(&Wallet{}).Where("system_account = ?", SYSTEM_ACCOUNT_EXTERNAL_CASH).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
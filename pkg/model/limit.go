@@ -0,0 +1,215 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DEFAULT_LIMIT_TIER = "standard"
+)
+
+// Transaction types that take money out of a user's wallet, counted towards the daily outgoing limit
+var outgoingTransactionTypes = []TransactionType{
+	TRANSACTION_TYPE_WITHDRAW,
+	TRANSACTION_TYPE_TRANSFER,
+	TRANSACTION_TYPE_FX_TRANSFER,
+}
+
+// Limits in one currency, for every user of a tier (UserId 0) or for a single user (Tier empty)
+// A user's own limits take precedence over their tier's, a zero limit is no limit
+type TransactionLimit struct {
+	Base
+	Tier     string `gorm:"uniqueIndex:idx_transaction_limits_owner_currency" json:"tier,omitempty"`
+	UserId   uint64 `gorm:"uniqueIndex:idx_transaction_limits_owner_currency" json:"user_id,omitempty"`
+	Currency string `gorm:"uniqueIndex:idx_transaction_limits_owner_currency" json:"currency"`
+
+	MaxSingleTransfer Money `json:"max_single_transfer"`
+
+	// Withdrawals and transfers since 00:00 UTC
+	DailyOutgoing Money `json:"daily_outgoing"`
+
+	// Withdrawals since the 1st of the month, 00:00 UTC
+	MonthlyWithdraw Money `json:"monthly_withdraw"`
+}
+
+func (*TransactionLimit) TableName() string {
+	return "transaction_limits"
+}
+
+func (l *TransactionLimit) AfterFind(tx *gorm.DB) error {
+	l.MaxSingleTransfer.Currency = l.Currency
+	l.DailyOutgoing.Currency = l.Currency
+	l.MonthlyWithdraw.Currency = l.Currency
+	return nil
+}
+
+// Creates or replaces the limits of a tier or a single user in the currency
+func (m *Model) SetTransactionLimit(ctx context.Context, limit TransactionLimit) (TransactionLimit, error) {
+	if (limit.Tier == "") == (limit.UserId == 0) {
+		return limit, ErrBadInput
+	}
+
+	currency, err := ParseCurrency(limit.Currency)
+	if err != nil {
+		return limit, err
+	}
+	limit.Currency = currency.Code
+
+	for _, amount := range []Money{limit.MaxSingleTransfer, limit.DailyOutgoing, limit.MonthlyWithdraw} {
+		if amount.IsNegative() {
+			return limit, ErrInvalidAmount
+		}
+	}
+
+	err = m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tier"}, {Name: "user_id"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "max_single_transfer", "daily_outgoing", "monthly_withdraw"}),
+	}).Create(&limit).Error
+	if err != nil {
+		return limit, fmt.Errorf("failed to set transaction limit: %w", err)
+	}
+
+	return limit, nil
+}
+
+// User's own limits if set, otherwise their tier's, otherwise no limits at all
+func transactionLimitOf(tx *gorm.DB, userId uint64, currency string) (TransactionLimit, error) {
+	var limit TransactionLimit
+
+	err := tx.
+		Where("currency = ?", currency).
+		Where("(user_id = ? AND tier = '') OR (user_id = 0 AND tier = (?))", userId, tx.Model(&User{}).Select("tier").Where("id = ?", userId)).
+		Order("user_id desc").
+		First(&limit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TransactionLimit{Currency: currency}, nil
+	}
+	if err != nil {
+		return limit, fmt.Errorf("failed to get transaction limit: %w", err)
+	}
+
+	return limit, nil
+}
+
+// Checks amount leaving the wallet against the user's limits, summing the wallet's transactions since each window start
+// Active holds placed in the daily window count towards it, captured ones count as the transfer they became
+// Run in the same DB transaction as the debit, with the wallet row locked so concurrent debits cannot both pass
+func checkLimits(tx *gorm.DB, userId uint64, wallet Wallet, transactionType TransactionType, amount Money) error {
+	limit, err := transactionLimitOf(tx, userId, amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	if transactionType == TRANSACTION_TYPE_TRANSFER && limit.MaxSingleTransfer.IsPositive() {
+		if err := checkLimit(amount, Money{}, limit.MaxSingleTransfer, "single transfer"); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+
+	if limit.DailyOutgoing.IsPositive() {
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+		spent, err := outgoingSince(tx, wallet, dayStart, outgoingTransactionTypes...)
+		if err != nil {
+			return err
+		}
+
+		// Reserved for a merchant today, as good as spent until released
+		held, err := heldSince(tx, wallet, dayStart)
+		if err != nil {
+			return err
+		}
+
+		spent, err = spent.Add(held)
+		if errors.Is(err, ErrAmountOverflow) {
+			return limitExceeded("daily outgoing", limit.DailyOutgoing)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := checkLimit(amount, spent, limit.DailyOutgoing, "daily outgoing"); err != nil {
+			return err
+		}
+	}
+
+	if transactionType == TRANSACTION_TYPE_WITHDRAW && limit.MonthlyWithdraw.IsPositive() {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		withdrawn, err := outgoingSince(tx, wallet, monthStart, TRANSACTION_TYPE_WITHDRAW)
+		if err != nil {
+			return err
+		}
+
+		if err := checkLimit(amount, withdrawn, limit.MonthlyWithdraw, "monthly withdraw"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Total debited from the wallet since the time, as a positive amount
+func outgoingSince(tx *gorm.DB, wallet Wallet, since time.Time, types ...TransactionType) (Money, error) {
+	outgoing := Money{Currency: wallet.Currency}
+
+	var sum int64
+	if err := tx.Model(&Transaction{}).
+		Where("dest_wallet_id = ? AND type IN ? AND amount < 0 AND created_at >= ?", wallet.Id, types, since).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&sum).Error; err != nil {
+		return outgoing, fmt.Errorf("failed to sum outgoing transactions: %w", err)
+	}
+
+	outgoing.Amount = sum
+	return outgoing.Neg()
+}
+
+// Total of the wallet's active holds placed since the time
+func heldSince(tx *gorm.DB, wallet Wallet, since time.Time) (Money, error) {
+	held := Money{Currency: wallet.Currency}
+
+	if err := tx.Model(&Hold{}).
+		Where("wallet_id = ? AND status = ? AND created_at >= ?", wallet.Id, HOLD_STATUS_ACTIVE, since).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&held.Amount).Error; err != nil {
+		return held, fmt.Errorf("failed to sum active holds: %w", err)
+	}
+
+	return held, nil
+}
+
+func checkLimit(amount, used, limit Money, name string) error {
+	total, err := used.Add(amount)
+	if errors.Is(err, ErrAmountOverflow) {
+		return limitExceeded(name, limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	cmp, err := total.Cmp(limit)
+	if err != nil {
+		return err
+	}
+
+	if cmp > 0 {
+		return limitExceeded(name, limit)
+	}
+
+	return nil
+}
+
+// Same code as ErrLimitExceeded, with the limit that was hit in the message
+func limitExceeded(name string, limit Money) error {
+	return newClientError(http.StatusUnprocessableEntity, ErrLimitExceeded.Code, fmt.Sprintf("The %s limit of %s would be exceeded", name, limit))
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestWithdrawLimits(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(10000, "USD")},
+		},
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	_, err := model.SetTransactionLimit(context.Background(), TransactionLimit{
+		Tier:            DEFAULT_LIMIT_TIER,
+		Currency:        "USD",
+		DailyOutgoing:   NewMoney(1000, "USD"),
+		MonthlyWithdraw: NewMoney(1500, "USD"),
+	})
+	assert.NoError(t, err)
+
+	// Withdrawn earlier this month, counts towards the monthly limit only, not on the 1st when it would be today
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if now.Day() > 1 {
+		assert.NoError(t, db.Create(&Transaction{Base: Base{CreatedAt: monthStart.Add(time.Minute)}, DestWalletId: user.Wallets[0].Id, Type: TRANSACTION_TYPE_WITHDRAW, Currency: "USD", Amount: NewMoney(-400, "USD")}).Error)
+	}
+	// Withdrawn last month, counts towards neither
+	assert.NoError(t, db.Create(&Transaction{Base: Base{CreatedAt: monthStart.Add(-time.Minute)}, DestWalletId: user.Wallets[0].Id, Type: TRANSACTION_TYPE_WITHDRAW, Currency: "USD", Amount: NewMoney(-5000, "USD")}).Error)
+
+	_, err = model.Withdraw(context.Background(), user.Id, NewMoney(800, "USD"))
+	assert.NoError(t, err)
+
+	_, err = model.Withdraw(context.Background(), user.Id, NewMoney(201, "USD"))
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+	assert.Contains(t, err.Error(), "daily outgoing limit of USD 10.00")
+
+	_, err = model.Withdraw(context.Background(), user.Id, NewMoney(200, "USD"))
+	assert.NoError(t, err)
+
+	// A user's own limits take precedence over the tier's
+	_, err = model.SetTransactionLimit(context.Background(), TransactionLimit{
+		UserId:          user.Id,
+		Currency:        "USD",
+		MonthlyWithdraw: NewMoney(1500, "USD"),
+	})
+	assert.NoError(t, err)
+
+	if now.Day() > 1 {
+		_, err = model.Withdraw(context.Background(), user.Id, NewMoney(101, "USD"))
+		assert.True(t, errors.Is(err, ErrLimitExceeded), "expected ErrLimitExceeded, got %v", err)
+		assert.Contains(t, err.Error(), "monthly withdraw limit of USD 15.00")
+	}
+
+	_, err = model.Withdraw(context.Background(), user.Id, NewMoney(100, "USD"))
+	assert.NoError(t, err)
+
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, user.Wallets[0].Id).Error)
+	assert.Equal(t, int64(8900), wallet.Balance.Amount)
+}
+
+func TestTransferLimits(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Tier:  "basic",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(10000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	for _, limit := range []TransactionLimit{
+		{Tier: "basic", Currency: "USD", MaxSingleTransfer: NewMoney(500, "USD"), DailyOutgoing: NewMoney(900, "USD")},
+		// Other tiers and currencies do not apply
+		{Tier: DEFAULT_LIMIT_TIER, Currency: "USD", MaxSingleTransfer: NewMoney(1, "USD")},
+		{Tier: "basic", Currency: "EUR", MaxSingleTransfer: NewMoney(1, "EUR")},
+	} {
+		_, err := model.SetTransactionLimit(context.Background(), limit)
+		assert.NoError(t, err)
+	}
+
+	transfer := func(amount int64) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			_, err := model.transferBalance(context.Background(), tx, source.Id, dest.Id, NewMoney(amount, "USD"), "")
+			return err
+		})
+	}
+
+	tests := []struct {
+		name   string
+		amount int64
+		want   error
+		limit  string
+	}{
+		{"Above single transfer limit", 501, ErrLimitExceeded, "single transfer limit of USD 5.00"},
+		{"At single transfer limit", 500, nil, ""},
+		{"Above daily outgoing limit", 401, ErrLimitExceeded, "daily outgoing limit of USD 9.00"},
+		{"At daily outgoing limit", 400, nil, ""},
+		{"Daily outgoing limit used up", 1, ErrLimitExceeded, "daily outgoing limit of USD 9.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validateErr := model.ValidateTransfer(context.Background(), source.Id, dest.Id, NewMoney(tt.amount, "USD"))
+			err := transfer(tt.amount)
+			if tt.want == nil {
+				assert.NoError(t, validateErr)
+				assert.NoError(t, err)
+				return
+			}
+
+			for _, err := range []error{validateErr, err} {
+				assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+				assert.Contains(t, err.Error(), tt.limit)
+			}
+		})
+	}
+
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, source.Wallets[0].Id).Error)
+	assert.Equal(t, int64(9100), wallet.Balance.Amount)
+}
+
+func TestSetTransactionLimitInvalid(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	tests := []struct {
+		name  string
+		limit TransactionLimit
+		want  error
+	}{
+		{"Neither tier nor user", TransactionLimit{Currency: "USD"}, ErrBadInput},
+		{"Both tier and user", TransactionLimit{Tier: DEFAULT_LIMIT_TIER, UserId: 1, Currency: "USD"}, ErrBadInput},
+		{"Unsupported currency", TransactionLimit{Tier: DEFAULT_LIMIT_TIER, Currency: "XYZ"}, ErrUnsupportedCurrency},
+		{"Negative limit", TransactionLimit{Tier: DEFAULT_LIMIT_TIER, Currency: "USD", DailyOutgoing: NewMoney(-1, "USD")}, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.SetTransactionLimit(context.Background(), tt.limit)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/go-redis/redis/v8"
@@ -10,10 +11,24 @@ import (
 type Model struct {
 	db    *gorm.DB
 	redis *redis.Client
+	rates RateProvider
 }
 
-func NewModel() *Model {
-	return &Model{}
+type ModelOption func(*Model)
+
+func NewModel(opts ...ModelOption) *Model {
+	m := &Model{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Without a rate provider, FX quotes are rejected with ErrRateUnavailable
+func WithRateProvider(rates RateProvider) ModelOption {
+	return func(m *Model) {
+		m.rates = rates
+	}
 }
 
 func (m *Model) Setup() error {
@@ -47,3 +62,25 @@ func (m *Model) SetupDatabase() error {
 func (m *Model) GetRedis() *redis.Client {
 	return m.redis
 }
+
+func (m *Model) Close() error {
+	var errs []error
+
+	if m.db != nil {
+		sqlDB, err := m.db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database: %w", err))
+		}
+	}
+
+	if m.redis != nil {
+		if err := m.redis.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close redis: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Amount in minor units of Currency, e.g. 1003 USD is $10.03
+// Stored as the minor units alone, the currency lives in a column of its own and is filled in after loading
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// -1, 0 or +1, amounts in different currencies are not comparable
+func (m Money) Cmp(other Money) (int, error) {
+	if _, err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.sameCurrency(other)
+	if err != nil {
+		return m, err
+	}
+
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return m, ErrAmountOverflow
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	negated, err := other.Neg()
+	if err != nil {
+		return m, err
+	}
+
+	return m.Add(negated)
+}
+
+// MinInt64 has no positive counterpart
+func (m Money) Neg() (Money, error) {
+	if m.Amount == math.MinInt64 {
+		return m, ErrAmountOverflow
+	}
+
+	return Money{Amount: -m.Amount, Currency: m.Currency}, nil
+}
+
+// Amount in major units with the currency code, e.g. "USD 10.03"
+func (m Money) String() string {
+	currency, err := ParseCurrency(m.Currency)
+	if err != nil {
+		return fmt.Sprintf("%s %d", m.Currency, m.Amount)
+	}
+
+	return fmt.Sprintf("%s %s", currency.Code, currency.FormatAmount(m.Amount))
+}
+
+// Money without a currency, e.g. a sum scanned from the database, takes the currency of the other
+func (m Money) sameCurrency(other Money) (string, error) {
+	switch {
+	case m.Currency == "":
+		return other.Currency, nil
+	case other.Currency == "", m.Currency == other.Currency:
+		return m.Currency, nil
+	default:
+		return "", ErrCurrencyMismatch
+	}
+}
+
+// Minor units as a JSON string, JavaScript numbers lose precision beyond 2^53
+// The currency is a field of its own in every request and response
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(m.Amount, 10))
+}
+
+// Accepts a JSON number as well, for clients that predate string amounts
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	data = bytes.Trim(data, `"`)
+
+	amount, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return ErrInvalidAmount
+	}
+
+	m.Amount = amount
+	return nil
+}
+
+func (Money) GormDataType() string {
+	return "bigint"
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		m.Amount = v
+	case []byte:
+		amount, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to scan money: %w", err)
+		}
+		m.Amount = amount
+	case nil:
+		m.Amount = 0
+	default:
+		return fmt.Errorf("failed to scan money: unsupported type %T", src)
+	}
+
+	return nil
+}
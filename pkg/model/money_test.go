@@ -0,0 +1,98 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want Money
+		err  error
+	}{
+		{"Add", func() (Money, error) { return NewMoney(100, "USD").Add(NewMoney(50, "USD")) }, NewMoney(150, "USD"), nil},
+		{"Add negative", func() (Money, error) { return NewMoney(100, "USD").Add(NewMoney(-150, "USD")) }, NewMoney(-50, "USD"), nil},
+		{"Add overflow", func() (Money, error) { return NewMoney(math.MaxInt64, "USD").Add(NewMoney(1, "USD")) }, Money{}, ErrAmountOverflow},
+		{"Add underflow", func() (Money, error) { return NewMoney(math.MinInt64, "USD").Add(NewMoney(-1, "USD")) }, Money{}, ErrAmountOverflow},
+		{"Add currency mismatch", func() (Money, error) { return NewMoney(100, "USD").Add(NewMoney(100, "EUR")) }, Money{}, ErrCurrencyMismatch},
+		{"Add without currency", func() (Money, error) { return Money{Amount: 100}.Add(NewMoney(100, "EUR")) }, NewMoney(200, "EUR"), nil},
+		{"Sub", func() (Money, error) { return NewMoney(100, "USD").Sub(NewMoney(150, "USD")) }, NewMoney(-50, "USD"), nil},
+		{"Sub overflow", func() (Money, error) { return NewMoney(math.MinInt64, "USD").Sub(NewMoney(1, "USD")) }, Money{}, ErrAmountOverflow},
+		{"Sub min", func() (Money, error) { return NewMoney(0, "USD").Sub(NewMoney(math.MinInt64, "USD")) }, Money{}, ErrAmountOverflow},
+		{"Neg", func() (Money, error) { return NewMoney(100, "USD").Neg() }, NewMoney(-100, "USD"), nil},
+		{"Neg min", func() (Money, error) { return NewMoney(math.MinInt64, "USD").Neg() }, Money{}, ErrAmountOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "expected %v, got %v", tt.err, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	cmp, err := NewMoney(100, "USD").Cmp(NewMoney(50, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cmp)
+
+	cmp, err = NewMoney(50, "USD").Cmp(NewMoney(100, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, cmp)
+
+	_, err = NewMoney(100, "USD").Cmp(NewMoney(100, "EUR"))
+	assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{NewMoney(math.MaxInt64, "USD")})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"amount":"9223372036854775807"}`, string(data))
+
+	tests := []struct {
+		json string
+		want int64
+		err  bool
+	}{
+		{`"1003"`, 1003, false},
+		{`1003`, 1003, false},
+		{`"-5"`, -5, false},
+		{`"9223372036854775807"`, math.MaxInt64, false},
+		{`"9223372036854775808"`, 0, true},
+		{`"10.03"`, 0, true},
+		{`"abc"`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var money Money
+			err := json.Unmarshal([]byte(tt.json), &money)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, money.Amount)
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "USD 10.03", NewMoney(1003, "USD").String())
+	assert.Equal(t, "JPY -1003", NewMoney(-1003, "JPY").String())
+}
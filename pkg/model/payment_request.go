@@ -0,0 +1,370 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PAYMENT_REQUEST_DEFAULT_TTL = 7 * 24 * time.Hour
+	PAYMENT_REQUEST_MAX_TTL     = 30 * 24 * time.Hour
+)
+
+type PaymentRequestStatus int
+
+const (
+	PAYMENT_REQUEST_STATUS_PENDING PaymentRequestStatus = iota + 1
+	PAYMENT_REQUEST_STATUS_ACCEPTED
+	PAYMENT_REQUEST_STATUS_DECLINED
+	PAYMENT_REQUEST_STATUS_CANCELLED
+	PAYMENT_REQUEST_STATUS_EXPIRED
+)
+
+func (s PaymentRequestStatus) String() string {
+	switch s {
+	case PAYMENT_REQUEST_STATUS_PENDING:
+		return "pending"
+	case PAYMENT_REQUEST_STATUS_ACCEPTED:
+		return "accepted"
+	case PAYMENT_REQUEST_STATUS_DECLINED:
+		return "declined"
+	case PAYMENT_REQUEST_STATUS_CANCELLED:
+		return "cancelled"
+	case PAYMENT_REQUEST_STATUS_EXPIRED:
+		return "expired"
+	default:
+		return "-"
+	}
+}
+
+func ParsePaymentRequestStatus(s string) (PaymentRequestStatus, error) {
+	for status := PAYMENT_REQUEST_STATUS_PENDING; status <= PAYMENT_REQUEST_STATUS_EXPIRED; status++ {
+		if s == status.String() {
+			return status, nil
+		}
+	}
+
+	return 0, ErrBadInput
+}
+
+// Side of the payment request the user is on when listing them
+type PaymentRequestRole int
+
+const (
+	PAYMENT_REQUEST_ROLE_ANY PaymentRequestRole = iota
+	PAYMENT_REQUEST_ROLE_REQUESTER
+	PAYMENT_REQUEST_ROLE_PAYER
+)
+
+func ParsePaymentRequestRole(s string) (PaymentRequestRole, error) {
+	switch s {
+	case "":
+		return PAYMENT_REQUEST_ROLE_ANY, nil
+	case "outgoing":
+		return PAYMENT_REQUEST_ROLE_REQUESTER, nil
+	case "incoming":
+		return PAYMENT_REQUEST_ROLE_PAYER, nil
+	default:
+		return 0, ErrBadInput
+	}
+}
+
+// Money asked of the payer by the requester, paid with a transfer from the payer when accepted
+// Nothing is reserved until then, the payer's balance is only checked on accept
+type PaymentRequest struct {
+	Base
+	RequestUUID     string               `gorm:"uniqueIndex" json:"request_uuid"`
+	RequesterUserId uint64               `gorm:"index" json:"requester_user_id"`
+	PayerUserId     uint64               `gorm:"index" json:"payer_user_id"`
+	Currency        string               `json:"currency"`
+	Amount          Money                `json:"amount"`
+	Memo            string               `json:"memo"`
+	Status          PaymentRequestStatus `gorm:"index:idx_payment_requests_status_expires_at" json:"status"`
+	ExpiresAt       time.Time            `gorm:"index:idx_payment_requests_status_expires_at" json:"expires_at"`
+
+	// Journal entry of the transfer that paid the request
+	TransactionUUID string `json:"transaction_uuid,omitempty"`
+}
+
+func (*PaymentRequest) TableName() string {
+	return "payment_requests"
+}
+
+func (p *PaymentRequest) AfterFind(tx *gorm.DB) error {
+	p.Amount.Currency = p.Currency
+	return nil
+}
+
+// The requester must hold a wallet in the currency to be paid into, the payer's balance is not checked yet
+func (m *Model) CreatePaymentRequest(ctx context.Context, requesterUserId, payerUserId uint64, amount Money, expiresAt time.Time, memo string) (PaymentRequest, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var request PaymentRequest
+
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return request, err
+	}
+
+	if requesterUserId == payerUserId {
+		return request, ErrSelfTransferInvalid
+	}
+
+	if !expiresAt.After(time.Now()) || time.Until(expiresAt) > PAYMENT_REQUEST_MAX_TTL {
+		return request, ErrBadInput
+	}
+
+	memo, err = normalizeMemo(memo)
+	if err != nil {
+		return request, err
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var payerCount int64
+		if err := tx.Model(&User{}).Where("id = ?", payerUserId).Count(&payerCount).Error; err != nil {
+			return err
+		}
+
+		if payerCount == 0 {
+			return ErrUserNotFound
+		}
+
+		var requesterWalletCount int64
+		if err := tx.Model(&Wallet{}).Scopes(ownedBy(requesterUserId), inCurrency(amount.Currency)).Count(&requesterWalletCount).Error; err != nil {
+			return err
+		}
+
+		if requesterWalletCount == 0 {
+			return ErrWalletNotFound
+		}
+
+		request = PaymentRequest{
+			RequestUUID:     uuid.New().String(),
+			RequesterUserId: requesterUserId,
+			PayerUserId:     payerUserId,
+			Currency:        amount.Currency,
+			Amount:          amount,
+			Memo:            memo,
+			Status:          PAYMENT_REQUEST_STATUS_PENDING,
+			ExpiresAt:       expiresAt,
+		}
+
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		return request, fmt.Errorf("failed to create payment request: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("payment request %s of %s by user_id %d to user_id %d", request.RequestUUID, amount, requesterUserId, payerUserId))
+
+	return request, nil
+}
+
+// Either side of the request can look it up, a pending request past its expiry is reported as expired
+func (m *Model) GetPaymentRequest(ctx context.Context, userId uint64, requestUUID string) (PaymentRequest, error) {
+	var request PaymentRequest
+
+	err := m.db.WithContext(ctx).Where("request_uuid = ? AND (requester_user_id = ? OR payer_user_id = ?)", requestUUID, userId, userId).First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return request, ErrPaymentRequestNotFound
+	}
+	if err != nil {
+		return request, fmt.Errorf("failed to get payment request: %w", err)
+	}
+
+	request.markExpired(time.Now())
+
+	return request, nil
+}
+
+// Newest first, a zero status lists every status
+// Pending requests past their expiry are listed as expired even before ExpirePaymentRequests gets to them
+func (m *Model) GetPaymentRequests(ctx context.Context, userId uint64, role PaymentRequestRole, status PaymentRequestStatus, pageInfo PageInfo) ([]PaymentRequest, error) {
+	var requests []PaymentRequest
+
+	if pageInfo.Page < 1 {
+		pageInfo.Page = 1
+	}
+
+	if pageInfo.PageSize < 1 || pageInfo.PageSize > 100 {
+		pageInfo.PageSize = 30
+	}
+
+	db := m.db.WithContext(ctx)
+
+	switch role {
+	case PAYMENT_REQUEST_ROLE_REQUESTER:
+		db = db.Where("requester_user_id = ?", userId)
+	case PAYMENT_REQUEST_ROLE_PAYER:
+		db = db.Where("payer_user_id = ?", userId)
+	default:
+		db = db.Where("requester_user_id = ? OR payer_user_id = ?", userId, userId)
+	}
+
+	now := time.Now()
+
+	switch status {
+	case 0:
+	case PAYMENT_REQUEST_STATUS_PENDING:
+		db = db.Where("status = ? AND expires_at > ?", status, now)
+	case PAYMENT_REQUEST_STATUS_EXPIRED:
+		db = db.Where("status = ? OR (status = ? AND expires_at <= ?)", status, PAYMENT_REQUEST_STATUS_PENDING, now)
+	default:
+		db = db.Where("status = ?", status)
+	}
+
+	if err := db.
+		Order("id desc").
+		Offset((pageInfo.Page - 1) * pageInfo.PageSize).
+		Limit(pageInfo.PageSize).
+		Find(&requests).Error; err != nil {
+		return nil, fmt.Errorf("failed to get payment requests: %w", err)
+	}
+
+	for i := range requests {
+		requests[i].markExpired(now)
+	}
+
+	return requests, nil
+}
+
+// Payer only, pays the request with a transfer to the requester, checked like any other transfer
+// The transfer and the request are updated in the same DB transaction, a request is never paid twice
+func (m *Model) AcceptPaymentRequest(ctx context.Context, payerUserId uint64, requestUUID string) (PaymentRequest, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var request PaymentRequest
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = lockPendingPaymentRequest(tx, requestUUID, func(request PaymentRequest) bool {
+			return request.PayerUserId == payerUserId
+		})
+		if err != nil {
+			return err
+		}
+
+		entry, err := m.transferBalance(ctx, tx, request.PayerUserId, request.RequesterUserId, request.Amount, request.Memo)
+		if err != nil {
+			return err
+		}
+
+		request.Status = PAYMENT_REQUEST_STATUS_ACCEPTED
+		request.TransactionUUID = entry.TransactionUUID
+
+		if err := tx.Model(&request).Updates(map[string]interface{}{
+			"status":           request.Status,
+			"transaction_uuid": request.TransactionUUID,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Both sides of the transfer show which request it paid in their history
+		return tx.Model(&Transaction{}).Where("transaction_uuid = ?", entry.TransactionUUID).Update("payment_request_uuid", request.RequestUUID).Error
+	})
+	if err != nil {
+		return request, fmt.Errorf("failed to accept payment request: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("payment request %s paid by %s", requestUUID, request.TransactionUUID))
+
+	return request, nil
+}
+
+// Payer only
+func (m *Model) DeclinePaymentRequest(ctx context.Context, payerUserId uint64, requestUUID string) (PaymentRequest, error) {
+	request, err := m.closePaymentRequest(ctx, requestUUID, PAYMENT_REQUEST_STATUS_DECLINED, func(request PaymentRequest) bool {
+		return request.PayerUserId == payerUserId
+	})
+	if err != nil {
+		return request, fmt.Errorf("failed to decline payment request: %w", err)
+	}
+
+	return request, nil
+}
+
+// Requester only
+func (m *Model) CancelPaymentRequest(ctx context.Context, requesterUserId uint64, requestUUID string) (PaymentRequest, error) {
+	request, err := m.closePaymentRequest(ctx, requestUUID, PAYMENT_REQUEST_STATUS_CANCELLED, func(request PaymentRequest) bool {
+		return request.RequesterUserId == requesterUserId
+	})
+	if err != nil {
+		return request, fmt.Errorf("failed to cancel payment request: %w", err)
+	}
+
+	return request, nil
+}
+
+// Marks pending requests past their expiry as expired, nothing was reserved so there is nothing to release
+// Returns the number of requests expired
+func (m *Model) ExpirePaymentRequests(ctx context.Context, now time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).
+		Model(&PaymentRequest{}).
+		Where("status = ? AND expires_at <= ?", PAYMENT_REQUEST_STATUS_PENDING, now).
+		Update("status", PAYMENT_REQUEST_STATUS_EXPIRED)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to expire payment requests: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// Pending requests past their expiry are expired whether ExpirePaymentRequests got to them or not
+func (p *PaymentRequest) markExpired(now time.Time) {
+	if p.Status == PAYMENT_REQUEST_STATUS_PENDING && !now.Before(p.ExpiresAt) {
+		p.Status = PAYMENT_REQUEST_STATUS_EXPIRED
+	}
+}
+
+func (m *Model) closePaymentRequest(ctx context.Context, requestUUID string, status PaymentRequestStatus, allowed func(PaymentRequest) bool) (PaymentRequest, error) {
+	var request PaymentRequest
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		request, err = lockPendingPaymentRequest(tx, requestUUID, allowed)
+		if err != nil {
+			return err
+		}
+
+		request.Status = status
+		return tx.Model(&request).Update("status", request.Status).Error
+	})
+
+	return request, err
+}
+
+// Row locks the request, accepting, declining and cancelling the same request queue up behind each other
+// Requests the user is not allowed to act on are not found, the other side's requests are not leaked
+func lockPendingPaymentRequest(tx *gorm.DB, requestUUID string, allowed func(PaymentRequest) bool) (PaymentRequest, error) {
+	var request PaymentRequest
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("request_uuid = ?", requestUUID).First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return request, ErrPaymentRequestNotFound
+	}
+	if err != nil {
+		return request, err
+	}
+
+	if !allowed(request) {
+		return request, ErrPaymentRequestNotFound
+	}
+
+	if request.Status != PAYMENT_REQUEST_STATUS_PENDING {
+		return request, ErrPaymentRequestNotPending
+	}
+
+	// Not marked by the scheduler yet, but no longer payable
+	if !time.Now().Before(request.ExpiresAt) {
+		return request, ErrPaymentRequestExpired
+	}
+
+	return request, nil
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentRequests(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	requester := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	payer := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	other := User{
+		Name:  "User C",
+		Email: "user_c@crypto.com",
+	}
+	for _, user := range []*User{&requester, &payer, &other} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	request, err := model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(300, "USD"), expiresAt, "Dinner")
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_PENDING, request.Status)
+
+	incoming, err := model.GetPaymentRequests(ctx, payer.Id, PAYMENT_REQUEST_ROLE_PAYER, PAYMENT_REQUEST_STATUS_PENDING, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, incoming, 1)
+	assert.Equal(t, request.RequestUUID, incoming[0].RequestUUID)
+
+	outgoing, err := model.GetPaymentRequests(ctx, payer.Id, PAYMENT_REQUEST_ROLE_REQUESTER, 0, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, outgoing, 0)
+
+	// Only the payer can accept or decline, only the requester can cancel
+	_, err = model.AcceptPaymentRequest(ctx, requester.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotFound), "expected ErrPaymentRequestNotFound, got %v", err)
+
+	_, err = model.DeclinePaymentRequest(ctx, other.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotFound), "expected ErrPaymentRequestNotFound, got %v", err)
+
+	_, err = model.CancelPaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotFound), "expected ErrPaymentRequestNotFound, got %v", err)
+
+	request, err = model.AcceptPaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_ACCEPTED, request.Status)
+	assert.NotEmpty(t, request.TransactionUUID)
+
+	// Never paid twice
+	_, err = model.AcceptPaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotPending), "expected ErrPaymentRequestNotPending, got %v", err)
+
+	history, _, err := model.GetTransactionHistory(ctx, requester.Id, "USD", TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, request.TransactionUUID, history[0].TransactionUUID)
+	assert.Equal(t, request.RequestUUID, history[0].PaymentRequestUUID)
+	assert.Equal(t, int64(300), history[0].Amount.Amount)
+	assert.Equal(t, "Dinner", history[0].Memo)
+
+	history, _, err = model.GetTransactionHistory(ctx, payer.Id, "USD", TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, request.RequestUUID, history[0].PaymentRequestUUID)
+	assert.Equal(t, int64(-300), history[0].Amount.Amount)
+
+	// Declined and cancelled requests move nothing
+	declined, err := model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(100, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+
+	declined, err = model.DeclinePaymentRequest(ctx, payer.Id, declined.RequestUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_DECLINED, declined.Status)
+
+	cancelled, err := model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(100, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+
+	cancelled, err = model.CancelPaymentRequest(ctx, requester.Id, cancelled.RequestUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_CANCELLED, cancelled.Status)
+
+	_, err = model.AcceptPaymentRequest(ctx, payer.Id, declined.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotPending), "expected ErrPaymentRequestNotPending, got %v", err)
+
+	// Accepting checks the payer's balance like any transfer
+	tooMuch, err := model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(5000, "USD"), expiresAt, "")
+	assert.NoError(t, err)
+
+	_, err = model.AcceptPaymentRequest(ctx, payer.Id, tooMuch.RequestUUID)
+	assert.True(t, errors.Is(err, ErrBalanceInsufficient), "expected ErrBalanceInsufficient, got %v", err)
+
+	tooMuch, err = model.GetPaymentRequest(ctx, requester.Id, tooMuch.RequestUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_PENDING, tooMuch.Status)
+
+	_, err = model.GetPaymentRequest(ctx, other.Id, tooMuch.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotFound), "expected ErrPaymentRequestNotFound, got %v", err)
+
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, payer.Wallets[0].Id).Error)
+	assert.Equal(t, int64(700), wallet.Balance.Amount)
+
+	all, err := model.GetPaymentRequests(ctx, requester.Id, PAYMENT_REQUEST_ROLE_ANY, 0, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	tests := []struct {
+		name        string
+		payerUserId uint64
+		amount      Money
+		expiresAt   time.Time
+		want        error
+	}{
+		{"Self request", requester.Id, NewMoney(100, "USD"), expiresAt, ErrSelfTransferInvalid},
+		{"Unknown payer", 999, NewMoney(100, "USD"), expiresAt, ErrUserNotFound},
+		{"No wallet to be paid into", payer.Id, NewMoney(100, "EUR"), expiresAt, ErrWalletNotFound},
+		{"Zero amount", payer.Id, NewMoney(0, "USD"), expiresAt, ErrInvalidAmount},
+		{"Expired already", payer.Id, NewMoney(100, "USD"), time.Now().Add(-time.Minute), ErrBadInput},
+		{"Expires too late", payer.Id, NewMoney(100, "USD"), time.Now().Add(PAYMENT_REQUEST_MAX_TTL + time.Hour), ErrBadInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.CreatePaymentRequest(ctx, requester.Id, tt.payerUserId, tt.amount, tt.expiresAt, "")
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+}
+
+func TestExpirePaymentRequests(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	requester := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	payer := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	for _, user := range []*User{&requester, &payer} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+
+	request, err := model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(300, "USD"), time.Now().Add(time.Minute), "")
+	assert.NoError(t, err)
+
+	_, err = model.CreatePaymentRequest(ctx, requester.Id, payer.Id, NewMoney(200, "USD"), time.Now().Add(time.Hour), "")
+	assert.NoError(t, err)
+
+	// Past its expiry but not marked yet
+	assert.NoError(t, db.Model(&PaymentRequest{}).Where("id = ?", request.Id).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+	_, err = model.AcceptPaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestExpired), "expected ErrPaymentRequestExpired, got %v", err)
+
+	pending, err := model.GetPaymentRequests(ctx, payer.Id, PAYMENT_REQUEST_ROLE_ANY, PAYMENT_REQUEST_STATUS_PENDING, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	expired, err := model.GetPaymentRequests(ctx, payer.Id, PAYMENT_REQUEST_ROLE_ANY, PAYMENT_REQUEST_STATUS_EXPIRED, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_EXPIRED, expired[0].Status)
+
+	count, err := model.ExpirePaymentRequests(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	request, err = model.GetPaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, PAYMENT_REQUEST_STATUS_EXPIRED, request.Status)
+
+	_, err = model.DeclinePaymentRequest(ctx, payer.Id, request.RequestUUID)
+	assert.True(t, errors.Is(err, ErrPaymentRequestNotPending), "expected ErrPaymentRequestNotPending, got %v", err)
+}
@@ -0,0 +1,347 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TransferStatus int
+
+const (
+	TRANSFER_STATUS_PENDING TransferStatus = iota + 1
+	TRANSFER_STATUS_PROCESSING
+	TRANSFER_STATUS_SUCCEEDED
+	TRANSFER_STATUS_FAILED
+)
+
+func (s TransferStatus) String() string {
+	switch s {
+	case TRANSFER_STATUS_PENDING:
+		return "pending"
+	case TRANSFER_STATUS_PROCESSING:
+		return "processing"
+	case TRANSFER_STATUS_SUCCEEDED:
+		return "succeeded"
+	case TRANSFER_STATUS_FAILED:
+		return "failed"
+	default:
+		return "-"
+	}
+}
+
+// Worker took too long and the transfer was claimed by another worker
+var ErrTransferClaimLost = errors.New("transfer claim lost")
+
+// Outbox of accepted v2 transfers, survives restarts until a worker acks it
+// Kept after the ack so users can look up how their transfer went
+type PendingTransfer struct {
+	Base
+	TransferUUID  string         `gorm:"uniqueIndex" json:"transfer_uuid"`
+	SourceUserId  uint64         `gorm:"index" json:"source_user_id"`
+	DestUserId    uint64         `json:"dest_user_id"`
+	Currency      string         `gorm:"default:USD" json:"currency"`
+	Amount        Money          `json:"amount"`
+	Memo          string         `json:"memo"`
+	Status        TransferStatus `gorm:"index" json:"status"`
+	FailureReason string         `json:"failure_reason"`
+	Attempts      int            `json:"attempts"`
+
+	// Set on every claim, a worker can only ack the transfer with the token it claimed it with
+	ClaimToken     string     `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
+
+	// Set when retrying, not claimed again before then
+	NextAttemptAt *time.Time `json:"-"`
+}
+
+func (*PendingTransfer) TableName() string {
+	return "pending_transfers"
+}
+
+func (t *PendingTransfer) AfterFind(tx *gorm.DB) error {
+	t.Amount.Currency = t.Currency
+	return nil
+}
+
+// Transfers that exhausted their retries, kept for operators to inspect and replay
+type DeadLetterTransfer struct {
+	Base
+	PendingTransferId uint64     `gorm:"index" json:"pending_transfer_id"`
+	TransferUUID      string     `json:"transfer_uuid"`
+	Attempts          int        `json:"attempts"`
+	LastError         string     `json:"last_error"`
+	ReplayedAt        *time.Time `json:"replayed_at"`
+}
+
+func (*DeadLetterTransfer) TableName() string {
+	return "dead_letter_transfers"
+}
+
+func (m *Model) EnqueueTransfer(ctx context.Context, sourceUserId, destUserId uint64, amount Money, memo string) (PendingTransfer, error) {
+	transfer, err := enqueueTransfer(m.db.WithContext(ctx), sourceUserId, destUserId, amount, memo)
+	if err != nil {
+		return transfer, fmt.Errorf("failed to enqueue transfer: %w", err)
+	}
+
+	return transfer, nil
+}
+
+// Writes the pending transfer within the caller's DB transaction, so it can be committed together with other writes
+func enqueueTransfer(tx *gorm.DB, sourceUserId, destUserId uint64, amount Money, memo string) (PendingTransfer, error) {
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+
+	memo, err = normalizeMemo(memo)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+
+	transfer := PendingTransfer{
+		TransferUUID: uuid.New().String(),
+		SourceUserId: sourceUserId,
+		DestUserId:   destUserId,
+		Currency:     amount.Currency,
+		Amount:       amount,
+		Memo:         memo,
+		Status:       TRANSFER_STATUS_PENDING,
+	}
+
+	return transfer, tx.Create(&transfer).Error
+}
+
+// Only the user who submitted the transfer can look it up
+func (m *Model) GetTransfer(ctx context.Context, userId uint64, transferUUID string) (PendingTransfer, error) {
+	var transfer PendingTransfer
+
+	err := m.db.WithContext(ctx).
+		Where("transfer_uuid = ? AND source_user_id = ?", transferUUID, userId).
+		First(&transfer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return transfer, ErrTransferNotFound
+	}
+	if err != nil {
+		return transfer, fmt.Errorf("failed to get transfer: %w", err)
+	}
+
+	return transfer, nil
+}
+
+// Claims pending transfers, and transfers whose lease expired because the worker crashed
+// SKIP LOCKED so concurrent workers never claim the same transfer
+func (m *Model) ClaimTransfers(ctx context.Context, limit int, lease time.Duration) ([]TransferJob, error) {
+	var jobs []TransferJob
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var transfers []PendingTransfer
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", TRANSFER_STATUS_PENDING, now).
+			Or("status = ? AND lease_expires_at < ?", TRANSFER_STATUS_PROCESSING, now).
+			Order("id").
+			Limit(limit).
+			Find(&transfers).Error; err != nil {
+			return err
+		}
+
+		leaseExpiresAt := now.Add(lease)
+
+		for _, transfer := range transfers {
+			claimToken := uuid.New().String()
+
+			if err := tx.Model(&transfer).Updates(map[string]interface{}{
+				"status":           TRANSFER_STATUS_PROCESSING,
+				"attempts":         gorm.Expr("attempts + 1"),
+				"claim_token":      claimToken,
+				"lease_expires_at": leaseExpiresAt,
+			}).Error; err != nil {
+				return err
+			}
+
+			jobs = append(jobs, TransferJob{
+				Ctx:          ctx,
+				Id:           transfer.Id,
+				ClaimToken:   claimToken,
+				Attempts:     transfer.Attempts + 1,
+				SourceUserId: transfer.SourceUserId,
+				DestUserId:   transfer.DestUserId,
+				Amount:       transfer.Amount,
+				Memo:         transfer.Memo,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim transfers: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// Runs the transfer and acks the job in the same DB transaction
+// A redelivered job can never move money twice
+func (m *Model) ExecuteTransfer(ctx context.Context, job TransferJob) error {
+	ctx, lg := trace.Logger(ctx)
+
+	lg.Info(fmt.Sprintf("Starts transferring %s from user_id %d to user_id %d", job.Amount, job.SourceUserId, job.DestUserId))
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockClaimedTransfer(tx, job); err != nil {
+			return err
+		}
+
+		if _, err := m.transferBalance(ctx, tx, job.SourceUserId, job.DestUserId, job.Amount, job.Memo); err != nil {
+			return err
+		}
+
+		return tx.Model(&PendingTransfer{}).Where("id = ?", job.Id).Updates(map[string]interface{}{
+			"status":           TRANSFER_STATUS_SUCCEEDED,
+			"lease_expires_at": nil,
+		}).Error
+	})
+}
+
+// Acks the job as failed with the reason users will see, it will not be delivered again
+func (m *Model) FailTransfer(ctx context.Context, job TransferJob, cause error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockClaimedTransfer(tx, job); err != nil {
+			return err
+		}
+
+		return tx.Model(&PendingTransfer{}).Where("id = ?", job.Id).Updates(map[string]interface{}{
+			"status":           TRANSFER_STATUS_FAILED,
+			"failure_reason":   failureReason(cause),
+			"lease_expires_at": nil,
+		}).Error
+	})
+}
+
+// Releases the job back to pending, to be claimed again after delay
+func (m *Model) RetryTransfer(ctx context.Context, job TransferJob, delay time.Duration, cause error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockClaimedTransfer(tx, job); err != nil {
+			return err
+		}
+
+		return tx.Model(&PendingTransfer{}).Where("id = ?", job.Id).Updates(map[string]interface{}{
+			"status":           TRANSFER_STATUS_PENDING,
+			"failure_reason":   failureReason(cause),
+			"next_attempt_at":  time.Now().Add(delay),
+			"lease_expires_at": nil,
+		}).Error
+	})
+}
+
+// Acks the job as failed and keeps it in dead_letter_transfers for operators
+func (m *Model) DeadLetterTransfer(ctx context.Context, job TransferJob, cause error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockClaimedTransfer(tx, job); err != nil {
+			return err
+		}
+
+		var transfer PendingTransfer
+		if err := tx.Where("id = ?", job.Id).First(&transfer).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&transfer).Updates(map[string]interface{}{
+			"status":           TRANSFER_STATUS_FAILED,
+			"failure_reason":   failureReason(cause),
+			"lease_expires_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&DeadLetterTransfer{
+			PendingTransferId: transfer.Id,
+			TransferUUID:      transfer.TransferUUID,
+			Attempts:          transfer.Attempts,
+			LastError:         cause.Error(),
+		}).Error
+	})
+}
+
+func (m *Model) GetDeadLetterTransfers(ctx context.Context, pageInfo PageInfo) ([]DeadLetterTransfer, error) {
+	var deadLetters []DeadLetterTransfer
+
+	if pageInfo.Page < 1 {
+		pageInfo.Page = 1
+	}
+
+	if pageInfo.PageSize == 0 || pageInfo.PageSize > 100 {
+		pageInfo.PageSize = 30
+	}
+
+	if err := m.db.WithContext(ctx).
+		Where("replayed_at IS NULL").
+		Order("id desc").
+		Offset((pageInfo.Page - 1) * pageInfo.PageSize).
+		Limit(pageInfo.PageSize).
+		Find(&deadLetters).Error; err != nil {
+		return nil, fmt.Errorf("failed to get dead letter transfers: %w", err)
+	}
+
+	return deadLetters, nil
+}
+
+// Puts the dead lettered transfer back to pending with a fresh set of retries
+func (m *Model) ReplayDeadLetterTransfer(ctx context.Context, deadLetterId uint64) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var deadLetter DeadLetterTransfer
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", deadLetterId).First(&deadLetter).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTransferNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if deadLetter.ReplayedAt != nil {
+			return ErrTransferAlreadyReplayed
+		}
+
+		if err := tx.Model(&PendingTransfer{}).Where("id = ?", deadLetter.PendingTransferId).Updates(map[string]interface{}{
+			"status":          TRANSFER_STATUS_PENDING,
+			"failure_reason":  "",
+			"attempts":        0,
+			"next_attempt_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&deadLetter).Update("replayed_at", time.Now()).Error
+	})
+}
+
+// Client error codes are safe to show, anything else might leak internals
+func failureReason(err error) string {
+	clientErr := &ClientError{}
+	if errors.As(err, &clientErr) {
+		return clientErr.Code
+	}
+
+	return "internal_error"
+}
+
+func lockClaimedTransfer(tx *gorm.DB, job TransferJob) error {
+	var transfer PendingTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", job.Id).First(&transfer).Error; err != nil {
+		return err
+	}
+
+	if transfer.Status != TRANSFER_STATUS_PROCESSING || transfer.ClaimToken != job.ClaimToken {
+		return ErrTransferClaimLost
+	}
+
+	return nil
+}
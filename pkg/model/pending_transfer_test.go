@@ -0,0 +1,211 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTransferUsers(t *testing.T, model *Model, sourceBalance int64) (User, User) {
+	source := User{Name: "User A", Email: "user_a@crypto.com"}
+	dest := User{Name: "User B", Email: "user_b@crypto.com"}
+	assert.NoError(t, model.db.Create(&source).Error)
+	assert.NoError(t, model.db.Create(&dest).Error)
+	assert.NoError(t, model.db.Create(&Wallet{UserId: source.Id}).Error)
+	assert.NoError(t, model.db.Create(&Wallet{UserId: dest.Id}).Error)
+
+	if sourceBalance > 0 {
+		_, err := model.Deposit(context.Background(), source.Id, NewMoney(sourceBalance, "USD"))
+		assert.NoError(t, err)
+	}
+
+	return source, dest
+}
+
+func TestPendingTransferClaimAndExecute(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	ctx := context.Background()
+
+	source, dest := setupTransferUsers(t, model, 200)
+
+	_, err := model.EnqueueTransfer(ctx, source.Id, dest.Id, NewMoney(150, "USD"), "")
+	assert.NoError(t, err)
+
+	jobs, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	// Already claimed, not delivered twice
+	again, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	err = model.ExecuteTransfer(ctx, jobs[0])
+	assert.NoError(t, err)
+
+	// Acked, executing again must not move money twice
+	err = model.ExecuteTransfer(ctx, jobs[0])
+	assert.True(t, errors.Is(err, ErrTransferClaimLost), "expected ErrTransferClaimLost, got %v", err)
+
+	balance, err := model.GetWalletBalance(ctx, dest.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), balance.Amount)
+
+	var transfer PendingTransfer
+	assert.NoError(t, db.First(&transfer, jobs[0].Id).Error)
+	assert.Equal(t, TRANSFER_STATUS_SUCCEEDED, transfer.Status)
+	assert.Empty(t, transfer.FailureReason)
+	assert.Equal(t, 1, transfer.Attempts)
+}
+
+func TestPendingTransferRedeliveredAfterLeaseExpires(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	ctx := context.Background()
+
+	source, dest := setupTransferUsers(t, model, 200)
+
+	_, err := model.EnqueueTransfer(ctx, source.Id, dest.Id, NewMoney(100, "USD"), "")
+	assert.NoError(t, err)
+
+	// Worker claims and crashes without acking
+	crashed, err := model.ClaimTransfers(ctx, 10, -time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, crashed, 1)
+
+	redelivered, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, redelivered, 1)
+	assert.Equal(t, crashed[0].Id, redelivered[0].Id)
+
+	// Crashed worker coming back cannot ack a transfer it no longer owns
+	err = model.ExecuteTransfer(ctx, crashed[0])
+	assert.True(t, errors.Is(err, ErrTransferClaimLost), "expected ErrTransferClaimLost, got %v", err)
+
+	err = model.ExecuteTransfer(ctx, redelivered[0])
+	assert.NoError(t, err)
+
+	balance, err := model.GetWalletBalance(ctx, dest.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), balance.Amount)
+}
+
+func TestPendingTransferFailed(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	ctx := context.Background()
+
+	source, dest := setupTransferUsers(t, model, 50)
+
+	pending, err := model.EnqueueTransfer(ctx, source.Id, dest.Id, NewMoney(100, "USD"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSFER_STATUS_PENDING, pending.Status)
+
+	jobs, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	err = model.ExecuteTransfer(ctx, jobs[0])
+	assert.True(t, errors.Is(err, ErrBalanceInsufficient), "expected ErrBalanceInsufficient, got %v", err)
+
+	err = model.FailTransfer(ctx, jobs[0], err)
+	assert.NoError(t, err)
+
+	transfer, err := model.GetTransfer(ctx, source.Id, pending.TransferUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSFER_STATUS_FAILED, transfer.Status)
+	assert.Equal(t, "balance_insufficient", transfer.FailureReason)
+
+	// Only visible to the user who submitted it
+	_, err = model.GetTransfer(ctx, dest.Id, pending.TransferUUID)
+	assert.True(t, errors.Is(err, ErrTransferNotFound), "expected ErrTransferNotFound, got %v", err)
+
+	// Failed transfers are not delivered again
+	again, err := model.ClaimTransfers(ctx, 10, -time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+}
+
+func TestPendingTransferRetryAndDeadLetter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	ctx := context.Background()
+
+	source, dest := setupTransferUsers(t, model, 200)
+
+	pending, err := model.EnqueueTransfer(ctx, source.Id, dest.Id, NewMoney(100, "USD"), "")
+	assert.NoError(t, err)
+
+	jobs, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	cause := errors.New("deadlock detected")
+
+	err = model.RetryTransfer(ctx, jobs[0], time.Hour, cause)
+	assert.NoError(t, err)
+
+	// Not claimed again before the backoff delay
+	jobs, err = model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	db.Model(&PendingTransfer{}).Where("id = ?", pending.Id).Update("next_attempt_at", time.Now().Add(-time.Second))
+
+	jobs, err = model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, 2, jobs[0].Attempts)
+
+	err = model.DeadLetterTransfer(ctx, jobs[0], cause)
+	assert.NoError(t, err)
+
+	transfer, err := model.GetTransfer(ctx, source.Id, pending.TransferUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSFER_STATUS_FAILED, transfer.Status)
+	assert.Equal(t, "internal_error", transfer.FailureReason)
+
+	deadLetters, err := model.GetDeadLetterTransfers(ctx, PageInfo{Page: 1, PageSize: 10})
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 1)
+	assert.Equal(t, "deadlock detected", deadLetters[0].LastError)
+	assert.Equal(t, 2, deadLetters[0].Attempts)
+
+	err = model.ReplayDeadLetterTransfer(ctx, deadLetters[0].Id)
+	assert.NoError(t, err)
+
+	err = model.ReplayDeadLetterTransfer(ctx, deadLetters[0].Id)
+	assert.True(t, errors.Is(err, ErrTransferAlreadyReplayed), "expected ErrTransferAlreadyReplayed, got %v", err)
+
+	jobs, err = model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, 1, jobs[0].Attempts)
+
+	err = model.ExecuteTransfer(ctx, jobs[0])
+	assert.NoError(t, err)
+
+	balance, err := model.GetWalletBalance(ctx, dest.Id, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), balance.Amount)
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Mid market rates in major units, e.g. 1 USD = 0.92 EUR, before the house spread
+type RateProvider interface {
+	GetRate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// Rates used when no rates file is configured, for local testing only
+var DEFAULT_FX_RATES = map[string]string{
+	"USD/EUR": "0.92",
+	"USD/GBP": "0.79",
+	"USD/SGD": "1.35",
+	"USD/MYR": "4.72",
+	"USD/JPY": "151.4",
+	"USD/KRW": "1365",
+	"USD/BHD": "0.376",
+}
+
+// Fixed rates keyed by "FROM/TO", the inverse rate is derived when only one direction is given
+type StaticRateProvider struct {
+	rates map[string]*big.Rat
+}
+
+var _ = RateProvider(&StaticRateProvider{})
+
+func NewStaticRateProvider(rates map[string]string) (*StaticRateProvider, error) {
+	p := &StaticRateProvider{
+		rates: make(map[string]*big.Rat, len(rates)),
+	}
+
+	for pair, value := range rates {
+		from, to, ok := strings.Cut(strings.ToUpper(pair), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid currency pair %q, expected FROM/TO", pair)
+		}
+
+		if _, err := ParseCurrency(from); err != nil {
+			return nil, fmt.Errorf("invalid currency pair %q: %w", pair, err)
+		}
+		if _, err := ParseCurrency(to); err != nil {
+			return nil, fmt.Errorf("invalid currency pair %q: %w", pair, err)
+		}
+
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s", value, pair)
+		}
+
+		p.rates[from+"/"+to] = rate
+	}
+
+	return p, nil
+}
+
+// Reads rates from a JSON file in the form of {"USD/EUR": "0.92"}
+func NewFileRateProvider(path string) (*StaticRateProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file: %w", err)
+	}
+
+	var rates map[string]string
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("failed to parse rates file: %w", err)
+	}
+
+	return NewStaticRateProvider(rates)
+}
+
+func (p *StaticRateProvider) GetRate(ctx context.Context, from, to string) (*big.Rat, error) {
+	if rate, ok := p.rates[from+"/"+to]; ok {
+		return new(big.Rat).Set(rate), nil
+	}
+
+	if rate, ok := p.rates[to+"/"+from]; ok {
+		return new(big.Rat).Inv(rate), nil
+	}
+
+	return nil, ErrRateUnavailable
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticRateProvider(t *testing.T) {
+	rates, err := NewStaticRateProvider(map[string]string{"USD/JPY": "150"})
+	assert.NoError(t, err)
+
+	rate, err := rates.GetRate(context.Background(), "USD", "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, "150", rate.RatString())
+
+	// Inverse is derived from the other direction
+	rate, err = rates.GetRate(context.Background(), "JPY", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "1/150", rate.RatString())
+
+	_, err = rates.GetRate(context.Background(), "USD", "EUR")
+	assert.True(t, errors.Is(err, ErrRateUnavailable))
+
+	_, err = NewStaticRateProvider(map[string]string{"USD/XXX": "1"})
+	assert.Error(t, err)
+	_, err = NewStaticRateProvider(map[string]string{"USD/EUR": "-1"})
+	assert.Error(t, err)
+}
+
+func TestFileRateProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"usd/eur": "0.92"}`), 0o600))
+
+	rates, err := NewFileRateProvider(path)
+	assert.NoError(t, err)
+
+	rate, err := rates.GetRate(context.Background(), "USD", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.92000000", rate.FloatString(8))
+}
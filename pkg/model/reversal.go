@@ -0,0 +1,147 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Moves the funds of a transaction back with a REVERSAL entry negating every one of its postings, all or nothing
+// A transaction is reversed at most once and reversals cannot be reversed themselves
+// Like adjustments, limits do not apply and frozen wallets can be reversed, closed wallets cannot
+// Returns the reversal with the user wallets it moved funds of, balances after the reversal
+func (m *Model) ReverseTransaction(ctx context.Context, transactionUUID string, actorUserId uint64, reason string) (JournalEntry, []Wallet, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var reversal JournalEntry
+	var wallets []Wallet
+
+	reason, err := normalizeMemo(reason)
+	if err != nil {
+		return reversal, nil, err
+	}
+
+	if reason == "" {
+		return reversal, nil, ErrBadInput
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locked so concurrent reversals of the same transaction queue up behind each other
+		var original JournalEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("transaction_uuid = ?", transactionUUID).First(&original).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if original.Type == TRANSACTION_TYPE_REVERSAL {
+			return ErrTransactionNotReversible
+		}
+
+		var reversalCount int64
+		if err := tx.Model(&JournalEntry{}).Where("reversal_of = ?", transactionUUID).Count(&reversalCount).Error; err != nil {
+			return err
+		}
+
+		if reversalCount > 0 {
+			return ErrTransactionAlreadyReversed
+		}
+
+		var postings []Transaction
+		if err := tx.Where("transaction_uuid = ?", transactionUUID).Order("id").Find(&postings).Error; err != nil {
+			return err
+		}
+
+		walletIds := make([]uint64, len(postings))
+		for i, posting := range postings {
+			walletIds[i] = posting.DestWalletId
+		}
+
+		var postingWallets []Wallet
+		if err := tx.Where("id IN ?", walletIds).Find(&postingWallets).Error; err != nil {
+			return err
+		}
+
+		// User wallets are locked the same way as transfers lock them, system wallets are never locked
+		var keys []walletKey
+		for _, wallet := range postingWallets {
+			if wallet.SystemAccount == "" {
+				keys = append(keys, walletKey{UserId: wallet.UserId, Currency: wallet.Currency})
+			}
+		}
+
+		wallets, err = lockWallets(tx, keys...)
+		if err != nil {
+			return err
+		}
+
+		lockedWallets := make(map[uint64]*Wallet, len(wallets))
+		for i := range wallets {
+			if wallets[i].Status == WALLET_STATUS_CLOSED {
+				return ErrWalletClosed
+			}
+			lockedWallets[wallets[i].Id] = &wallets[i]
+		}
+
+		reversePostings := make([]Posting, len(postings))
+		for i, posting := range postings {
+			amount, err := posting.Amount.Neg()
+			if err != nil {
+				return err
+			}
+
+			// Funds already spent or held by the recipient cannot be taken back
+			if wallet, ok := lockedWallets[posting.DestWalletId]; ok {
+				if amount.IsNegative() {
+					if err := checkSufficient(wallet.AvailableBalance, posting.Amount); err != nil {
+						return err
+					}
+				}
+
+				if wallet.Balance, err = wallet.Balance.Add(amount); err != nil {
+					return err
+				}
+
+				if wallet.AvailableBalance, err = wallet.AvailableBalance.Add(amount); err != nil {
+					return err
+				}
+			}
+
+			reversePostings[i] = Posting{
+				WalletId:             posting.DestWalletId,
+				CounterpartyWalletId: posting.SourceWalletId,
+				Amount:               amount,
+				Memo:                 reason,
+			}
+		}
+
+		reversal, err = postJournalEntry(tx, TRANSACTION_TYPE_REVERSAL, reversePostings...)
+		if err != nil {
+			return err
+		}
+
+		reversal.ReversalOf = transactionUUID
+		reversal.ActorUserId = actorUserId
+		if err := tx.Model(&reversal).Updates(map[string]interface{}{
+			"reversal_of":   reversal.ReversalOf,
+			"actor_user_id": reversal.ActorUserId,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Transaction{}).Where("transaction_uuid = ?", reversal.TransactionUUID).Update("reversal_of", transactionUUID).Error
+	})
+	if err != nil {
+		return reversal, nil, fmt.Errorf("failed to reverse transaction: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("transaction %s reversed by %s by user_id %d", transactionUUID, reversal.TransactionUUID, actorUserId))
+
+	return reversal, wallets, nil
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestReverseTransaction(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	admin := User{
+		Name:  "Admin",
+		Email: "admin@crypto.com",
+		Role:  USER_ROLE_ADMIN,
+	}
+	for _, user := range []*User{&source, &dest, &admin} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+
+	transfer := func(amount int64) string {
+		var entry JournalEntry
+		err := db.Transaction(func(tx *gorm.DB) (err error) {
+			entry, err = model.transferBalance(ctx, tx, source.Id, dest.Id, NewMoney(amount, "USD"), "Rent")
+			return err
+		})
+		assert.NoError(t, err)
+
+		return entry.TransactionUUID
+	}
+
+	balanceOf := func(walletId uint64) int64 {
+		var wallet Wallet
+		assert.NoError(t, db.First(&wallet, walletId).Error)
+		return wallet.Balance.Amount
+	}
+
+	transferUUID := transfer(300)
+
+	// Recipient's wallet is frozen, reversals still go through
+	_, err := model.FreezeWallet(ctx, dest.Wallets[0].Id, admin.Id, "Fraud")
+	assert.NoError(t, err)
+
+	reversal, wallets, err := model.ReverseTransaction(ctx, transferUUID, admin.Id, "Sent to the wrong user")
+	assert.NoError(t, err)
+	assert.Equal(t, TRANSACTION_TYPE_REVERSAL, reversal.Type)
+	assert.Equal(t, transferUUID, reversal.ReversalOf)
+	assert.Equal(t, admin.Id, reversal.ActorUserId)
+	assert.Len(t, wallets, 2)
+
+	assert.Equal(t, int64(1000), balanceOf(source.Wallets[0].Id))
+	assert.Equal(t, int64(0), balanceOf(dest.Wallets[0].Id))
+
+	_, postings, err := model.GetJournalEntry(ctx, reversal.TransactionUUID)
+	assert.NoError(t, err)
+	assert.Len(t, postings, 2)
+	for _, posting := range postings {
+		assert.Equal(t, transferUUID, posting.ReversalOf)
+		assert.Equal(t, "Sent to the wrong user", posting.Memo)
+	}
+
+	history, _, err := model.GetTransactionHistory(ctx, source.Id, "USD", TransactionFilter{Type: TRANSACTION_TYPE_REVERSAL})
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, int64(300), history[0].Amount.Amount)
+	assert.Equal(t, dest.Wallets[0].Id, history[0].SourceWalletId)
+
+	_, err = model.UnfreezeWallet(ctx, dest.Wallets[0].Id, admin.Id, "Cleared")
+	assert.NoError(t, err)
+
+	// Recipient spent part of it already
+	spentUUID := transfer(500)
+	_, err = model.Withdraw(ctx, dest.Id, NewMoney(100, "USD"))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		transactionUUID string
+		reason          string
+		want            error
+	}{
+		{"Already reversed", transferUUID, "Again", ErrTransactionAlreadyReversed},
+		{"Reversal", reversal.TransactionUUID, "Undo", ErrTransactionNotReversible},
+		{"Funds spent", spentUUID, "Wrong user", ErrBalanceInsufficient},
+		{"Unknown transaction", "no-such-uuid", "Wrong user", ErrTransactionNotFound},
+		{"Without reason", spentUUID, "", ErrBadInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := model.ReverseTransaction(ctx, tt.transactionUUID, admin.Id, tt.reason)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// Nothing moved by the failed attempts
+	assert.Equal(t, int64(500), balanceOf(source.Wallets[0].Id))
+	assert.Equal(t, int64(400), balanceOf(dest.Wallets[0].Id))
+}
@@ -0,0 +1,504 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Shortest gap between two runs of a schedule, cron expressions included
+	SCHEDULED_TRANSFER_MIN_INTERVAL = time.Hour
+
+	// Due schedules dispatched per scheduler run
+	SCHEDULED_TRANSFER_DISPATCH_BATCH_SIZE = 100
+
+	// Missed occurrences of one schedule enqueued per scheduler run, the rest stay due for the next runs
+	SCHEDULED_TRANSFER_MAX_CATCH_UP = 24
+)
+
+type ScheduledTransferStatus int
+
+const (
+	SCHEDULED_TRANSFER_STATUS_ACTIVE ScheduledTransferStatus = iota + 1
+	SCHEDULED_TRANSFER_STATUS_COMPLETED
+	SCHEDULED_TRANSFER_STATUS_CANCELLED
+)
+
+func (s ScheduledTransferStatus) String() string {
+	switch s {
+	case SCHEDULED_TRANSFER_STATUS_ACTIVE:
+		return "active"
+	case SCHEDULED_TRANSFER_STATUS_COMPLETED:
+		return "completed"
+	case SCHEDULED_TRANSFER_STATUS_CANCELLED:
+		return "cancelled"
+	default:
+		return "-"
+	}
+}
+
+// Transfer repeated on a cron expression or a fixed interval until its end condition is met
+// Every run enqueues a pending transfer, so runs go through TransferWorkerPool like v2 transfers
+type ScheduledTransfer struct {
+	Base
+	ScheduleUUID string                  `gorm:"uniqueIndex" json:"schedule_uuid"`
+	SourceUserId uint64                  `gorm:"index" json:"source_user_id"`
+	DestUserId   uint64                  `json:"dest_user_id"`
+	Currency     string                  `json:"currency"`
+	Amount       Money                   `json:"amount"`
+	Memo         string                  `json:"memo"`
+	Status       ScheduledTransferStatus `gorm:"index:idx_scheduled_transfers_status_next_run_at" json:"status"`
+
+	// Exactly one of them is set, cron expressions are in UTC unless prefixed with CRON_TZ=
+	CronExpr        string `json:"cron_expr"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+
+	// Nil once the schedule is completed or cancelled
+	NextRunAt *time.Time `gorm:"index:idx_scheduled_transfers_status_next_run_at" json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+
+	// Ends after whichever comes first, zero values never end
+	EndsAt   *time.Time `json:"ends_at"`
+	MaxRuns  int        `json:"max_runs"`
+	RunCount int        `json:"run_count"`
+}
+
+func (*ScheduledTransfer) TableName() string {
+	return "scheduled_transfers"
+}
+
+func (t *ScheduledTransfer) AfterFind(tx *gorm.DB) error {
+	t.Amount.Currency = t.Currency
+	return nil
+}
+
+// One run of a schedule, the outcome is the one of the pending transfer it enqueued
+type ScheduledTransferRun struct {
+	Base
+	ScheduledTransferId uint64    `gorm:"index" json:"scheduled_transfer_id"`
+	ScheduledFor        time.Time `json:"scheduled_for"`
+	TransferUUID        string    `json:"transfer_uuid"`
+
+	// Read from the pending transfer
+	Status        TransferStatus `gorm:"-" json:"status"`
+	FailureReason string         `gorm:"-" json:"failure_reason"`
+}
+
+func (*ScheduledTransferRun) TableName() string {
+	return "scheduled_transfer_runs"
+}
+
+// What the user asks for, on create and on update
+type ScheduledTransferSpec struct {
+	DestUserId uint64
+	Amount     Money
+	Memo       string
+
+	// Exactly one of them
+	CronExpr string
+	Interval time.Duration
+
+	// First run no earlier than StartAt, defaults to now
+	StartAt time.Time
+	EndsAt  *time.Time
+	MaxRuns int
+}
+
+func (m *Model) CreateScheduledTransfer(ctx context.Context, sourceUserId uint64, spec ScheduledTransferSpec) (ScheduledTransfer, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	scheduled := ScheduledTransfer{
+		ScheduleUUID: uuid.New().String(),
+		SourceUserId: sourceUserId,
+		Status:       SCHEDULED_TRANSFER_STATUS_ACTIVE,
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := scheduled.apply(tx, spec, time.Now()); err != nil {
+			return err
+		}
+
+		return tx.Create(&scheduled).Error
+	})
+	if err != nil {
+		return scheduled, fmt.Errorf("failed to create scheduled transfer: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("scheduled transfer %s of %s from user_id %d to user_id %d, next run at %s", scheduled.ScheduleUUID, scheduled.Amount, sourceUserId, scheduled.DestUserId, scheduled.NextRunAt))
+
+	return scheduled, nil
+}
+
+// Replaces the whole spec, the next run is worked out again from now
+// Runs already made still count towards MaxRuns
+func (m *Model) UpdateScheduledTransfer(ctx context.Context, sourceUserId uint64, scheduleUUID string, spec ScheduledTransferSpec) (ScheduledTransfer, error) {
+	var scheduled ScheduledTransfer
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		scheduled, err = lockScheduledTransfer(tx, sourceUserId, scheduleUUID)
+		if err != nil {
+			return err
+		}
+
+		if scheduled.Status != SCHEDULED_TRANSFER_STATUS_ACTIVE {
+			return ErrScheduledTransferNotActive
+		}
+
+		if err := scheduled.apply(tx, spec, time.Now()); err != nil {
+			return err
+		}
+
+		return tx.Save(&scheduled).Error
+	})
+	if err != nil {
+		return scheduled, fmt.Errorf("failed to update scheduled transfer: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+// Stops future runs, transfers already enqueued by past runs still go through
+func (m *Model) CancelScheduledTransfer(ctx context.Context, sourceUserId uint64, scheduleUUID string) (ScheduledTransfer, error) {
+	var scheduled ScheduledTransfer
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		scheduled, err = lockScheduledTransfer(tx, sourceUserId, scheduleUUID)
+		if err != nil {
+			return err
+		}
+
+		if scheduled.Status != SCHEDULED_TRANSFER_STATUS_ACTIVE {
+			return ErrScheduledTransferNotActive
+		}
+
+		scheduled.Status = SCHEDULED_TRANSFER_STATUS_CANCELLED
+		scheduled.NextRunAt = nil
+
+		return tx.Model(&scheduled).Updates(map[string]interface{}{
+			"status":      scheduled.Status,
+			"next_run_at": nil,
+		}).Error
+	})
+	if err != nil {
+		return scheduled, fmt.Errorf("failed to cancel scheduled transfer: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+// Only the user paying can look it up
+func (m *Model) GetScheduledTransfer(ctx context.Context, sourceUserId uint64, scheduleUUID string) (ScheduledTransfer, error) {
+	var scheduled ScheduledTransfer
+
+	err := m.db.WithContext(ctx).Where("schedule_uuid = ? AND source_user_id = ?", scheduleUUID, sourceUserId).First(&scheduled).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return scheduled, ErrScheduledTransferNotFound
+	}
+	if err != nil {
+		return scheduled, fmt.Errorf("failed to get scheduled transfer: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+// Newest first, cancelled and completed ones included
+func (m *Model) GetScheduledTransfers(ctx context.Context, sourceUserId uint64, pageInfo PageInfo) ([]ScheduledTransfer, error) {
+	var scheduled []ScheduledTransfer
+
+	if pageInfo.Page < 1 {
+		pageInfo.Page = 1
+	}
+
+	if pageInfo.PageSize < 1 || pageInfo.PageSize > 100 {
+		pageInfo.PageSize = 30
+	}
+
+	if err := m.db.WithContext(ctx).
+		Where("source_user_id = ?", sourceUserId).
+		Order("id desc").
+		Offset((pageInfo.Page - 1) * pageInfo.PageSize).
+		Limit(pageInfo.PageSize).
+		Find(&scheduled).Error; err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfers: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+// Newest first, with the outcome of the transfer each run enqueued
+func (m *Model) GetScheduledTransferRuns(ctx context.Context, sourceUserId uint64, scheduleUUID string, pageInfo PageInfo) ([]ScheduledTransferRun, error) {
+	scheduled, err := m.GetScheduledTransfer(ctx, sourceUserId, scheduleUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pageInfo.Page < 1 {
+		pageInfo.Page = 1
+	}
+
+	if pageInfo.PageSize < 1 || pageInfo.PageSize > 100 {
+		pageInfo.PageSize = 30
+	}
+
+	db := m.db.WithContext(ctx)
+
+	var runs []ScheduledTransferRun
+	if err := db.
+		Where("scheduled_transfer_id = ?", scheduled.Id).
+		Order("id desc").
+		Offset((pageInfo.Page - 1) * pageInfo.PageSize).
+		Limit(pageInfo.PageSize).
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfer runs: %w", err)
+	}
+
+	transferUUIDs := make([]string, len(runs))
+	for i, run := range runs {
+		transferUUIDs[i] = run.TransferUUID
+	}
+
+	var transfers []PendingTransfer
+	if err := db.Where("transfer_uuid IN ?", transferUUIDs).Find(&transfers).Error; err != nil {
+		return nil, fmt.Errorf("failed to get scheduled transfer runs: %w", err)
+	}
+
+	byUUID := make(map[string]PendingTransfer, len(transfers))
+	for _, transfer := range transfers {
+		byUUID[transfer.TransferUUID] = transfer
+	}
+
+	for i := range runs {
+		transfer := byUUID[runs[i].TransferUUID]
+		runs[i].Status = transfer.Status
+		runs[i].FailureReason = transfer.FailureReason
+	}
+
+	return runs, nil
+}
+
+// Enqueues a transfer for every schedule due at now, one DB transaction per schedule
+// After downtime every missed occurrence gets its own transfer, oldest first, then the schedule carries on as usual
+// Returns the number of transfers enqueued
+func (m *Model) DispatchScheduledTransfers(ctx context.Context, now time.Time) (int, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var scheduleUUIDs []string
+	if err := m.db.WithContext(ctx).
+		Model(&ScheduledTransfer{}).
+		Where("status = ? AND next_run_at <= ?", SCHEDULED_TRANSFER_STATUS_ACTIVE, now).
+		Order("next_run_at").
+		Limit(SCHEDULED_TRANSFER_DISPATCH_BATCH_SIZE).
+		Pluck("schedule_uuid", &scheduleUUIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to get due scheduled transfers: %w", err)
+	}
+
+	dispatched := 0
+	for _, scheduleUUID := range scheduleUUIDs {
+		var runs []ScheduledTransferRun
+
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var scheduled ScheduledTransfer
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("schedule_uuid = ?", scheduleUUID).First(&scheduled).Error
+			if err != nil {
+				return err
+			}
+
+			// Cancelled, updated or dispatched by another instance since it was picked up
+			if scheduled.Status != SCHEDULED_TRANSFER_STATUS_ACTIVE || scheduled.NextRunAt == nil || scheduled.NextRunAt.After(now) {
+				return nil
+			}
+
+			runs, err = scheduled.dispatch(tx, now)
+			return err
+		})
+		if err != nil {
+			lg.Error(fmt.Sprintf("failed to dispatch scheduled transfer %s: %v", scheduleUUID, err))
+			continue
+		}
+
+		if len(runs) > 1 {
+			lg.Info(fmt.Sprintf("scheduled transfer %s caught up on %d missed runs", scheduleUUID, len(runs)))
+		}
+
+		dispatched += len(runs)
+	}
+
+	return dispatched, nil
+}
+
+// Enqueues a transfer for each occurrence due at now and moves the schedule on to the next one
+// Stops at SCHEDULED_TRANSFER_MAX_CATCH_UP, the schedule is then still due and the next scheduler run carries on
+func (t *ScheduledTransfer) dispatch(tx *gorm.DB, now time.Time) ([]ScheduledTransferRun, error) {
+	schedule, err := t.schedule()
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []ScheduledTransferRun
+
+	next := *t.NextRunAt
+	for !t.endsAt(next) && !next.After(now) && len(runs) < SCHEDULED_TRANSFER_MAX_CATCH_UP {
+		transfer, err := enqueueTransfer(tx, t.SourceUserId, t.DestUserId, t.Amount, t.Memo)
+		if err != nil {
+			return nil, err
+		}
+
+		run := ScheduledTransferRun{
+			ScheduledTransferId: t.Id,
+			ScheduledFor:        next,
+			TransferUUID:        transfer.TransferUUID,
+			Status:              transfer.Status,
+		}
+		if err := tx.Create(&run).Error; err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+
+		t.RunCount++
+		next = schedule.Next(next)
+	}
+
+	t.LastRunAt = &now
+	t.NextRunAt = &next
+
+	if t.endsAt(next) {
+		t.Status = SCHEDULED_TRANSFER_STATUS_COMPLETED
+		t.NextRunAt = nil
+	}
+
+	return runs, tx.Model(t).Updates(map[string]interface{}{
+		"status":      t.Status,
+		"run_count":   t.RunCount,
+		"last_run_at": t.LastRunAt,
+		"next_run_at": t.NextRunAt,
+	}).Error
+}
+
+// Validates the spec and sets it on the schedule along with its first run from now
+func (t *ScheduledTransfer) apply(tx *gorm.DB, spec ScheduledTransferSpec, now time.Time) error {
+	amount, err := validateAmount(spec.Amount)
+	if err != nil {
+		return err
+	}
+
+	if t.SourceUserId == spec.DestUserId {
+		return ErrSelfTransferInvalid
+	}
+
+	memo, err := normalizeMemo(spec.Memo)
+	if err != nil {
+		return err
+	}
+
+	if (spec.CronExpr == "") == (spec.Interval == 0) || spec.Interval < 0 || spec.MaxRuns < 0 {
+		return ErrInvalidSchedule
+	}
+
+	if err := checkDestination(tx, spec.DestUserId, amount.Currency); err != nil {
+		return err
+	}
+
+	t.DestUserId = spec.DestUserId
+	t.Currency = amount.Currency
+	t.Amount = amount
+	t.Memo = memo
+	t.CronExpr = spec.CronExpr
+	t.IntervalSeconds = int64(spec.Interval / time.Second)
+	t.EndsAt = spec.EndsAt
+	t.MaxRuns = spec.MaxRuns
+
+	schedule, err := t.schedule()
+	if err != nil {
+		return err
+	}
+
+	startAt := spec.StartAt
+	if startAt.Before(now) {
+		startAt = now
+	}
+	startAt = startAt.Truncate(time.Second)
+
+	// Intervals run at StartAt first, cron expressions at their first occurrence from then on
+	nextRunAt := startAt
+	if t.CronExpr != "" {
+		nextRunAt = schedule.Next(startAt.Add(-time.Second))
+	}
+
+	if t.endsAt(nextRunAt) {
+		return ErrInvalidSchedule
+	}
+
+	// Bounds how often a cron expression can fire, intervals are checked the same way
+	if !t.keepsMinInterval(schedule, nextRunAt) {
+		return ErrInvalidSchedule
+	}
+
+	t.NextRunAt = &nextRunAt
+
+	return nil
+}
+
+// Checks every gap between runs over a year from the first, or until the schedule ends
+// Lists like "0 0,23 * * *" or "0,59 0 * * *" only come close together past the first run, across days or month ends
+func (t *ScheduledTransfer) keepsMinInterval(schedule cron.Schedule, firstRunAt time.Time) bool {
+	until := firstRunAt.AddDate(1, 0, 0)
+
+	run := firstRunAt
+	for runs := 1; t.MaxRuns == 0 || runs < t.MaxRuns; runs++ {
+		next := schedule.Next(run)
+		if next.IsZero() || next.After(until) || (t.EndsAt != nil && next.After(*t.EndsAt)) {
+			return true
+		}
+
+		if next.Sub(run) < SCHEDULED_TRANSFER_MIN_INTERVAL {
+			return false
+		}
+		run = next
+	}
+
+	return true
+}
+
+func (t *ScheduledTransfer) schedule() (cron.Schedule, error) {
+	if t.CronExpr == "" {
+		return cron.Every(time.Duration(t.IntervalSeconds) * time.Second), nil
+	}
+
+	schedule, err := cron.ParseStandard(t.CronExpr)
+	if err != nil {
+		return nil, ErrInvalidSchedule
+	}
+
+	// Parsed in the server's local time zone unless CRON_TZ= is given, keep schedules independent of where we run
+	if spec, ok := schedule.(*cron.SpecSchedule); ok && spec.Location == time.Local {
+		spec.Location = time.UTC
+	}
+
+	return schedule, nil
+}
+
+// Whether the schedule is over by the run at runAt, zero meaning it never comes
+func (t *ScheduledTransfer) endsAt(runAt time.Time) bool {
+	return runAt.IsZero() || (t.EndsAt != nil && runAt.After(*t.EndsAt)) || (t.MaxRuns > 0 && t.RunCount >= t.MaxRuns)
+}
+
+func lockScheduledTransfer(tx *gorm.DB, sourceUserId uint64, scheduleUUID string) (ScheduledTransfer, error) {
+	var scheduled ScheduledTransfer
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("schedule_uuid = ? AND source_user_id = ?", scheduleUUID, sourceUserId).First(&scheduled).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return scheduled, ErrScheduledTransferNotFound
+	}
+
+	return scheduled, err
+}
@@ -0,0 +1,341 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateScheduledTransfer(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	// First runs at 00:59 and 00:45, a day and 11h15m before the next, the short gaps come after
+	halfPastMidnight := now.UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 30*time.Minute)
+
+	scheduled, err := model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(50, "USD"),
+		CronExpr:   "0 0 1 * *",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEDULED_TRANSFER_STATUS_ACTIVE, scheduled.Status)
+	assert.Equal(t, 1, scheduled.NextRunAt.UTC().Day())
+	assert.Equal(t, 0, scheduled.NextRunAt.UTC().Hour())
+	assert.True(t, scheduled.NextRunAt.After(now))
+
+	tests := []struct {
+		name string
+		spec ScheduledTransferSpec
+		want error
+	}{
+		{"Neither cron nor interval", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD")}, ErrInvalidSchedule},
+		{"Both cron and interval", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), CronExpr: "@daily", Interval: 24 * time.Hour}, ErrInvalidSchedule},
+		{"Malformed cron", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), CronExpr: "every monday"}, ErrInvalidSchedule},
+		{"Cron too often", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), CronExpr: "*/5 * * * *"}, ErrInvalidSchedule},
+		{"Cron close together past the first run", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), CronExpr: "0,59 0 * * *", StartAt: halfPastMidnight}, ErrInvalidSchedule},
+		{"Cron hours close together past the first run", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), CronExpr: "0,45 0,12 * * *", StartAt: halfPastMidnight}, ErrInvalidSchedule},
+		{"Interval too short", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), Interval: time.Minute}, ErrInvalidSchedule},
+		{"Ended already", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(50, "USD"), Interval: 24 * time.Hour, EndsAt: &past}, ErrInvalidSchedule},
+		{"Self transfer", ScheduledTransferSpec{DestUserId: source.Id, Amount: NewMoney(50, "USD"), Interval: 24 * time.Hour}, ErrSelfTransferInvalid},
+		{"Unknown destination", ScheduledTransferSpec{DestUserId: 999, Amount: NewMoney(50, "USD"), Interval: 24 * time.Hour}, ErrDestinationNotFound},
+		{"Zero amount", ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(0, "USD"), Interval: 24 * time.Hour}, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.CreateScheduledTransfer(ctx, source.Id, tt.spec)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// Only the payer sees it
+	_, err = model.GetScheduledTransfer(ctx, dest.Id, scheduled.ScheduleUUID)
+	assert.True(t, errors.Is(err, ErrScheduledTransferNotFound), "expected ErrScheduledTransferNotFound, got %v", err)
+
+	scheduled, err = model.UpdateScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(75, "USD"),
+		Interval:   7 * 24 * time.Hour,
+		StartAt:    now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(75), scheduled.Amount.Amount)
+	assert.Empty(t, scheduled.CronExpr)
+	assert.Equal(t, now.Add(time.Hour).Truncate(time.Second).Unix(), scheduled.NextRunAt.Unix())
+
+	scheduled, err = model.CancelScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEDULED_TRANSFER_STATUS_CANCELLED, scheduled.Status)
+	assert.Nil(t, scheduled.NextRunAt)
+
+	_, err = model.UpdateScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID, ScheduledTransferSpec{DestUserId: dest.Id, Amount: NewMoney(75, "USD"), Interval: 24 * time.Hour})
+	assert.True(t, errors.Is(err, ErrScheduledTransferNotActive), "expected ErrScheduledTransferNotActive, got %v", err)
+
+	list, err := model.GetScheduledTransfers(ctx, source.Id, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	// Lists are fine as long as every gap keeps the minimum interval
+	_, err = model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(50, "USD"),
+		CronExpr:   "0 9,17 * * 1-5",
+	})
+	assert.NoError(t, err)
+
+	// 23:00 to midnight is the minimum interval itself
+	_, err = model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(50, "USD"),
+		CronExpr:   "0 0,23 * * *",
+	})
+	assert.NoError(t, err)
+}
+
+func TestDispatchScheduledTransfers(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	scheduled, err := model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(100, "USD"),
+		Memo:       "Allowance",
+		Interval:   24 * time.Hour,
+		MaxRuns:    4,
+	})
+	assert.NoError(t, err)
+
+	// First run is due right away
+	dispatched, err := model.DispatchScheduledTransfers(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+
+	scheduled, err = model.GetScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, scheduled.RunCount)
+	assert.Equal(t, now.Truncate(time.Second).Add(24*time.Hour).Unix(), scheduled.NextRunAt.Unix())
+
+	// Nothing due until tomorrow
+	dispatched, err = model.DispatchScheduledTransfers(ctx, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dispatched)
+
+	// Runs go through the worker like v2 transfers
+	jobs, err := model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "Allowance", jobs[0].Memo)
+	assert.NoError(t, model.ExecuteTransfer(ctx, jobs[0]))
+
+	// Down for four days, every missed run is paid until max_runs
+	dispatched, err = model.DispatchScheduledTransfers(ctx, now.Add(4*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dispatched)
+
+	scheduled, err = model.GetScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, scheduled.RunCount)
+	assert.Equal(t, SCHEDULED_TRANSFER_STATUS_COMPLETED, scheduled.Status)
+	assert.Nil(t, scheduled.NextRunAt)
+
+	runs, err := model.GetScheduledTransferRuns(ctx, source.Id, scheduled.ScheduleUUID, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, runs, 4)
+
+	// Newest first, each run for its own occurrence
+	for i, run := range runs[:3] {
+		assert.Equal(t, now.Truncate(time.Second).Add(time.Duration(3-i)*24*time.Hour).Unix(), run.ScheduledFor.Unix())
+		assert.Equal(t, TRANSFER_STATUS_PENDING, run.Status)
+	}
+	assert.Equal(t, TRANSFER_STATUS_SUCCEEDED, runs[3].Status)
+
+	jobs, err = model.ClaimTransfers(ctx, 10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 3)
+
+	var wallet Wallet
+	assert.NoError(t, db.First(&wallet, dest.Wallets[0].Id).Error)
+	assert.Equal(t, int64(100), wallet.Balance.Amount)
+
+	// Completed schedules are not dispatched again
+	dispatched, err = model.DispatchScheduledTransfers(ctx, now.Add(10*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dispatched)
+}
+
+func TestDispatchScheduledTransfersEndsAt(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	endsAt := now.Add(36 * time.Hour)
+
+	scheduled, err := model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(100, "USD"),
+		Interval:   24 * time.Hour,
+		EndsAt:     &endsAt,
+	})
+	assert.NoError(t, err)
+
+	// Occurrences after the end are not caught up on
+	dispatched, err := model.DispatchScheduledTransfers(ctx, now.Add(5*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+
+	runs, err := model.GetScheduledTransferRuns(ctx, source.Id, scheduled.ScheduleUUID, PageInfo{})
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, now.Truncate(time.Second).Add(24*time.Hour).Unix(), runs[0].ScheduledFor.Unix())
+	assert.Equal(t, now.Truncate(time.Second).Unix(), runs[1].ScheduledFor.Unix())
+
+	scheduled, err = model.GetScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEDULED_TRANSFER_STATUS_COMPLETED, scheduled.Status)
+}
+
+func TestDispatchScheduledTransfersCatchUp(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	scheduled, err := model.CreateScheduledTransfer(ctx, source.Id, ScheduledTransferSpec{
+		DestUserId: dest.Id,
+		Amount:     NewMoney(10, "USD"),
+		Interval:   time.Hour,
+	})
+	assert.NoError(t, err)
+
+	// Down for 30 hours, 31 runs are due but only so many are enqueued at once
+	dispatched, err := model.DispatchScheduledTransfers(ctx, now.Add(30*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEDULED_TRANSFER_MAX_CATCH_UP, dispatched)
+
+	scheduled, err = model.GetScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEDULED_TRANSFER_STATUS_ACTIVE, scheduled.Status)
+	assert.Equal(t, now.Truncate(time.Second).Add(SCHEDULED_TRANSFER_MAX_CATCH_UP*time.Hour).Unix(), scheduled.NextRunAt.Unix())
+
+	// The rest on the next tick
+	dispatched, err = model.DispatchScheduledTransfers(ctx, now.Add(30*time.Hour+time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 31-SCHEDULED_TRANSFER_MAX_CATCH_UP, dispatched)
+
+	scheduled, err = model.GetScheduledTransfer(ctx, source.Id, scheduled.ScheduleUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, 31, scheduled.RunCount)
+	assert.Equal(t, now.Truncate(time.Second).Add(31*time.Hour).Unix(), scheduled.NextRunAt.Unix())
+
+	var pending int64
+	assert.NoError(t, db.Model(&PendingTransfer{}).Where("source_user_id = ?", source.Id).Count(&pending).Error)
+	assert.Equal(t, int64(31), pending)
+}
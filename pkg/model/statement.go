@@ -0,0 +1,154 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Transactions fetched per round trip while streaming a statement
+	STATEMENT_PAGE_SIZE = 100
+)
+
+// Balances of a user's wallet in one currency over [From, To)
+type Statement struct {
+	WalletId       uint64    `json:"wallet_id"`
+	Currency       string    `json:"currency"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	OpeningBalance Money     `json:"opening_balance"`
+	ClosingBalance Money     `json:"closing_balance"`
+}
+
+// Opening balance is the balance right before from, closing balance the balance right before to
+// Users without a wallet in the currency get an empty statement
+func (m *Model) GetStatement(ctx context.Context, userId uint64, currency string, from, to time.Time) (Statement, error) {
+	var statement Statement
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		statement, err = getStatement(tx, userId, currency, from, to)
+		return err
+	}, statementTxOptions)
+
+	return statement, err
+}
+
+// Calls start with the statement, then fn with every page of its transactions, oldest first
+// Only one page is held in memory at a time, however long the statement is
+// Balances and pages are all read in one transaction, so the pages add up from the opening to the closing balance
+func (m *Model) StreamStatement(ctx context.Context, userId uint64, currency string, from, to time.Time, start func(Statement) error, fn func([]Transaction) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		statement, err := getStatement(tx, userId, currency, from, to)
+		if err != nil {
+			return err
+		}
+
+		if err := start(statement); err != nil {
+			return err
+		}
+
+		if statement.WalletId == 0 {
+			return nil
+		}
+
+		filter := TransactionFilter{
+			From: &from,
+			To:   &to,
+			Sort: SORT_ORDER_ASC,
+			CursorInfo: CursorInfo{
+				PageSize: STATEMENT_PAGE_SIZE,
+			},
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			transactions, nextCursor, err := transactionHistory(tx, userId, currency, filter)
+			if err != nil {
+				return fmt.Errorf("failed to get statement transactions: %w", err)
+			}
+
+			if len(transactions) > 0 {
+				if err := fn(transactions); err != nil {
+					return err
+				}
+			}
+
+			if nextCursor == "" {
+				return nil
+			}
+
+			filter.Cursor = nextCursor
+		}
+	}, statementTxOptions)
+}
+
+// One snapshot of the ledger for the whole statement, postings committed meanwhile are left out of balances and pages alike
+var statementTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+func getStatement(tx *gorm.DB, userId uint64, currency string, from, to time.Time) (Statement, error) {
+	statement := Statement{
+		Currency:       currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: Money{Currency: currency},
+		ClosingBalance: Money{Currency: currency},
+	}
+
+	if !from.Before(to) {
+		return statement, ErrBadInput
+	}
+
+	if err := tx.
+		Model(&Wallet{}).
+		Select("id").
+		Scopes(ownedBy(userId), inCurrency(currency)).
+		Scan(&statement.WalletId).Error; err != nil {
+		return statement, fmt.Errorf("failed to get statement wallet: %w", err)
+	}
+
+	if statement.WalletId == 0 {
+		return statement, nil
+	}
+
+	var err error
+	statement.OpeningBalance.Amount, err = balanceBefore(tx, statement.WalletId, from)
+	if err != nil {
+		return statement, fmt.Errorf("failed to get statement balances: %w", err)
+	}
+
+	statement.ClosingBalance.Amount, err = balanceBefore(tx, statement.WalletId, to)
+	if err != nil {
+		return statement, fmt.Errorf("failed to get statement balances: %w", err)
+	}
+
+	return statement, nil
+}
+
+// Latest wallet snapshot taken before at, plus the ledger since that snapshot
+// Snapshots include every transaction up to their TransactionId, later ones are summed whenever they were created
+func balanceBefore(tx *gorm.DB, walletId uint64, at time.Time) (int64, error) {
+	var snapshot WalletAmountSnapshot
+	err := tx.Where("wallet_id = ? AND created_at < ?", walletId, at).Order("created_at desc, id desc").First(&snapshot).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var sum int64
+	if err := tx.Model(&Transaction{}).
+		Where("dest_wallet_id = ? AND created_at < ? AND id > ?", walletId, at, snapshot.TransactionId).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&sum).Error; err != nil {
+		return 0, err
+	}
+
+	return snapshot.Amount + sum, nil
+}
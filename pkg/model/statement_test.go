@@ -0,0 +1,137 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStatement(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{Name: "User A", Email: "user_a@crypto.com"}
+	assert.NoError(t, db.Create(&user).Error)
+
+	wallet := Wallet{UserId: user.Id, Balance: NewMoney(105, "USD")}
+	assert.NoError(t, db.Create(&wallet).Error)
+
+	now := time.Now().UTC()
+	amounts := []struct {
+		ago    time.Duration
+		amount int64
+	}{
+		{5 * time.Hour, 100},
+		{4 * time.Hour, -30},
+		{3 * time.Hour, 50},
+		{2 * time.Hour, -20},
+		{1 * time.Hour, 5},
+	}
+	ids := make([]uint64, len(amounts))
+	for i, a := range amounts {
+		transaction := Transaction{Base: Base{CreatedAt: now.Add(-a.ago)}, DestWalletId: wallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(a.amount, "USD")}
+		assert.NoError(t, db.Create(&transaction).Error)
+		ids[i] = transaction.Id
+	}
+
+	// Snapshot covers the first two transactions, later ones come from the ledger
+	snapshot := WalletAmountSnapshot{Base: Base{CreatedAt: now.Add(-210 * time.Minute)}, WalletId: wallet.Id, Amount: 70, TransactionId: ids[1]}
+	assert.NoError(t, db.Create(&snapshot).Error)
+
+	statement, err := model.GetStatement(context.Background(), user.Id, "USD", now.Add(-195*time.Minute), now.Add(-90*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, wallet.Id, statement.WalletId)
+	assert.Equal(t, int64(70), statement.OpeningBalance.Amount)
+	assert.Equal(t, int64(100), statement.ClosingBalance.Amount)
+
+	var streamed []int64
+	err = model.StreamStatement(context.Background(), user.Id, "USD", now.Add(-195*time.Minute), now.Add(-90*time.Minute), func(started Statement) error {
+		assert.Equal(t, statement, started)
+		return nil
+	}, func(transactions []Transaction) error {
+		for _, transaction := range transactions {
+			streamed = append(streamed, transaction.Amount.Amount)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{50, -20}, streamed)
+
+	// Created before the snapshot but committed after it, missing from the snapshot so summed from the ledger
+	late := Transaction{Base: Base{CreatedAt: now.Add(-220 * time.Minute)}, DestWalletId: wallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(7, "USD")}
+	assert.NoError(t, db.Create(&late).Error)
+
+	statement, err = model.GetStatement(context.Background(), user.Id, "USD", now.Add(-195*time.Minute), now.Add(-90*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(77), statement.OpeningBalance.Amount)
+	assert.Equal(t, int64(107), statement.ClosingBalance.Amount)
+	assert.NoError(t, db.Delete(&late).Error)
+
+	// Before any snapshot, the ledger alone
+	statement, err = model.GetStatement(context.Background(), user.Id, "USD", now.Add(-6*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), statement.OpeningBalance.Amount)
+	assert.Equal(t, int64(105), statement.ClosingBalance.Amount)
+
+	_, err = model.GetStatement(context.Background(), user.Id, "USD", now, now.Add(-time.Hour))
+	assert.ErrorIs(t, err, ErrBadInput)
+
+	// No EUR wallet
+	statement, err = model.GetStatement(context.Background(), user.Id, "EUR", now.Add(-6*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), statement.ClosingBalance.Amount)
+}
+
+func TestStreamStatementPages(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{Name: "User A", Email: "user_a@crypto.com"}
+	assert.NoError(t, db.Create(&user).Error)
+
+	wallet := Wallet{UserId: user.Id, Balance: NewMoney(1, "USD")}
+	assert.NoError(t, db.Create(&wallet).Error)
+
+	now := time.Now().UTC()
+	transactions := make([]Transaction, STATEMENT_PAGE_SIZE+50)
+	for i := range transactions {
+		transactions[i] = Transaction{Base: Base{CreatedAt: now.Add(time.Duration(i-len(transactions)) * time.Second)}, DestWalletId: wallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(int64(i+1), "USD")}
+	}
+	assert.NoError(t, db.CreateInBatches(&transactions, 50).Error)
+
+	var pages int
+	var last int64
+	err := model.StreamStatement(context.Background(), user.Id, "USD", now.Add(-time.Hour), now, func(statement Statement) error {
+		assert.Equal(t, int64(0), statement.OpeningBalance.Amount)
+		return nil
+	}, func(transactions []Transaction) error {
+		pages++
+		for _, transaction := range transactions {
+			assert.Equal(t, last+1, transaction.Amount.Amount, "expected transactions oldest first")
+			last = transaction.Amount.Amount
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, int64(STATEMENT_PAGE_SIZE+50), last)
+
+	// Bad range is reported before anything is started
+	err = model.StreamStatement(context.Background(), user.Id, "USD", now, now.Add(-time.Hour), func(Statement) error {
+		t.Error("expected no statement for a bad range")
+		return nil
+	}, func([]Transaction) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrBadInput)
+}
@@ -1,22 +1,34 @@
 package model
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"js-centralized-wallet/pkg/trace"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+const (
+	// In characters, not bytes
+	MAX_MEMO_LENGTH = 140
+)
+
 type TransactionType int
 
 const (
 	TRANSACTION_TYPE_DEPOSIT TransactionType = iota + 1
 	TRANSACTION_TYPE_WITHDRAW
 	TRANSACTION_TYPE_TRANSFER
+	TRANSACTION_TYPE_FX_TRANSFER
+	TRANSACTION_TYPE_ADJUSTMENT
+	TRANSACTION_TYPE_REVERSAL
 )
 
 func (t TransactionType) String() string {
@@ -27,6 +39,12 @@ func (t TransactionType) String() string {
 		return "Withdraw"
 	case TRANSACTION_TYPE_TRANSFER:
 		return "Transfer"
+	case TRANSACTION_TYPE_FX_TRANSFER:
+		return "FX Transfer"
+	case TRANSACTION_TYPE_ADJUSTMENT:
+		return "Adjustment"
+	case TRANSACTION_TYPE_REVERSAL:
+		return "Reversal"
 	default:
 		return "-"
 	}
@@ -34,219 +52,455 @@ func (t TransactionType) String() string {
 
 type Transaction struct {
 	Base
-	TransactionUUID string          `json:"transaction_uuid"`
+	TransactionUUID string          `gorm:"index" json:"transaction_uuid"`
 	SourceWalletId  uint64          `json:"source_wallet_id"`
-	DestWalletId    uint64          `gorm:"index" json:"dest_wallet_id"`
-	Amount          int64           `json:"amount"`
+	DestWalletId    uint64          `json:"dest_wallet_id"`
+	Currency        string          `gorm:"default:USD" json:"currency"`
+	Amount          Money           `json:"amount"`
 	Type            TransactionType `json:"type"`
+
+	// Supplied by the user who made the transfer, on both sides of it
+	Memo string `json:"memo"`
+
+	// TransactionUUID of the transaction a reversal moved the funds of back
+	ReversalOf string `json:"reversal_of,omitempty"`
+
+	// RequestUUID of the payment request the transfer paid
+	PaymentRequestUUID string `json:"payment_request_uuid,omitempty"`
 }
 
 func (*Transaction) TableName() string {
 	return "transactions"
 }
 
-func (m *Model) GetTransactionHistory(ctx context.Context, userId uint64, transctionType int, pageInfo PageInfo) ([]Transaction, error) {
+func (t *Transaction) AfterFind(tx *gorm.DB) error {
+	t.Amount.Currency = t.Currency
+	return nil
+}
+
+// Keyset paginated on (created_at, id) so pages do not shift when new transactions arrive
+// Returns the cursor of the next page, empty on the last page
+func (m *Model) GetTransactionHistory(ctx context.Context, userId uint64, currency string, filter TransactionFilter) ([]Transaction, string, error) {
+	return transactionHistory(m.db, userId, currency, filter)
+}
+
+// Statements page through the same history inside their own transaction
+func transactionHistory(db *gorm.DB, userId uint64, currency string, filter TransactionFilter) ([]Transaction, string, error) {
 
 	var transactions []Transaction
 	var walletId uint64
 	var err error
 
-	if err = m.db.
+	if err = filter.validate(); err != nil {
+		return transactions, "", err
+	}
+
+	if err = db.
 		Model(&Wallet{}).
 		Select("id").
-		Where("user_id = ?", userId).
+		Scopes(ownedBy(userId), inCurrency(currency)).
 		Scan(&walletId).Error; err != nil {
-		return transactions, err
+		return transactions, "", err
 	}
 
-	query := m.db.Where("dest_wallet_id = ?", walletId)
+	query := db.Where("dest_wallet_id = ?", walletId).Scopes(filter.scope)
 
-	if transctionType > 0 && transctionType <= 3 {
-		query = query.Where("type = ?", transctionType)
+	if filter.Sort == "" {
+		filter.Sort = SORT_ORDER_DESC
 	}
 
-	if pageInfo.Page < 1 {
-		pageInfo.Page = 1
+	if filter.Cursor != "" {
+		after, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return transactions, "", err
+		}
+
+		if filter.Sort == SORT_ORDER_ASC {
+			query = query.Where("(created_at > ? OR (created_at = ? AND id > ?))", after.CreatedAt, after.CreatedAt, after.Id)
+		} else {
+			query = query.Where("(created_at < ? OR (created_at = ? AND id < ?))", after.CreatedAt, after.CreatedAt, after.Id)
+		}
 	}
 
-	if pageInfo.PageSize == 0 || pageInfo.PageSize > 100 {
-		pageInfo.PageSize = 30
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 30
 	}
 
-	offset := (pageInfo.Page - 1) * pageInfo.PageSize
-	query = query.Offset(offset).Limit(pageInfo.PageSize).Order("created_at desc")
+	// One extra row to tell whether there is a next page
+	query = query.Order(fmt.Sprintf("created_at %s, id %s", filter.Sort, filter.Sort)).Limit(filter.PageSize + 1)
 
 	if err = query.Find(&transactions).Error; err != nil {
-		return transactions, err
+		return transactions, "", err
+	}
+
+	if len(transactions) <= filter.PageSize {
+		return transactions, "", nil
 	}
 
-	return transactions, err
+	transactions = transactions[:filter.PageSize]
+	last := transactions[len(transactions)-1]
 
+	return transactions, encodeCursor(last.CreatedAt, last.Id), nil
 }
 
-func (m *Model) Deposit(ctx context.Context, userId uint64, amount int64) (int64, error) {
+// Opens a wallet in the currency if the user does not hold one yet
+func (m *Model) Deposit(ctx context.Context, userId uint64, amount Money) (Money, error) {
+
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return amount, err
+	}
 
-	if amount < 1 {
-		return 0, ErrInvalidAmount
+	debit, err := amount.Neg()
+	if err != nil {
+		return amount, err
 	}
 
 	var userWallet Wallet
 
-	err := m.db.Transaction(func(tx *gorm.DB) error {
-		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Where("user_id", userId).First(&userWallet).Error
-		if err != nil {
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		if err := openWallet(tx, userId, amount.Currency); err != nil {
 			return err
 		}
 
-		userWallet.Balance += amount
+		// "UPDATE" lock, postJournalEntry updates this row so two deposits holding a share lock would deadlock upgrading it
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(ownedBy(userId), inCurrency(amount.Currency)).First(&userWallet).Error
+		if err != nil {
+			return err
+		}
 
-		if err := tx.Save(userWallet).Error; err != nil {
+		if err := userWallet.checkActive(); err != nil {
 			return err
 		}
 
-		transaction := Transaction{
-			TransactionUUID: uuid.New().String(),
-			SourceWalletId:  userWallet.Id,
-			DestWalletId:    userWallet.Id,
-			Amount:          amount,
-			Type:            TRANSACTION_TYPE_DEPOSIT,
+		cashWallet, err := systemWallet(tx, SYSTEM_ACCOUNT_EXTERNAL_CASH, amount.Currency)
+		if err != nil {
+			return err
 		}
 
-		if err := tx.Create(&transaction).Error; err != nil {
+		_, err = postJournalEntry(tx, TRANSACTION_TYPE_DEPOSIT,
+			Posting{WalletId: cashWallet.Id, CounterpartyWalletId: userWallet.Id, Amount: debit},
+			Posting{WalletId: userWallet.Id, CounterpartyWalletId: cashWallet.Id, Amount: amount},
+		)
+		if err != nil {
 			return err
 		}
 
+		userWallet.Balance, err = userWallet.Balance.Add(amount)
 		return err
 	})
 
 	return userWallet.Balance, err
 }
 
-func (m *Model) Withdraw(ctx context.Context, userId uint64, amount int64) (int64, error) {
+func (m *Model) Withdraw(ctx context.Context, userId uint64, amount Money) (Money, error) {
+
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return amount, err
+	}
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return amount, err
+	}
 
 	var userWallet Wallet
 
-	err := m.db.Transaction(func(tx *gorm.DB) error {
-		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Where("user_id", userId).First(&userWallet).Error
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		// "UPDATE" lock, limits are summed from the wallet's transactions so withdrawals must not overlap
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(ownedBy(userId), inCurrency(amount.Currency)).First(&userWallet).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWalletNotFound
+		}
 		if err != nil {
 			return err
 		}
 
-		if userWallet.Balance < amount {
-			return ErrBalanceInsufficient
+		if err := userWallet.checkActive(); err != nil {
+			return err
 		}
 
-		userWallet.Balance -= amount
+		if err := checkSufficient(userWallet.AvailableBalance, amount); err != nil {
+			return err
+		}
 
-		if err := tx.Save(userWallet).Error; err != nil {
+		if err := checkLimits(tx, userId, userWallet, TRANSACTION_TYPE_WITHDRAW, amount); err != nil {
 			return err
 		}
 
-		transaction := Transaction{
-			TransactionUUID: uuid.New().String(),
-			SourceWalletId:  userWallet.Id,
-			DestWalletId:    userWallet.Id,
-			Amount:          amount * -1,
-			Type:            TRANSACTION_TYPE_WITHDRAW,
+		cashWallet, err := systemWallet(tx, SYSTEM_ACCOUNT_EXTERNAL_CASH, amount.Currency)
+		if err != nil {
+			return err
 		}
 
-		if err := tx.Create(&transaction).Error; err != nil {
+		_, err = postJournalEntry(tx, TRANSACTION_TYPE_WITHDRAW,
+			Posting{WalletId: userWallet.Id, CounterpartyWalletId: cashWallet.Id, Amount: debit},
+			Posting{WalletId: cashWallet.Id, CounterpartyWalletId: userWallet.Id, Amount: amount},
+		)
+		if err != nil {
 			return err
 		}
 
+		userWallet.Balance, err = userWallet.Balance.Add(debit)
 		return err
 	})
 
 	return userWallet.Balance, err
 }
 
-func (m *Model) TransferBalance(ctx context.Context, sourceUserId, destUserId uint64, amount int64) error {
+func (m *Model) TransferBalance(ctx context.Context, sourceUserId, destUserId uint64, amount Money, memo string) error {
 	ctx, lg := trace.Logger(ctx)
 
-	lg.Info(fmt.Sprintf("Starts transferring $%d from user_id %d to user_id %d", amount, sourceUserId, destUserId))
+	lg.Info(fmt.Sprintf("Starts transferring %s from user_id %d to user_id %d", amount, sourceUserId, destUserId))
+
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return err
+	}
+
+	memo, err = normalizeMemo(memo)
+	if err != nil {
+		return err
+	}
 
 	// Simulate slow process / delay
 	time.Sleep(1 * time.Second)
-	err := m.db.Transaction(func(tx *gorm.DB) error {
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		_, err := m.transferBalance(ctx, tx, sourceUserId, destUserId, amount, memo)
+		return err
+	})
 
-		// Lock wallets
-		sourceWallet, destWallet, err := LockWalletsBalanceByUserId(ctx, sourceUserId, destUserId, tx)
-		if err != nil {
-			return err
-		}
-		if sourceWallet.Balance < amount {
+	return err
+}
 
-			// V2 TO TAKE NOTE
-			// Might have issue even though checked before pushing into channel
-			// TODO:
-			// 1) Add retry mechanism in the future
-			// OR
-			// 2) Push into persistent storage to notify users that the transaction fails
-			return ErrBalanceInsufficient
-		}
-		sourceWallet.Balance -= amount
-		destWallet.Balance += amount
+// Checks everything that can be checked before a transfer is accepted, so clients get the error synchronously
+// The transfer checks again under lock when it executes, balances might have changed by then
+func (m *Model) ValidateTransfer(ctx context.Context, sourceUserId, destUserId uint64, amount Money) error {
+	amount, err := validateAmount(amount)
+	if err != nil {
+		return err
+	}
 
-		if err := tx.Save(sourceWallet).Error; err != nil {
-			return err
-		}
-		if err := tx.Save(destWallet).Error; err != nil {
-			return err
-		}
+	if sourceUserId == destUserId {
+		return ErrSelfTransferInvalid
+	}
 
-		// When we get listing / sync, we filter by DestWalletId with the amount
-		sourceTransaction := Transaction{
-			TransactionUUID: uuid.New().String(),
-			SourceWalletId:  destWallet.Id,
-			DestWalletId:    sourceWallet.Id,
-			Amount:          amount * -1,
-			Type:            TRANSACTION_TYPE_TRANSFER,
-		}
+	db := m.db.WithContext(ctx)
 
-		// When we get listing / sync, we filter by DestWalletId with the amount
-		destTransaction := Transaction{
-			TransactionUUID: uuid.New().String(),
-			SourceWalletId:  sourceWallet.Id,
-			DestWalletId:    destWallet.Id,
-			Amount:          amount,
-			Type:            TRANSACTION_TYPE_TRANSFER,
-		}
+	if err := checkDestination(db, destUserId, amount.Currency); err != nil {
+		return err
+	}
 
-		if err := tx.Create(&sourceTransaction).Error; err != nil {
-			return err
-		}
-		if err := tx.Create(&destTransaction).Error; err != nil {
-			return err
+	var sourceWallet, destWallet Wallet
+	err = db.Scopes(ownedBy(sourceUserId), inCurrency(amount.Currency)).First(&sourceWallet).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrWalletNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get source wallet: %w", err)
+	}
+
+	if err := db.Scopes(ownedBy(destUserId), inCurrency(amount.Currency)).First(&destWallet).Error; err != nil {
+		return fmt.Errorf("failed to get destination wallet: %w", err)
+	}
+
+	if err := checkTransferWallets(&sourceWallet, &destWallet); err != nil {
+		return err
+	}
+
+	if err := checkSufficient(sourceWallet.AvailableBalance, amount); err != nil {
+		return err
+	}
+
+	return checkLimits(db, sourceUserId, sourceWallet, TRANSACTION_TYPE_TRANSFER, amount)
+}
+
+// Moves balance within the caller's DB transaction, so it can be committed together with other writes
+// Both users must hold a wallet in the currency
+func (m *Model) transferBalance(ctx context.Context, tx *gorm.DB, sourceUserId, destUserId uint64, amount Money, memo string) (JournalEntry, error) {
+	return m.postTransfer(ctx, tx, sourceUserId, destUserId, amount, memo, true)
+}
+
+// Limits are skipped for amounts already checked against them, e.g. when capturing a hold
+func (m *Model) postTransfer(ctx context.Context, tx *gorm.DB, sourceUserId, destUserId uint64, amount Money, memo string, limited bool) (JournalEntry, error) {
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return JournalEntry{}, err
+	}
+
+	if err := checkDestination(tx, destUserId, amount.Currency); err != nil {
+		return JournalEntry{}, err
+	}
+
+	// Lock wallets
+	sourceWallet, destWallet, err := LockWalletsBalanceByUserId(ctx, sourceUserId, destUserId, amount.Currency, tx)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+
+	if err := checkTransferWallets(&sourceWallet, &destWallet); err != nil {
+		return JournalEntry{}, err
+	}
+
+	// V2 TO TAKE NOTE
+	// Might happen even though checked before persisting into pending transfers
+	// Worker records it as the failure reason, users see it on GET /api/transfers/{id}/v1
+	if err := checkSufficient(sourceWallet.AvailableBalance, amount); err != nil {
+		return JournalEntry{}, err
+	}
+
+	if limited {
+		if err := checkLimits(tx, sourceUserId, sourceWallet, TRANSACTION_TYPE_TRANSFER, amount); err != nil {
+			return JournalEntry{}, err
 		}
+	}
+
+	// Single journal entry, so both sides of the transfer share the same TransactionUUID
+	// When we get listing / sync, we filter by DestWalletId with the amount
+	return postJournalEntry(tx, TRANSACTION_TYPE_TRANSFER,
+		Posting{WalletId: sourceWallet.Id, CounterpartyWalletId: destWallet.Id, Amount: debit, Memo: memo},
+		Posting{WalletId: destWallet.Id, CounterpartyWalletId: sourceWallet.Id, Amount: amount, Memo: memo},
+	)
+}
+
+// Amounts are always positive, the direction comes from the method moving them
+// Returns the amount in the canonical currency code, an empty currency being DEFAULT_CURRENCY
+func validateAmount(amount Money) (Money, error) {
+	currency, err := ParseCurrency(amount.Currency)
+	if err != nil {
+		return amount, err
+	}
+	amount.Currency = currency.Code
+
+	if !amount.IsPositive() {
+		return amount, ErrInvalidAmount
+	}
 
+	return amount, nil
+}
+
+func checkSufficient(balance, amount Money) error {
+	cmp, err := balance.Cmp(amount)
+	if err != nil {
 		return err
-	})
+	}
 
-	return err
+	if cmp < 0 {
+		return ErrBalanceInsufficient
+	}
+
+	return nil
+}
+
+func normalizeMemo(memo string) (string, error) {
+	memo = strings.TrimSpace(memo)
+	if utf8.RuneCountInString(memo) > MAX_MEMO_LENGTH {
+		return "", ErrMemoTooLong
+	}
+
+	return memo, nil
+}
+
+// Destination must hold a wallet in the currency to receive funds
+// Holding wallets only in other currencies is a mismatch, holding none at all is a missing destination
+func checkDestination(tx *gorm.DB, destUserId uint64, currency string) error {
+	var destCurrencies []string
+	if err := tx.Model(&Wallet{}).Scopes(ownedBy(destUserId)).Pluck("currency", &destCurrencies).Error; err != nil {
+		return err
+	}
+
+	if len(destCurrencies) == 0 {
+		return ErrDestinationNotFound
+	}
+
+	if !slices.Contains(destCurrencies, currency) {
+		return ErrCurrencyMismatch
+	}
+
+	return nil
+}
+
+// Creates an empty wallet in the currency for an existing user, no-op if the user already holds one
+func openWallet(tx *gorm.DB, userId uint64, currency string) error {
+	var userCount int64
+	if err := tx.Model(&User{}).Where("id = ?", userId).Count(&userCount).Error; err != nil {
+		return err
+	}
+
+	if userCount == 0 {
+		return ErrUserNotFound
+	}
+
+	var wallets []Wallet
+	if err := tx.Scopes(ownedBy(userId)).Find(&wallets).Error; err != nil {
+		return err
+	}
+
+	// Already open, whatever its status the caller checks it
+	for _, wallet := range wallets {
+		if wallet.Currency == currency {
+			return nil
+		}
+	}
+
+	// A frozen or closed wallet must not be worked around by opening one in another currency
+	for _, wallet := range wallets {
+		if wallet.Status != WALLET_STATUS_ACTIVE {
+			return ErrWalletOpenRestricted
+		}
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Wallet{
+		UserId:   userId,
+		Currency: currency,
+	}).Error
 }
 
 // Always row lock smaller userId first to prevent deadlock
 // Uses "UPDATE" lock instead of "SHARE" lock, stricter
-func LockWalletsBalanceByUserId(c context.Context, sourceUserId, destUserId uint64, tx *gorm.DB) (Wallet, Wallet, error) {
-	var sourceWallet, destWallet Wallet
-	if sourceUserId < destUserId {
-		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id", sourceUserId).First(&sourceWallet).Error
-		if err != nil {
-			return sourceWallet, destWallet, err
-		}
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id", destUserId).First(&destWallet).Error
-		if err != nil {
-			return sourceWallet, destWallet, err
+func LockWalletsBalanceByUserId(c context.Context, sourceUserId, destUserId uint64, currency string, tx *gorm.DB) (Wallet, Wallet, error) {
+	wallets, err := lockWallets(tx,
+		walletKey{UserId: sourceUserId, Currency: currency},
+		walletKey{UserId: destUserId, Currency: currency},
+	)
+	if err != nil {
+		return Wallet{}, Wallet{}, err
+	}
+
+	return wallets[0], wallets[1], nil
+}
+
+type walletKey struct {
+	UserId   uint64
+	Currency string
+}
+
+// Row locks user wallets ordered by (user id, currency), the same order for every caller to prevent deadlock
+// Wallets are returned in the order of keys
+func lockWallets(tx *gorm.DB, keys ...walletKey) ([]Wallet, error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		if keys[a].UserId != keys[b].UserId {
+			return cmp.Compare(keys[a].UserId, keys[b].UserId)
 		}
-	} else {
-		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id", destUserId).First(&destWallet).Error
-		if err != nil {
-			return sourceWallet, destWallet, err
+		return strings.Compare(keys[a].Currency, keys[b].Currency)
+	})
+
+	wallets := make([]Wallet, len(keys))
+	for _, i := range order {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(ownedBy(keys[i].UserId), inCurrency(keys[i].Currency)).First(&wallets[i]).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWalletNotFound
 		}
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id", sourceUserId).First(&sourceWallet).Error
 		if err != nil {
-			return sourceWallet, destWallet, err
+			return nil, err
 		}
 	}
-	return sourceWallet, destWallet, nil
+
+	return wallets, nil
 }
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TransactionDirection int
+
+const (
+	TRANSACTION_DIRECTION_CREDIT TransactionDirection = iota + 1
+	TRANSACTION_DIRECTION_DEBIT
+)
+
+func (d TransactionDirection) String() string {
+	switch d {
+	case TRANSACTION_DIRECTION_CREDIT:
+		return "credit"
+	case TRANSACTION_DIRECTION_DEBIT:
+		return "debit"
+	default:
+		return "-"
+	}
+}
+
+func ParseTransactionDirection(s string) (TransactionDirection, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "credit":
+		return TRANSACTION_DIRECTION_CREDIT, nil
+	case "debit":
+		return TRANSACTION_DIRECTION_DEBIT, nil
+	default:
+		return 0, ErrBadInput
+	}
+}
+
+type SortOrder string
+
+const (
+	SORT_ORDER_DESC SortOrder = "desc"
+	SORT_ORDER_ASC  SortOrder = "asc"
+)
+
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch SortOrder(s) {
+	case "", SORT_ORDER_DESC:
+		return SORT_ORDER_DESC, nil
+	case SORT_ORDER_ASC:
+		return SORT_ORDER_ASC, nil
+	default:
+		return "", ErrBadInput
+	}
+}
+
+// Zero values are not filtered on
+type TransactionFilter struct {
+	Type TransactionType
+
+	// created_at in [From, To)
+	From *time.Time
+	To   *time.Time
+
+	// Absolute amount, debits of -500 match a MinAmount of 500
+	MinAmount *int64
+	MaxAmount *int64
+
+	Direction          TransactionDirection
+	CounterpartyUserId uint64
+
+	// Newest first by default
+	Sort SortOrder
+
+	CursorInfo
+}
+
+func (f TransactionFilter) validate() error {
+	if f.Type < 0 || f.Type > TRANSACTION_TYPE_REVERSAL {
+		return ErrBadInput
+	}
+
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return ErrBadInput
+	}
+
+	if (f.MinAmount != nil && *f.MinAmount < 0) || (f.MaxAmount != nil && *f.MaxAmount < 0) {
+		return ErrInvalidAmount
+	}
+
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		return ErrInvalidAmount
+	}
+
+	return nil
+}
+
+// Filters on top of dest_wallet_id, in the column order of the transactions composite indexes
+func (f TransactionFilter) scope(db *gorm.DB) *gorm.DB {
+	if f.Type > 0 {
+		db = db.Where("type = ?", f.Type)
+	}
+
+	if f.CounterpartyUserId > 0 {
+		db = db.Where("source_wallet_id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+			Model(&Wallet{}).
+			Select("id").
+			Scopes(ownedBy(f.CounterpartyUserId)))
+	}
+
+	if f.From != nil {
+		db = db.Where("created_at >= ?", *f.From)
+	}
+
+	if f.To != nil {
+		db = db.Where("created_at < ?", *f.To)
+	}
+
+	switch f.Direction {
+	case TRANSACTION_DIRECTION_CREDIT:
+		db = db.Where("amount > 0")
+	case TRANSACTION_DIRECTION_DEBIT:
+		db = db.Where("amount < 0")
+	}
+
+	// Ranges on the signed amount rather than ABS(amount), so the database can still use them
+	if f.MinAmount != nil {
+		db = db.Where("(amount >= ? OR amount <= ?)", *f.MinAmount, *f.MinAmount*-1)
+	}
+
+	if f.MaxAmount != nil {
+		db = db.Where("amount BETWEEN ? AND ?", *f.MaxAmount*-1, *f.MaxAmount)
+	}
+
+	return db
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTransactionHistoryFilter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{Name: "User A", Email: "user_a@crypto.com"}
+	counterparty := User{Name: "User B", Email: "user_b@crypto.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	assert.NoError(t, db.Create(&counterparty).Error)
+
+	wallet := Wallet{UserId: user.Id, Balance: NewMoney(100, "USD")}
+	counterpartyWallet := Wallet{UserId: counterparty.Id, Balance: NewMoney(100, "USD")}
+	cashWallet := Wallet{SystemAccount: SYSTEM_ACCOUNT_EXTERNAL_CASH}
+	assert.NoError(t, db.Create(&wallet).Error)
+	assert.NoError(t, db.Create(&counterpartyWallet).Error)
+	assert.NoError(t, db.Create(&cashWallet).Error)
+
+	start := time.Now().UTC().Add(-time.Hour)
+	transactions := []Transaction{
+		{SourceWalletId: cashWallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(1000, "USD")},
+		{SourceWalletId: counterpartyWallet.Id, Type: TRANSACTION_TYPE_TRANSFER, Amount: NewMoney(-300, "USD")},
+		{SourceWalletId: cashWallet.Id, Type: TRANSACTION_TYPE_WITHDRAW, Amount: NewMoney(-50, "USD")},
+		{SourceWalletId: counterpartyWallet.Id, Type: TRANSACTION_TYPE_TRANSFER, Amount: NewMoney(20, "USD")},
+	}
+	for i := range transactions {
+		transactions[i].CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		transactions[i].DestWalletId = wallet.Id
+		assert.NoError(t, db.Create(&transactions[i]).Error)
+	}
+
+	amountOf := func(v int64) *int64 { return &v }
+	timeOf := func(v time.Time) *time.Time { return &v }
+
+	tests := []struct {
+		name   string
+		filter TransactionFilter
+		want   []int64
+	}{
+		{"no filter newest first", TransactionFilter{}, []int64{20, -50, -300, 1000}},
+		{"oldest first", TransactionFilter{Sort: SORT_ORDER_ASC}, []int64{1000, -300, -50, 20}},
+		{"type", TransactionFilter{Type: TRANSACTION_TYPE_TRANSFER}, []int64{20, -300}},
+		{"credits", TransactionFilter{Direction: TRANSACTION_DIRECTION_CREDIT}, []int64{20, 1000}},
+		{"debits", TransactionFilter{Direction: TRANSACTION_DIRECTION_DEBIT}, []int64{-50, -300}},
+		{"counterparty", TransactionFilter{CounterpartyUserId: counterparty.Id}, []int64{20, -300}},
+		{"from inclusive to exclusive", TransactionFilter{From: timeOf(start.Add(time.Minute)), To: timeOf(start.Add(3 * time.Minute))}, []int64{-50, -300}},
+		{"absolute min amount", TransactionFilter{MinAmount: amountOf(50)}, []int64{-50, -300, 1000}},
+		{"absolute max amount", TransactionFilter{MaxAmount: amountOf(300)}, []int64{20, -50, -300}},
+		{"amount range and direction", TransactionFilter{MinAmount: amountOf(20), MaxAmount: amountOf(300), Direction: TRANSACTION_DIRECTION_DEBIT}, []int64{-50, -300}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _, err := model.GetTransactionHistory(context.Background(), user.Id, "USD", tt.filter)
+			assert.NoError(t, err)
+
+			amounts := make([]int64, len(result))
+			for i, transaction := range result {
+				amounts[i] = transaction.Amount.Amount
+			}
+			assert.Equal(t, tt.want, amounts)
+		})
+	}
+
+	// Pages in ascending order continue after the cursor
+	firstPage, nextCursor, err := model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{Sort: SORT_ORDER_ASC, CursorInfo: CursorInfo{PageSize: 3}})
+	assert.NoError(t, err)
+	assert.Len(t, firstPage, 3)
+	lastPage, nextCursor, err := model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{Sort: SORT_ORDER_ASC, CursorInfo: CursorInfo{Cursor: nextCursor, PageSize: 3}})
+	assert.NoError(t, err)
+	assert.Len(t, lastPage, 1)
+	assert.Equal(t, int64(20), lastPage[0].Amount.Amount)
+	assert.Empty(t, nextCursor)
+}
+
+func TestGetTransactionHistoryInvalidFilter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	now := time.Now()
+	before := now.Add(-time.Hour)
+	negative := int64(-1)
+	small, large := int64(10), int64(100)
+
+	tests := []struct {
+		filter TransactionFilter
+		err    error
+	}{
+		{TransactionFilter{From: &now, To: &before}, ErrBadInput},
+		{TransactionFilter{Type: 99}, ErrBadInput},
+		{TransactionFilter{MinAmount: &negative}, ErrInvalidAmount},
+		{TransactionFilter{MinAmount: &large, MaxAmount: &small}, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		_, _, err := model.GetTransactionHistory(context.Background(), 1, "USD", tt.filter)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("expected %v, got %v", tt.err, err)
+		}
+	}
+}
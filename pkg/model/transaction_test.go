@@ -3,7 +3,10 @@ package model
 import (
 	"context"
 	"errors"
+	"math"
+	"slices"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -19,15 +22,15 @@ func TestDepositSuccess(t *testing.T) {
 	user := User{
 		Name:  "User A",
 		Email: "user_a@crypto.com",
-		Wallet: Wallet{
-			Balance: 100,
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
 		},
 	}
 	if err := db.Create(&user).Error; err != nil {
 		t.Fatalf("failed to create user: %v", err)
 	}
 
-	newBalance, err := model.Deposit(context.Background(), user.Id, 50)
+	newBalance, err := model.Deposit(context.Background(), user.Id, NewMoney(50, "USD"))
 	if err != nil {
 		t.Fatalf("failed to deposit: %v", err)
 	}
@@ -37,12 +40,12 @@ func TestDepositSuccess(t *testing.T) {
 		t.Fatalf("failed to find wallet: %v", err)
 	}
 
-	if wallet.Balance != 150 {
-		t.Fatalf("expected wallet balance 150, got %d", wallet.Balance)
+	if wallet.Balance.Amount != 150 {
+		t.Fatalf("expected wallet balance 150, got %d", wallet.Balance.Amount)
 	}
 
-	if newBalance != 150 {
-		t.Fatalf("expected new balance 150, got %d", newBalance)
+	if newBalance.Amount != 150 {
+		t.Fatalf("expected new balance 150, got %d", newBalance.Amount)
 	}
 
 	var count int64
@@ -63,15 +66,15 @@ func TestDepositFailedInvalidAmount(t *testing.T) {
 	user := User{
 		Name:  "User A",
 		Email: "user_a@crypto.com",
-		Wallet: Wallet{
-			Balance: 100,
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
 		},
 	}
 	if err := db.Create(&user).Error; err != nil {
 		t.Fatalf("failed to create user: %v", err)
 	}
 
-	_, err := model.Deposit(context.Background(), user.Id, 0)
+	_, err := model.Deposit(context.Background(), user.Id, NewMoney(0, "USD"))
 	if !errors.Is(err, ErrInvalidAmount) {
 		t.Fatalf("expected ErrInvalidAmount, got %v", err)
 	}
@@ -98,9 +101,9 @@ func TestDepositFailedInvalidUser(t *testing.T) {
 	}
 	user := User{}
 
-	_, err := model.Deposit(context.Background(), user.Id, 50)
-	if err == nil {
-		t.Fatalf("expected invalid user, but got none")
+	_, err := model.Deposit(context.Background(), user.Id, NewMoney(50, "USD"))
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
 	}
 }
 
@@ -114,15 +117,15 @@ func TestWithdrawSuccess(t *testing.T) {
 	user := User{
 		Name:  "User B",
 		Email: "user_b@crypto.com",
-		Wallet: Wallet{
-			Balance: 100,
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
 		},
 	}
 	if err := db.Create(&user).Error; err != nil {
 		t.Fatalf("failed to create user: %v", err)
 	}
 
-	_, err := model.Withdraw(context.Background(), user.Id, 50)
+	_, err := model.Withdraw(context.Background(), user.Id, NewMoney(50, "USD"))
 	if errors.Is(err, ErrBalanceInsufficient) {
 		t.Fatalf("expected ErrBalanceInsufficient, got %v", err)
 	}
@@ -150,15 +153,15 @@ func TestWithdrawInsufficientBalance(t *testing.T) {
 	user := User{
 		Name:  "User B",
 		Email: "user_b@crypto.com",
-		Wallet: Wallet{
-			Balance: 50,
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(50, "USD")},
 		},
 	}
 	if err := db.Create(&user).Error; err != nil {
 		t.Fatalf("failed to create user: %v", err)
 	}
 
-	_, err := model.Withdraw(context.Background(), user.Id, 100)
+	_, err := model.Withdraw(context.Background(), user.Id, NewMoney(100, "USD"))
 	if err == nil {
 		t.Fatal("expected error due to insufficient balance")
 	}
@@ -188,9 +191,9 @@ func TestWithdrawFailedInvalidUser(t *testing.T) {
 	}
 	user := User{}
 
-	_, err := model.Withdraw(context.Background(), user.Id, 50)
-	if err == nil {
-		t.Fatalf("expected invalid user, but got none")
+	_, err := model.Withdraw(context.Background(), user.Id, NewMoney(50, "USD"))
+	if !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("expected ErrWalletNotFound, got %v", err)
 	}
 }
 
@@ -219,11 +222,11 @@ func TestTransferBalanceSuccess(t *testing.T) {
 
 	sourceWallet := Wallet{
 		UserId:  source.Id,
-		Balance: 200,
+		Balance: NewMoney(200, "USD"),
 	}
 	destWallet := Wallet{
 		UserId:  dest.Id,
-		Balance: 0,
+		Balance: NewMoney(0, "USD"),
 	}
 	if err := db.Create(&sourceWallet).Error; err != nil {
 		t.Fatalf("failed to create source wallet: %v", err)
@@ -232,7 +235,7 @@ func TestTransferBalanceSuccess(t *testing.T) {
 		t.Fatalf("failed to create dest wallet: %v", err)
 	}
 
-	err := model.TransferBalance(context.Background(), source.Id, dest.Id, 100)
+	err := model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(100, "USD"), "")
 	if err != nil {
 		t.Fatalf("transfer failed: %v", err)
 	}
@@ -244,11 +247,11 @@ func TestTransferBalanceSuccess(t *testing.T) {
 		t.Fatalf("failed to get dest wallet: %v", err)
 	}
 
-	if sourceWallet.Balance != 100 {
-		t.Errorf("expected source wallet balance 100, got %d", sourceWallet.Balance)
+	if sourceWallet.Balance.Amount != 100 {
+		t.Errorf("expected source wallet balance 100, got %d", sourceWallet.Balance.Amount)
 	}
-	if destWallet.Balance != 100 {
-		t.Errorf("expected dest wallet balance 100, got %d", destWallet.Balance)
+	if destWallet.Balance.Amount != 100 {
+		t.Errorf("expected dest wallet balance 100, got %d", destWallet.Balance.Amount)
 	}
 
 	var transactions []Transaction
@@ -285,11 +288,11 @@ func TestTransferBalanceInsufficientBalance(t *testing.T) {
 
 	sourceWallet := Wallet{
 		UserId:  source.Id,
-		Balance: 50,
+		Balance: NewMoney(50, "USD"),
 	}
 	destWallet := Wallet{
 		UserId:  dest.Id,
-		Balance: 0,
+		Balance: NewMoney(0, "USD"),
 	}
 	if err := db.Create(&sourceWallet).Error; err != nil {
 		t.Fatalf("failed to create source wallet: %v", err)
@@ -298,7 +301,7 @@ func TestTransferBalanceInsufficientBalance(t *testing.T) {
 		t.Fatalf("failed to create dest wallet: %v", err)
 	}
 
-	err := model.TransferBalance(context.Background(), source.Id, dest.Id, 100)
+	err := model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(100, "USD"), "")
 
 	if err != ErrBalanceInsufficient {
 		t.Fatalf("expected ErrBalanceInsufficient, got %v", err)
@@ -311,11 +314,11 @@ func TestTransferBalanceInsufficientBalance(t *testing.T) {
 		t.Fatalf("failed to get dest wallet: %v", err)
 	}
 
-	if sourceWallet.Balance != 50 {
-		t.Errorf("expected source wallet balance 50, got %d", sourceWallet.Balance)
+	if sourceWallet.Balance.Amount != 50 {
+		t.Errorf("expected source wallet balance 50, got %d", sourceWallet.Balance.Amount)
 	}
-	if destWallet.Balance != 0 {
-		t.Errorf("expected dest wallet balance 0, got %d", destWallet.Balance)
+	if destWallet.Balance.Amount != 0 {
+		t.Errorf("expected dest wallet balance 0, got %d", destWallet.Balance.Amount)
 	}
 }
 
@@ -337,7 +340,7 @@ func TestTransferBalanceInvalidUser(t *testing.T) {
 
 	destWallet := Wallet{
 		UserId:  dest.Id,
-		Balance: 0,
+		Balance: NewMoney(0, "USD"),
 	}
 	if err := db.Create(&destWallet).Error; err != nil {
 		t.Fatalf("failed to create dest wallet: %v", err)
@@ -345,19 +348,19 @@ func TestTransferBalanceInvalidUser(t *testing.T) {
 
 	invalidSourceUserId := uint64(9999)
 
-	err := model.TransferBalance(context.Background(), invalidSourceUserId, dest.Id, 100)
+	err := model.TransferBalance(context.Background(), invalidSourceUserId, dest.Id, NewMoney(100, "USD"), "")
 
 	// Invalid User
-	if err == nil {
-		t.Fatalf("expected error, got %v", err)
+	if !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("expected ErrWalletNotFound, got %v", err)
 	}
 
 	if err := db.First(&destWallet, "user_id = ?", dest.Id).Error; err != nil {
 		t.Fatalf("failed to get dest wallet: %v", err)
 	}
 
-	if destWallet.Balance != 0 {
-		t.Errorf("expected dest wallet balance 0, got %d", destWallet.Balance)
+	if destWallet.Balance.Amount != 0 {
+		t.Errorf("expected dest wallet balance 0, got %d", destWallet.Balance.Amount)
 	}
 
 	var transactions []Transaction
@@ -371,9 +374,9 @@ func TestTransferBalanceInvalidUser(t *testing.T) {
 	invalidDestUserId := uint64(9999)
 	validDestUserId := dest.Id
 
-	err = model.TransferBalance(context.Background(), validDestUserId, invalidDestUserId, 100)
-	if err == nil {
-		t.Fatalf("expected error for invalid destination user, got nil")
+	err = model.TransferBalance(context.Background(), validDestUserId, invalidDestUserId, NewMoney(100, "USD"), "")
+	if !errors.Is(err, ErrDestinationNotFound) {
+		t.Fatalf("expected ErrDestinationNotFound, got %v", err)
 	}
 
 	var sourceWallet Wallet
@@ -381,8 +384,8 @@ func TestTransferBalanceInvalidUser(t *testing.T) {
 		t.Fatalf("failed to get source wallet: %v", err)
 	}
 
-	if sourceWallet.Balance != 0 {
-		t.Errorf("expected source wallet balance to be 0, got %d", sourceWallet.Balance)
+	if sourceWallet.Balance.Amount != 0 {
+		t.Errorf("expected source wallet balance to be 0, got %d", sourceWallet.Balance.Amount)
 	}
 
 	var transactionsAfterDestInvalid []Transaction
@@ -394,6 +397,268 @@ func TestTransferBalanceInvalidUser(t *testing.T) {
 	}
 }
 
+func TestDepositOpensWalletInNewCurrency(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
+		},
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	newBalance, err := model.Deposit(context.Background(), user.Id, NewMoney(50, "eur"))
+	if err != nil {
+		t.Fatalf("failed to deposit: %v", err)
+	}
+	if newBalance.Amount != 50 {
+		t.Fatalf("expected new balance 50, got %d", newBalance.Amount)
+	}
+
+	wallets, err := model.GetWallets(context.Background(), user.Id)
+	if err != nil {
+		t.Fatalf("failed to get wallets: %v", err)
+	}
+	if len(wallets) != 2 {
+		t.Fatalf("expected 2 wallets, got %d", len(wallets))
+	}
+	if wallets[0].Currency != "EUR" || wallets[0].Balance.Amount != 50 {
+		t.Errorf("expected EUR wallet with balance 50, got %s %d", wallets[0].Currency, wallets[0].Balance.Amount)
+	}
+	if wallets[1].Currency != "USD" || wallets[1].Balance.Amount != 100 {
+		t.Errorf("expected USD wallet untouched with balance 100, got %s %d", wallets[1].Currency, wallets[1].Balance.Amount)
+	}
+
+	_, err = model.Deposit(context.Background(), user.Id, NewMoney(50, "XXX"))
+	if !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Fatalf("expected ErrUnsupportedCurrency, got %v", err)
+	}
+}
+
+func TestTransferBalanceCurrencyMismatch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(200, "USD")},
+			{Currency: "EUR", Balance: NewMoney(200, "EUR")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
+		},
+	}
+	if err := db.Create(&source).Error; err != nil {
+		t.Fatalf("failed to create source user: %v", err)
+	}
+	if err := db.Create(&dest).Error; err != nil {
+		t.Fatalf("failed to create dest user: %v", err)
+	}
+
+	err := model.TransferBalance(context.Background(), source.Id, dest.Id, NewMoney(100, "EUR"), "")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("expected ErrCurrencyMismatch, got %v", err)
+	}
+
+	balance, err := model.GetWalletBalance(context.Background(), source.Id, "EUR")
+	if err != nil {
+		t.Fatalf("failed to get source balance: %v", err)
+	}
+	if balance.Amount != 200 {
+		t.Errorf("expected source EUR balance unchanged at 200, got %d", balance.Amount)
+	}
+
+	var count int64
+	db.Model(&Transaction{}).Where("type = ?", TRANSACTION_TYPE_TRANSFER).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no transfer transactions, got %d", count)
+	}
+}
+
+func TestValidateTransfer(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(0, "USD")},
+		},
+	}
+	walletless := User{
+		Name:  "User C",
+		Email: "user_c@crypto.com",
+	}
+	for _, user := range []*User{&source, &dest, &walletless} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		destUserId uint64
+		currency   string
+		amount     int64
+		want       error
+	}{
+		{"Valid", dest.Id, "USD", 100, nil},
+		{"Zero amount", dest.Id, "USD", 0, ErrInvalidAmount},
+		{"Negative amount", dest.Id, "USD", -1, ErrInvalidAmount},
+		{"Self transfer", source.Id, "USD", 100, ErrSelfTransferInvalid},
+		{"Unknown destination", 9999, "USD", 100, ErrDestinationNotFound},
+		{"Destination without wallets", walletless.Id, "USD", 100, ErrDestinationNotFound},
+		{"Destination in another currency", dest.Id, "EUR", 100, ErrCurrencyMismatch},
+		{"Insufficient balance", dest.Id, "USD", 101, ErrBalanceInsufficient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := model.ValidateTransfer(context.Background(), source.Id, tt.destUserId, NewMoney(tt.amount, tt.currency))
+			if tt.want == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMoneyMovementAmounts(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	rates, err := NewStaticRateProvider(DEFAULT_FX_RATES)
+	if err != nil {
+		t.Fatalf("failed to create rate provider: %v", err)
+	}
+
+	model := &Model{
+		db:    db,
+		rates: rates,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(100, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(math.MaxInt64-50, "USD")},
+		},
+	}
+	for _, user := range []*User{&source, &dest} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+
+	deposit := func(amount int64) error {
+		_, err := model.Deposit(ctx, source.Id, NewMoney(amount, "USD"))
+		return err
+	}
+	withdraw := func(amount int64) error {
+		_, err := model.Withdraw(ctx, source.Id, NewMoney(amount, "USD"))
+		return err
+	}
+	transfer := func(amount int64) error {
+		return model.TransferBalance(ctx, source.Id, dest.Id, NewMoney(amount, "USD"), "")
+	}
+	enqueue := func(amount int64) error {
+		_, err := model.EnqueueTransfer(ctx, source.Id, dest.Id, NewMoney(amount, "USD"), "")
+		return err
+	}
+	quote := func(amount int64) error {
+		_, err := model.CreateFxQuote(ctx, source.Id, NewMoney(amount, "USD"), "EUR")
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		move   func(amount int64) error
+		amount int64
+		want   error
+	}{
+		{"Deposit negative", deposit, -100, ErrInvalidAmount},
+		{"Deposit zero", deposit, 0, ErrInvalidAmount},
+		{"Deposit overflows balance", deposit, math.MaxInt64, ErrBalanceOverflow},
+		{"Withdraw negative", withdraw, -100, ErrInvalidAmount},
+		{"Withdraw zero", withdraw, 0, ErrInvalidAmount},
+		{"Withdraw max", withdraw, math.MaxInt64, ErrBalanceInsufficient},
+		{"Transfer negative", transfer, -100, ErrInvalidAmount},
+		{"Transfer zero", transfer, 0, ErrInvalidAmount},
+		{"Transfer overflows destination balance", transfer, 100, ErrBalanceOverflow},
+		{"Enqueue negative", enqueue, -100, ErrInvalidAmount},
+		{"Enqueue zero", enqueue, 0, ErrInvalidAmount},
+		{"Quote negative", quote, -100, ErrInvalidAmount},
+		{"Quote zero", quote, 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.move(tt.amount)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+
+			// Nothing moved
+			var wallets []Wallet
+			if err := db.Where("user_id IN ?", []uint64{source.Id, dest.Id}).Order("user_id").Find(&wallets).Error; err != nil {
+				t.Fatalf("failed to get wallets: %v", err)
+			}
+			if wallets[0].Balance.Amount != 100 || wallets[1].Balance.Amount != math.MaxInt64-50 {
+				t.Errorf("expected balances unchanged, got %d and %d", wallets[0].Balance.Amount, wallets[1].Balance.Amount)
+			}
+		})
+	}
+
+	var count int64
+	if err := db.Model(&Transaction{}).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count transactions: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("expected no transactions, got %d", count)
+	}
+}
+
 func TestGetTransactionHistory(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -412,16 +677,16 @@ func TestGetTransactionHistory(t *testing.T) {
 
 	wallet := Wallet{
 		UserId:  user.Id,
-		Balance: 100,
+		Balance: NewMoney(100, "USD"),
 	}
 	if err := db.Create(&wallet).Error; err != nil {
 		t.Fatalf("failed to create wallet: %v", err)
 	}
 
 	transactions := []Transaction{
-		{DestWalletId: wallet.Id, Type: 1, Amount: 10},
-		{DestWalletId: wallet.Id, Type: 2, Amount: 20},
-		{DestWalletId: wallet.Id, Type: 3, Amount: 30},
+		{DestWalletId: wallet.Id, Type: 1, Amount: NewMoney(10, "USD")},
+		{DestWalletId: wallet.Id, Type: 2, Amount: NewMoney(20, "USD")},
+		{DestWalletId: wallet.Id, Type: 3, Amount: NewMoney(30, "USD")},
 	}
 
 	for _, txn := range transactions {
@@ -430,11 +695,10 @@ func TestGetTransactionHistory(t *testing.T) {
 		}
 	}
 
-	pageInfo := PageInfo{
-		Page:     1,
+	cursorInfo := CursorInfo{
 		PageSize: 2,
 	}
-	transactionsRes, err := model.GetTransactionHistory(context.Background(), user.Id, 1, pageInfo)
+	transactionsRes, _, err := model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{Type: TRANSACTION_TYPE_DEPOSIT, CursorInfo: cursorInfo})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -442,7 +706,7 @@ func TestGetTransactionHistory(t *testing.T) {
 		t.Errorf("expected 1 transaction, got %d", len(transactionsRes))
 	}
 
-	transactionsRes, err = model.GetTransactionHistory(context.Background(), user.Id, 2, pageInfo)
+	transactionsRes, _, err = model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{Type: TRANSACTION_TYPE_WITHDRAW, CursorInfo: cursorInfo})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -458,7 +722,7 @@ func TestGetTransactionHistory(t *testing.T) {
 		t.Fatalf("failed to create user: %v", err)
 	}
 
-	transactionsRes, err = model.GetTransactionHistory(context.Background(), userNoTransactions.Id, 0, pageInfo)
+	transactionsRes, _, err = model.GetTransactionHistory(context.Background(), userNoTransactions.Id, "USD", TransactionFilter{CursorInfo: cursorInfo})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -467,18 +731,107 @@ func TestGetTransactionHistory(t *testing.T) {
 	}
 }
 
+func TestGetTransactionHistoryCursor(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	wallet := Wallet{
+		UserId:  user.Id,
+		Balance: NewMoney(100, "USD"),
+	}
+	if err := db.Create(&wallet).Error; err != nil {
+		t.Fatalf("failed to create wallet: %v", err)
+	}
+
+	// Two transactions share the same created_at, id breaks the tie
+	now := time.Now().UTC()
+	createdAts := []time.Time{now.Add(-4 * time.Minute), now.Add(-3 * time.Minute), now.Add(-3 * time.Minute), now.Add(-2 * time.Minute), now.Add(-time.Minute)}
+	for i, createdAt := range createdAts {
+		txn := Transaction{Base: Base{CreatedAt: createdAt}, DestWalletId: wallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(int64(i+1), "USD")}
+		if err := db.Create(&txn).Error; err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+	}
+
+	firstPage, nextCursor, err := model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{CursorInfo: CursorInfo{PageSize: 2}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(firstPage) != 2 || firstPage[0].Amount.Amount != 5 || firstPage[1].Amount.Amount != 4 {
+		t.Fatalf("expected amounts 5, 4 on first page, got %v", firstPage)
+	}
+	if nextCursor == "" {
+		t.Fatalf("expected next cursor")
+	}
+
+	// A new transaction arriving mid-scroll does not shift the next pages
+	newTxn := Transaction{DestWalletId: wallet.Id, Type: TRANSACTION_TYPE_DEPOSIT, Amount: NewMoney(6, "USD")}
+	if err := db.Create(&newTxn).Error; err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+
+	var amounts []int64
+	for nextCursor != "" {
+		var page []Transaction
+		page, nextCursor, err = model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{CursorInfo: CursorInfo{Cursor: nextCursor, PageSize: 2}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, txn := range page {
+			amounts = append(amounts, txn.Amount.Amount)
+		}
+	}
+
+	if !slices.Equal(amounts, []int64{3, 2, 1}) {
+		t.Errorf("expected amounts 3, 2, 1 on the next pages, got %v", amounts)
+	}
+
+	_, _, err = model.GetTransactionHistory(context.Background(), user.Id, "USD", TransactionFilter{CursorInfo: CursorInfo{Cursor: "not a cursor"}})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
 func setupTestDB(t *testing.T) (*gorm.DB, func()) {
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("failed to open db: %v", err)
 	}
 
-	err = db.AutoMigrate(&User{}, &Wallet{}, &Transaction{})
+	err = db.AutoMigrate(&User{}, &Wallet{}, &Transaction{}, &WalletAmountSnapshot{}, &WalletDiscrepancy{}, &JournalEntry{}, &IdempotencyKey{}, &PendingTransfer{}, &DeadLetterTransfer{}, &FxQuote{}, &TransactionLimit{}, &WalletStatusEvent{}, &Hold{}, &ScheduledTransfer{}, &ScheduledTransferRun{}, &PaymentRequest{})
 	if err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
+	if err := createIndexes(db); err != nil {
+		t.Fatalf("failed to create indexes: %v", err)
+	}
 
 	cleanup := func() {
+		db.Exec("DELETE FROM wallet_discrepancies")
+		db.Exec("DELETE FROM wallet_snapshots")
+		db.Exec("DELETE FROM payment_requests")
+		db.Exec("DELETE FROM scheduled_transfer_runs")
+		db.Exec("DELETE FROM scheduled_transfers")
+		db.Exec("DELETE FROM holds")
+		db.Exec("DELETE FROM wallet_status_events")
+		db.Exec("DELETE FROM transaction_limits")
+		db.Exec("DELETE FROM fx_quotes")
+		db.Exec("DELETE FROM dead_letter_transfers")
+		db.Exec("DELETE FROM pending_transfers")
+		db.Exec("DELETE FROM idempotency_keys")
+		db.Exec("DELETE FROM journal_entries")
 		db.Exec("DELETE FROM transactions")
 		db.Exec("DELETE FROM wallets")
 		db.Exec("DELETE FROM users")
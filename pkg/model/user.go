@@ -2,31 +2,283 @@ package model
 
 import (
 	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PASSWORD_HASH_ITERATIONS = 600_000
+	PASSWORD_SALT_BYTES      = 16
+	PASSWORD_KEY_BYTES       = 32
+	PASSWORD_MIN_LENGTH      = 8
+)
+
+const (
+	USER_ROLE_USER  = "user"
+	USER_ROLE_ADMIN = "admin"
 )
 
 type User struct {
 	Base
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Wallet Wallet `json:"wallet"`
+	Name    string   `json:"name"`
+	Email   string   `gorm:"uniqueIndex:idx_users_email_unique" json:"email"`
+	Wallets []Wallet `json:"wallets"`
+
+	// Transaction limits apply per tier, unless the user has limits of their own
+	Tier string `gorm:"default:standard" json:"tier"`
+
+	// USER_ROLE_ADMIN may use the admin endpoints
+	Role string `gorm:"default:user" json:"role"`
+
+	// "pbkdf2-sha256$<iterations>$<salt>$<key>", empty means the user cannot log in
+	PasswordHash string `json:"-"`
 }
 
 func (*User) TableName() string {
 	return "users"
 }
 
-func (m *Model) GetAllUsers(ctx context.Context, pageInfo PageInfo) ([]*User, error) {
-	var users []*User
+// Matches query against name and email, case insensitive, an empty query matches every user
+func (m *Model) SearchUsers(ctx context.Context, query string, pageInfo PageInfo) ([]User, error) {
+	var users []User
+
+	if pageInfo.Page < 1 {
+		pageInfo.Page = 1
+	}
+
+	if pageInfo.PageSize < 1 || pageInfo.PageSize > 100 {
+		pageInfo.PageSize = 30
+	}
 
-	limit := pageInfo.PageSize
-	offset := (pageInfo.Page - 1) * pageInfo.PageSize
+	db := m.db.WithContext(ctx)
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
 
-	if err := m.db.WithContext(ctx).Preload("Wallet").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
-		return nil, fmt.Errorf("failed to get all users: %w", err)
+	if err := db.
+		Order("id").
+		Offset((pageInfo.Page - 1) * pageInfo.PageSize).
+		Limit(pageInfo.PageSize).
+		Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
 	}
 
-	slog.Info("Successfully get all users", "count", len(users))
+	slog.Info("Successfully searched users", "count", len(users))
 	return users, nil
 }
+
+// User with every wallet they hold, whatever its status
+func (m *Model) GetUser(ctx context.Context, userId uint64) (User, error) {
+	var user User
+
+	err := m.db.WithContext(ctx).Preload("Wallets", func(db *gorm.DB) *gorm.DB {
+		return db.Order("currency")
+	}).First(&user, userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, ErrUserNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// Roles the user holds now, none if the user does not exist
+func (m *Model) GetUserRoles(ctx context.Context, userId uint64) ([]string, error) {
+	var roles []string
+
+	if err := m.db.WithContext(ctx).Model(&User{}).Where("id = ?", userId).Pluck("role", &roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Emails are unique, they are what users log in with
+func (m *Model) CreateUser(ctx context.Context, name, email, password string) (User, error) {
+	user := User{
+		Name:  strings.TrimSpace(name),
+		Email: normalizeEmail(email),
+	}
+
+	if user.Name == "" || !strings.Contains(user.Email, "@") || len(password) < PASSWORD_MIN_LENGTH {
+		return user, ErrBadInput
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return user, err
+	}
+	user.PasswordHash = passwordHash
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var emailCount int64
+		if err := tx.Model(&User{}).Where("email = ?", user.Email).Count(&emailCount).Error; err != nil {
+			return fmt.Errorf("failed to check email: %w", err)
+		}
+		if emailCount > 0 {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return user, err
+	}
+
+	slog.Info("Successfully created user", "user_id", user.Id)
+	return user, nil
+}
+
+// Sets or resets the password, users without one cannot log in until it is set
+func (m *Model) SetUserPassword(ctx context.Context, userId uint64, password string) error {
+	if len(password) < PASSWORD_MIN_LENGTH {
+		return ErrBadInput
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	result := m.db.WithContext(ctx).Model(&User{}).Where("id = ?", userId).Update("password_hash", passwordHash)
+	if result.Error != nil {
+		return fmt.Errorf("failed to set password: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	slog.Info("Successfully set user password", "user_id", userId)
+	return nil
+}
+
+// Stored and looked up trimmed and lowercased, so "User_A@Crypto.com" is the same user as "user_a@crypto.com"
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Older databases had no unique index on email and kept emails as typed, duplicates must be renamed before they are normalized
+// The oldest user keeps the email, later ones get "<local>+duplicate-<id>@<domain>" and an admin sorts them out
+func dedupeUserEmails(db *gorm.DB) error {
+	var emails []string
+	if err := db.Model(&User{}).
+		Select("LOWER(TRIM(email)) AS email").
+		Group("LOWER(TRIM(email))").
+		Having("COUNT(*) > 1").
+		Pluck("email", &emails).Error; err != nil {
+		return fmt.Errorf("failed to find duplicate emails: %w", err)
+	}
+
+	for _, email := range emails {
+		var users []User
+		if err := db.Where("LOWER(TRIM(email)) = ?", email).Order("id").Find(&users).Error; err != nil {
+			return fmt.Errorf("failed to find users by email: %w", err)
+		}
+
+		for _, user := range users[1:] {
+			renamed := fmt.Sprintf("%s+duplicate-%d", email, user.Id)
+			if at := strings.LastIndex(email, "@"); at >= 0 {
+				renamed = fmt.Sprintf("%s+duplicate-%d%s", email[:at], user.Id, email[at:])
+			}
+
+			if err := db.Model(&User{}).Where("id = ?", user.Id).Update("email", renamed).Error; err != nil {
+				return fmt.Errorf("failed to rename duplicate email: %w", err)
+			}
+			slog.Warn("Renamed duplicate user email", "user_id", user.Id, "email", renamed, "kept_by_user_id", users[0].Id)
+		}
+	}
+
+	if err := db.Model(&User{}).Where("email <> LOWER(TRIM(email))").Update("email", gorm.Expr("LOWER(TRIM(email))")).Error; err != nil {
+		return fmt.Errorf("failed to normalize emails: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Model) AuthenticateUser(ctx context.Context, email, password string) (User, error) {
+	var user User
+
+	err := m.db.WithContext(ctx).Where("email = ?", normalizeEmail(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Hash anyway, so response time does not tell whether the email exists
+		_, _ = HashPassword(password)
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if !checkPassword(user.PasswordHash, password) {
+		return user, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, PASSWORD_SALT_BYTES)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, PASSWORD_HASH_ITERATIONS, PASSWORD_KEY_BYTES)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s",
+		PASSWORD_HASH_ITERATIONS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func checkPassword(passwordHash, password string) bool {
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
@@ -0,0 +1,277 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateUser(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	passwordHash, err := HashPassword("correct horse")
+	assert.NoError(t, err)
+
+	user := User{
+		Name:         "User A",
+		Email:        "user_a@crypto.com",
+		PasswordHash: passwordHash,
+	}
+	assert.NoError(t, db.Create(&user).Error)
+
+	authenticated, err := model.AuthenticateUser(context.Background(), "user_a@crypto.com", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, authenticated.Id)
+
+	// Emails are case insensitive
+	authenticated, err = model.AuthenticateUser(context.Background(), " User_A@Crypto.com ", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, authenticated.Id)
+
+	_, err = model.AuthenticateUser(context.Background(), "user_a@crypto.com", "wrong")
+	assert.True(t, errors.Is(err, ErrInvalidCredentials), "expected ErrInvalidCredentials, got %v", err)
+
+	_, err = model.AuthenticateUser(context.Background(), "nobody@crypto.com", "correct horse")
+	assert.True(t, errors.Is(err, ErrInvalidCredentials), "expected ErrInvalidCredentials, got %v", err)
+}
+
+func TestSearchUsers(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	users := []User{
+		{Name: "Alice Tan", Email: "alice@crypto.com", Wallets: []Wallet{{Currency: "USD"}, {Currency: "EUR"}}},
+		{Name: "Bob Lim", Email: "bob@crypto.com"},
+		{Name: "Carol", Email: "carol_100%@crypto.com"},
+	}
+	assert.NoError(t, db.Create(&users).Error)
+
+	tests := []struct {
+		name     string
+		query    string
+		pageInfo PageInfo
+		want     []string
+	}{
+		{"Every user", "", PageInfo{}, []string{"Alice Tan", "Bob Lim", "Carol"}},
+		{"By name, case insensitive", "aLiCe", PageInfo{}, []string{"Alice Tan"}},
+		{"By email", "bob@", PageInfo{}, []string{"Bob Lim"}},
+		{"Wildcards are literal", "100%", PageInfo{}, []string{"Carol"}},
+		{"Underscore is literal", "b_b", PageInfo{}, []string{}},
+		{"Second page", "", PageInfo{Page: 2, PageSize: 2}, []string{"Carol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := model.SearchUsers(context.Background(), tt.query, tt.pageInfo)
+			assert.NoError(t, err)
+
+			names := []string{}
+			for _, user := range found {
+				names = append(names, user.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+
+	user, err := model.GetUser(context.Background(), users[0].Id)
+	assert.NoError(t, err)
+	assert.Len(t, user.Wallets, 2)
+	assert.Equal(t, "EUR", user.Wallets[0].Currency)
+	assert.Equal(t, WALLET_STATUS_ACTIVE, user.Wallets[0].Status)
+	assert.Equal(t, USER_ROLE_USER, user.Role)
+
+	_, err = model.GetUser(context.Background(), 9999)
+	assert.True(t, errors.Is(err, ErrUserNotFound), "expected ErrUserNotFound, got %v", err)
+}
+
+func TestCreateUser(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	ctx := context.Background()
+
+	user, err := model.CreateUser(ctx, "User A", " User_A@Crypto.com ", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, "user_a@crypto.com", user.Email)
+
+	authenticated, err := model.AuthenticateUser(ctx, "user_a@crypto.com", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, authenticated.Id)
+
+	tests := []struct {
+		name     string
+		userName string
+		email    string
+		password string
+		want     error
+	}{
+		{"Email taken", "Someone Else", "user_a@crypto.com", "correct horse", ErrEmailTaken},
+		{"Email taken in another case", "Someone Else", "USER_A@crypto.com", "correct horse", ErrEmailTaken},
+		{"No name", "", "user_b@crypto.com", "correct horse", ErrBadInput},
+		{"Not an email", "User B", "user_b", "correct horse", ErrBadInput},
+		{"Password too short", "User B", "user_b@crypto.com", "short", ErrBadInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.CreateUser(ctx, tt.userName, tt.email, tt.password)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// The unique index holds even when the check is bypassed
+	assert.Error(t, db.Create(&User{Name: "Someone Else", Email: "user_a@crypto.com"}).Error)
+}
+
+func TestSetUserPassword(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	ctx := context.Background()
+
+	// Predates login, no password yet
+	user := User{Name: "User A", Email: "user_a@crypto.com"}
+	assert.NoError(t, db.Create(&user).Error)
+
+	_, err := model.AuthenticateUser(ctx, "user_a@crypto.com", "")
+	assert.True(t, errors.Is(err, ErrInvalidCredentials), "expected ErrInvalidCredentials, got %v", err)
+
+	assert.NoError(t, model.SetUserPassword(ctx, user.Id, "correct horse"))
+
+	authenticated, err := model.AuthenticateUser(ctx, "user_a@crypto.com", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, authenticated.Id)
+
+	err = model.SetUserPassword(ctx, user.Id, "short")
+	assert.True(t, errors.Is(err, ErrBadInput), "expected ErrBadInput, got %v", err)
+
+	err = model.SetUserPassword(ctx, 999, "correct horse")
+	assert.True(t, errors.Is(err, ErrUserNotFound), "expected ErrUserNotFound, got %v", err)
+}
+
+func TestDedupeUserEmails(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// As in databases predating the unique index
+	assert.NoError(t, db.Migrator().DropIndex(&User{}, "idx_users_email_unique"))
+
+	users := []User{
+		{Name: "User A", Email: "user_a@crypto.com"},
+		{Name: "User B", Email: "user_a@crypto.com"},
+		{Name: "User C", Email: "user_c@crypto.com"},
+		{Name: "User D", Email: "user_a@crypto.com"},
+	}
+	assert.NoError(t, db.Create(&users).Error)
+
+	assert.NoError(t, dedupeUserEmails(db))
+
+	var emails []string
+	assert.NoError(t, db.Model(&User{}).Order("id").Pluck("email", &emails).Error)
+	assert.Equal(t, []string{
+		"user_a@crypto.com",
+		fmt.Sprintf("user_a+duplicate-%d@crypto.com", users[1].Id),
+		"user_c@crypto.com",
+		fmt.Sprintf("user_a+duplicate-%d@crypto.com", users[3].Id),
+	}, emails)
+
+	assert.NoError(t, db.Migrator().CreateIndex(&User{}, "idx_users_email_unique"))
+}
+
+func TestDedupeUserEmailsCase(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// As in databases predating normalized emails, the unique index let these through
+	users := []User{
+		{Name: "User A", Email: "User_A@Crypto.com"},
+		{Name: "User B", Email: " user_a@crypto.com"},
+		{Name: "User C", Email: "User_C@crypto.com"},
+	}
+	assert.NoError(t, db.Create(&users).Error)
+
+	assert.NoError(t, dedupeUserEmails(db))
+
+	var emails []string
+	assert.NoError(t, db.Model(&User{}).Order("id").Pluck("email", &emails).Error)
+	assert.Equal(t, []string{
+		"user_a@crypto.com",
+		fmt.Sprintf("user_a+duplicate-%d@crypto.com", users[1].Id),
+		"user_c@crypto.com",
+	}, emails)
+}
+
+func TestGetUserRoles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	ctx := context.Background()
+
+	admin := User{Name: "Admin", Email: "admin@crypto.com", Role: USER_ROLE_ADMIN}
+	assert.NoError(t, db.Create(&admin).Error)
+
+	roles, err := model.GetUserRoles(ctx, admin.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{USER_ROLE_ADMIN}, roles)
+
+	// Revoked, applies on the next lookup
+	assert.NoError(t, db.Model(&admin).Update("role", USER_ROLE_USER).Error)
+
+	roles, err = model.GetUserRoles(ctx, admin.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{USER_ROLE_USER}, roles)
+
+	roles, err = model.GetUserRoles(ctx, 999)
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	ctx := context.Background()
+
+	assert.NoError(t, model.bootstrapAdmin(ctx, "root@crypto.com", "correct horse"))
+
+	admin, err := model.AuthenticateUser(ctx, "root@crypto.com", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, USER_ROLE_ADMIN, admin.Role)
+
+	// On every restart, the existing admin is left as it is
+	assert.NoError(t, model.bootstrapAdmin(ctx, "root@crypto.com", "another password"))
+
+	_, err = model.AuthenticateUser(ctx, "root@crypto.com", "correct horse")
+	assert.NoError(t, err)
+
+	err = model.bootstrapAdmin(ctx, "admin@crypto.com", "")
+	assert.True(t, errors.Is(err, ErrBadInput), "expected ErrBadInput, got %v", err)
+}
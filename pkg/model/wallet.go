@@ -4,37 +4,119 @@ import (
 	"context"
 	"fmt"
 	"js-centralized-wallet/pkg/trace"
+
+	"gorm.io/gorm"
+)
+
+type WalletStatus int
+
+const (
+	WALLET_STATUS_ACTIVE WalletStatus = iota + 1
+	WALLET_STATUS_FROZEN
+	WALLET_STATUS_CLOSED
 )
 
+func (s WalletStatus) String() string {
+	switch s {
+	case WALLET_STATUS_ACTIVE:
+		return "active"
+	case WALLET_STATUS_FROZEN:
+		return "frozen"
+	case WALLET_STATUS_CLOSED:
+		return "closed"
+	default:
+		return "-"
+	}
+}
+
+// One wallet per user and currency
 type Wallet struct {
 	Base
-	UserId  uint64 `json:"user_id"`
-	Balance int64  `json:"balance"`
-}
+	UserId   uint64 `gorm:"uniqueIndex:idx_wallets_user_currency,where:system_account = ''" json:"user_id"`
+	Currency string `gorm:"default:USD;uniqueIndex:idx_wallets_user_currency;uniqueIndex:idx_wallets_system_account_currency,priority:2" json:"currency"`
+	Balance  Money  `json:"balance"`
 
-type TransferService interface {
-	TransferBalance(ctx context.Context, source, dest uint64, amount int64) error
-	InvalidateWalletCache(ctx context.Context, userIds ...uint64)
+	// Balance less the amount reserved by active holds, what withdrawals and transfers can spend
+	AvailableBalance Money `json:"available_balance"`
+
+	// Empty for user wallets, otherwise one of the SYSTEM_ACCOUNT_* ledger accounts
+	SystemAccount string `gorm:"default:'';uniqueIndex:idx_wallets_system_account_currency,priority:1,where:system_account <> ''" json:"system_account,omitempty"`
+
+	// Only active wallets move money, the reason is given by the admin who last changed the status
+	Status       WalletStatus `gorm:"default:1" json:"status"`
+	StatusReason string       `json:"status_reason,omitempty"`
 }
 
 func (*Wallet) TableName() string {
 	return "wallets"
 }
 
-func (m *Model) GetWalletBalance(ctx context.Context, userId uint64) (int64, error) {
+// New wallets have no holds, everything in them is available
+func (w *Wallet) BeforeCreate(tx *gorm.DB) error {
+	if w.AvailableBalance.IsZero() {
+		w.AvailableBalance = w.Balance
+	}
+	return nil
+}
+
+func (w *Wallet) AfterFind(tx *gorm.DB) error {
+	w.Balance.Currency = w.Currency
+	w.AvailableBalance.Currency = w.Currency
+	return nil
+}
+
+// Checked under the wallet's row lock, so a freeze cannot slip in between the check and the posting
+func (w *Wallet) checkActive() error {
+	switch w.Status {
+	case WALLET_STATUS_FROZEN:
+		return ErrWalletFrozen
+	case WALLET_STATUS_CLOSED:
+		return ErrWalletClosed
+	default:
+		return nil
+	}
+}
+
+// Scopes wallet queries to the user's own wallets, never a system wallet
+func ownedBy(userId uint64) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND system_account = ?", userId, "")
+	}
+}
+
+func inCurrency(currency string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("currency = ?", currency)
+	}
+}
+
+func (m *Model) GetWalletBalance(ctx context.Context, userId uint64, currency string) (Money, error) {
 	ctx, lg := trace.Logger(ctx)
 
-	var balance int64
+	balance := Money{Currency: currency}
 
 	if err := m.db.WithContext(ctx).
 		Model(&Wallet{}).
 		Select("balance").
-		Where("user_id = ?", userId).
-		Scan(&balance).Error; err != nil {
-		return 0, fmt.Errorf("failed to get wallet balance: %w", err)
+		Scopes(ownedBy(userId), inCurrency(currency)).
+		Scan(&balance.Amount).Error; err != nil {
+		return balance, fmt.Errorf("failed to get wallet balance: %w", err)
 	}
 
-	lg.Info(fmt.Sprintf("retrieved wallet balance for user = %d: $%d", userId, balance))
+	lg.Info(fmt.Sprintf("retrieved wallet balance for user = %d: %s", userId, balance))
 
 	return balance, nil
 }
+
+func (m *Model) GetWallets(ctx context.Context, userId uint64) ([]Wallet, error) {
+	var wallets []Wallet
+
+	if err := m.db.WithContext(ctx).
+		Scopes(ownedBy(userId)).
+		Order("currency").
+		Find(&wallets).Error; err != nil {
+		return nil, fmt.Errorf("failed to get wallets: %w", err)
+	}
+
+	return wallets, nil
+}
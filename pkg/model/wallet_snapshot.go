@@ -2,23 +2,135 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Each wallet is synced in a DB transaction of its own, bounded so one stuck wallet does not hold up the sweep
+	WALLET_SNAPSHOT_TIMEOUT = 10 * time.Second
 )
 
 type WalletAmountSnapshot struct {
 	Base
 	WalletId uint64 `gorm:"index"`
 	Amount   int64  `json:"amount"`
+
+	// Last posting summed into Amount, the next snapshot carries on from the one after
+	// Windowing on created_at would miss postings inserted before a snapshot but committed after it
+	TransactionId uint64 `json:"transaction_id"`
 }
 
 func (*WalletAmountSnapshot) TableName() string {
 	return "wallet_snapshots"
 }
 
-// TODO:
-// Adds up previous day transactions logs, and adds last wallet snapshot record from the day before, summing up a total to compare to balance
-// If does not tally, update the wallet balance to the sum amount
-func (m *Model) SyncWalletSnapshots(ctx context.Context) error {
-	// ctx, _ = trace.Logger(ctx)
+// Recorded whenever a wallet balance does not tally with its ledger
+// Never used to "fix" the balance, someone has to investigate it
+type WalletDiscrepancy struct {
+	Base
+	WalletId      uint64 `gorm:"index" json:"wallet_id"`
+	SnapshotId    uint64 `json:"snapshot_id"`
+	LedgerAmount  int64  `json:"ledger_amount"`
+	WalletBalance int64  `json:"wallet_balance"`
+	Drift         int64  `json:"drift"`
+}
+
+func (*WalletDiscrepancy) TableName() string {
+	return "wallet_discrepancies"
+}
+
+// Adds up transaction logs since the last wallet snapshot, plus the last snapshot amount, and writes a new snapshot
+// If the sum does not tally with the wallet balance, the drift is recorded in wallet_discrepancies and returned
+func (m *Model) SyncWalletSnapshots(ctx context.Context) ([]WalletDiscrepancy, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var walletIds []uint64
+	if err := m.db.WithContext(ctx).Model(&Wallet{}).Order("id").Pluck("id", &walletIds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list wallets: %w", err)
+	}
+
+	// One failed wallet does not hold back the rest, it is picked up again on the next sweep
+	var discrepancies []WalletDiscrepancy
+	failed := 0
+	for _, walletId := range walletIds {
+		walletCtx, cancel := context.WithTimeout(ctx, WALLET_SNAPSHOT_TIMEOUT)
+		discrepancy, err := m.syncWalletSnapshot(walletCtx, walletId)
+		cancel()
+		if err != nil {
+			lg.Error(fmt.Sprintf("failed to sync wallet %d snapshot: %v", walletId, err))
+			failed++
+			continue
+		}
+
+		// Logged by the caller, along with the discrepancy id
+		if discrepancy != nil {
+			discrepancies = append(discrepancies, *discrepancy)
+		}
+	}
+
+	lg.Info(fmt.Sprintf("synced %d wallet snapshots, %d failed, %d discrepancies", len(walletIds)-failed, failed, len(discrepancies)))
+
+	return discrepancies, nil
+}
+
+func (m *Model) syncWalletSnapshot(ctx context.Context, walletId uint64) (*WalletDiscrepancy, error) {
+	var discrepancy *WalletDiscrepancy
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		// Update lock, conflicts with every writer as postJournalEntry updates the wallet before inserting its postings
+		// Once we hold it, every posting to the wallet is committed and visible, and none can be added until we are done
+		var wallet Wallet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", walletId).First(&wallet).Error; err != nil {
+			return err
+		}
+
+		var lastSnapshot WalletAmountSnapshot
+		err := tx.Where("wallet_id = ?", walletId).Order("id desc").First(&lastSnapshot).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var since struct {
+			Sum    int64
+			LastId uint64
+		}
+		if err := tx.Model(&Transaction{}).
+			Where("dest_wallet_id = ? AND id > ?", walletId, lastSnapshot.TransactionId).
+			Select("COALESCE(SUM(amount), 0) AS sum, COALESCE(MAX(id), 0) AS last_id").
+			Scan(&since).Error; err != nil {
+			return err
+		}
+
+		snapshot := WalletAmountSnapshot{
+			WalletId:      walletId,
+			Amount:        lastSnapshot.Amount + since.Sum,
+			TransactionId: max(lastSnapshot.TransactionId, since.LastId),
+		}
+		if err := tx.Create(&snapshot).Error; err != nil {
+			return err
+		}
+
+		if snapshot.Amount == wallet.Balance.Amount {
+			return nil
+		}
+
+		discrepancy = &WalletDiscrepancy{
+			WalletId:      walletId,
+			SnapshotId:    snapshot.Id,
+			LedgerAmount:  snapshot.Amount,
+			WalletBalance: wallet.Balance.Amount,
+			Drift:         wallet.Balance.Amount - snapshot.Amount,
+		}
+
+		return tx.Create(discrepancy).Error
+	})
 
-	return nil
+	return discrepancy, err
 }
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWalletSnapshots(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+	}
+	err := db.Create(&user).Error
+	assert.NoError(t, err)
+
+	wallet := Wallet{
+		UserId:  user.Id,
+		Balance: NewMoney(0, "USD"),
+	}
+	err = db.Create(&wallet).Error
+	assert.NoError(t, err)
+
+	_, err = model.Deposit(context.Background(), user.Id, NewMoney(150, "USD"))
+	assert.NoError(t, err)
+	_, err = model.Withdraw(context.Background(), user.Id, NewMoney(50, "USD"))
+	assert.NoError(t, err)
+
+	discrepancies, err := model.SyncWalletSnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+
+	var snapshot WalletAmountSnapshot
+	err = db.Where("wallet_id = ?", wallet.Id).Order("id desc").First(&snapshot).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), snapshot.Amount)
+
+	// Builds on top of the previous snapshot
+	_, err = model.Deposit(context.Background(), user.Id, NewMoney(30, "USD"))
+	assert.NoError(t, err)
+
+	discrepancies, err = model.SyncWalletSnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+
+	var nextSnapshot WalletAmountSnapshot
+	err = db.Where("wallet_id = ?", wallet.Id).Order("id desc").First(&nextSnapshot).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(130), nextSnapshot.Amount)
+
+	// Balance moved without a transaction log
+	err = db.Model(&Wallet{}).Where("id = ?", wallet.Id).Update("balance", 200).Error
+	assert.NoError(t, err)
+
+	discrepancies, err = model.SyncWalletSnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, discrepancies, 1)
+	assert.Equal(t, int64(70), discrepancies[0].Drift)
+	assert.Equal(t, int64(130), discrepancies[0].LedgerAmount)
+
+	// Reported, not fixed
+	var balance int64
+	err = db.Model(&Wallet{}).Select("balance").Where("id = ?", wallet.Id).Scan(&balance).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), balance)
+
+	var count int64
+	db.Model(&WalletDiscrepancy{}).Where("wallet_id = ?", wallet.Id).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSyncWalletSnapshotsLateCommit(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+	}
+	err := db.Create(&user).Error
+	assert.NoError(t, err)
+
+	wallet := Wallet{
+		UserId:  user.Id,
+		Balance: NewMoney(0, "USD"),
+	}
+	err = db.Create(&wallet).Error
+	assert.NoError(t, err)
+
+	_, err = model.Deposit(context.Background(), user.Id, NewMoney(100, "USD"))
+	assert.NoError(t, err)
+
+	_, err = model.SyncWalletSnapshots(context.Background())
+	assert.NoError(t, err)
+
+	// Stamped before the snapshot, committed after it
+	late := Transaction{
+		Base:         Base{CreatedAt: time.Now().Add(-time.Hour)},
+		DestWalletId: wallet.Id,
+		Currency:     "USD",
+		Amount:       NewMoney(25, "USD"),
+		Type:         TRANSACTION_TYPE_DEPOSIT,
+	}
+	err = db.Create(&late).Error
+	assert.NoError(t, err)
+	err = db.Model(&Wallet{}).Where("id = ?", wallet.Id).Update("balance", 125).Error
+	assert.NoError(t, err)
+
+	discrepancies, err := model.SyncWalletSnapshots(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+
+	var snapshot WalletAmountSnapshot
+	err = db.Where("wallet_id = ?", wallet.Id).Order("id desc").First(&snapshot).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(125), snapshot.Amount)
+	assert.Equal(t, late.Id, snapshot.TransactionId)
+}
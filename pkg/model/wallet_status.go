@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"js-centralized-wallet/pkg/trace"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit trail of wallet status changes, one row per change, never updated
+type WalletStatusEvent struct {
+	Base
+	WalletId   uint64       `gorm:"index" json:"wallet_id"`
+	FromStatus WalletStatus `json:"from_status"`
+	ToStatus   WalletStatus `json:"to_status"`
+	Reason     string       `json:"reason"`
+
+	// Admin who changed the status
+	ActorUserId uint64 `json:"actor_user_id"`
+}
+
+func (*WalletStatusEvent) TableName() string {
+	return "wallet_status_events"
+}
+
+// Stops the wallet from moving money in either direction until it is unfrozen
+func (m *Model) FreezeWallet(ctx context.Context, walletId, actorUserId uint64, reason string) (Wallet, error) {
+	return m.setWalletStatus(ctx, walletId, actorUserId, WALLET_STATUS_FROZEN, reason)
+}
+
+func (m *Model) UnfreezeWallet(ctx context.Context, walletId, actorUserId uint64, reason string) (Wallet, error) {
+	return m.setWalletStatus(ctx, walletId, actorUserId, WALLET_STATUS_ACTIVE, reason)
+}
+
+// Only user wallets change status, closed is final
+// Setting the status a wallet already has is a no-op and records no event
+func (m *Model) setWalletStatus(ctx context.Context, walletId, actorUserId uint64, status WalletStatus, reason string) (Wallet, error) {
+	ctx, lg := trace.Logger(ctx)
+
+	var wallet Wallet
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return wallet, ErrBadInput
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Same lock as money movements, so in-flight postings finish before the status changes
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND system_account = ?", walletId, "").First(&wallet).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if wallet.Status == WALLET_STATUS_CLOSED {
+			return ErrWalletClosed
+		}
+
+		if wallet.Status == status {
+			return nil
+		}
+
+		event := WalletStatusEvent{
+			WalletId:    wallet.Id,
+			FromStatus:  wallet.Status,
+			ToStatus:    status,
+			Reason:      reason,
+			ActorUserId: actorUserId,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+
+		wallet.Status = status
+		wallet.StatusReason = reason
+
+		return tx.Model(&wallet).Updates(map[string]interface{}{
+			"status":        wallet.Status,
+			"status_reason": wallet.StatusReason,
+		}).Error
+	})
+	if err != nil {
+		return wallet, fmt.Errorf("failed to set wallet status: %w", err)
+	}
+
+	lg.Info(fmt.Sprintf("wallet_id %d is %s by user_id %d", walletId, status, actorUserId))
+
+	return wallet, nil
+}
+
+// Newest first
+func (m *Model) GetWalletStatusEvents(ctx context.Context, walletId uint64) ([]WalletStatusEvent, error) {
+	var events []WalletStatusEvent
+
+	if err := m.db.WithContext(ctx).
+		Where("wallet_id = ?", walletId).
+		Order("created_at desc, id desc").
+		Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to get wallet status events: %w", err)
+	}
+
+	return events, nil
+}
+
+// Frozen or closed destinations are not told apart to the sender
+func checkTransferWallets(sourceWallet, destWallet *Wallet) error {
+	if err := sourceWallet.checkActive(); err != nil {
+		return err
+	}
+
+	if destWallet.checkActive() != nil {
+		return ErrDestinationUnavailable
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestFrozenWallet(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	source := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	dest := User{
+		Name:  "User B",
+		Email: "user_b@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD", Balance: NewMoney(1000, "USD")},
+		},
+	}
+	admin := User{
+		Name:  "Admin",
+		Email: "admin@crypto.com",
+		Role:  USER_ROLE_ADMIN,
+	}
+	for _, user := range []*User{&source, &dest, &admin} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	amount := NewMoney(100, "USD")
+
+	transfer := func(sourceUserId, destUserId uint64) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			_, err := model.transferBalance(ctx, tx, sourceUserId, destUserId, amount, "")
+			return err
+		})
+	}
+
+	wallet, err := model.FreezeWallet(ctx, source.Wallets[0].Id, admin.Id, "Fraud investigation #42")
+	assert.NoError(t, err)
+	assert.Equal(t, WALLET_STATUS_FROZEN, wallet.Status)
+	assert.Equal(t, "Fraud investigation #42", wallet.StatusReason)
+
+	_, err = model.Deposit(ctx, source.Id, amount)
+	assert.True(t, errors.Is(err, ErrWalletFrozen), "expected ErrWalletFrozen, got %v", err)
+
+	_, err = model.Withdraw(ctx, source.Id, amount)
+	assert.True(t, errors.Is(err, ErrWalletFrozen), "expected ErrWalletFrozen, got %v", err)
+
+	// Nor can the funds go into a wallet opened in another currency
+	_, err = model.Deposit(ctx, source.Id, NewMoney(100, "EUR"))
+	assert.True(t, errors.Is(err, ErrWalletOpenRestricted), "expected ErrWalletOpenRestricted, got %v", err)
+
+	assert.True(t, errors.Is(transfer(source.Id, dest.Id), ErrWalletFrozen))
+	assert.True(t, errors.Is(model.ValidateTransfer(ctx, source.Id, dest.Id, amount), ErrWalletFrozen))
+
+	// Senders are not told the destination is frozen
+	assert.True(t, errors.Is(transfer(dest.Id, source.Id), ErrDestinationUnavailable))
+	assert.True(t, errors.Is(model.ValidateTransfer(ctx, dest.Id, source.Id, amount), ErrDestinationUnavailable))
+
+	// Freezing again is a no-op
+	_, err = model.FreezeWallet(ctx, source.Wallets[0].Id, admin.Id, "Still investigating")
+	assert.NoError(t, err)
+
+	wallet, err = model.UnfreezeWallet(ctx, source.Wallets[0].Id, admin.Id, "Cleared")
+	assert.NoError(t, err)
+	assert.Equal(t, WALLET_STATUS_ACTIVE, wallet.Status)
+
+	_, err = model.Withdraw(ctx, source.Id, amount)
+	assert.NoError(t, err)
+	assert.NoError(t, transfer(source.Id, dest.Id))
+
+	_, err = model.Deposit(ctx, source.Id, NewMoney(100, "EUR"))
+	assert.NoError(t, err)
+
+	events, err := model.GetWalletStatusEvents(ctx, source.Wallets[0].Id)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "Cleared", events[0].Reason)
+	assert.Equal(t, WALLET_STATUS_FROZEN, events[0].FromStatus)
+	assert.Equal(t, WALLET_STATUS_ACTIVE, events[0].ToStatus)
+	assert.Equal(t, admin.Id, events[0].ActorUserId)
+	assert.Equal(t, "Fraud investigation #42", events[1].Reason)
+	assert.Equal(t, WALLET_STATUS_ACTIVE, events[1].FromStatus)
+	assert.Equal(t, WALLET_STATUS_FROZEN, events[1].ToStatus)
+}
+
+func TestSetWalletStatusInvalid(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	user := User{
+		Name:  "User A",
+		Email: "user_a@crypto.com",
+		Wallets: []Wallet{
+			{Currency: "USD"},
+			{Currency: "EUR", Status: WALLET_STATUS_CLOSED},
+		},
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	cashWallet, err := systemWallet(db, SYSTEM_ACCOUNT_EXTERNAL_CASH, "USD")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		walletId uint64
+		reason   string
+		want     error
+	}{
+		{"Without reason", user.Wallets[0].Id, " ", ErrBadInput},
+		{"Unknown wallet", 9999, "Fraud", ErrWalletNotFound},
+		{"System wallet", cashWallet.Id, "Fraud", ErrWalletNotFound},
+		{"Closed wallet", user.Wallets[1].Id, "Fraud", ErrWalletClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.FreezeWallet(context.Background(), tt.walletId, 1, tt.reason)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+
+	// A closed wallet keeps the user from opening new ones
+	_, err = model.Deposit(context.Background(), user.Id, NewMoney(100, "GBP"))
+	assert.True(t, errors.Is(err, ErrWalletOpenRestricted), "expected ErrWalletOpenRestricted, got %v", err)
+}
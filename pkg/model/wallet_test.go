@@ -17,13 +17,42 @@ func TestGetWalletBalance(t *testing.T) {
 
 	wallet := Wallet{
 		UserId:  42,
-		Balance: 1000,
+		Balance: NewMoney(1000, "USD"),
 	}
 	err := db.Create(&wallet).Error
 	assert.NoError(t, err)
 
-	balance, err := model.GetWalletBalance(context.Background(), wallet.UserId)
+	balance, err := model.GetWalletBalance(context.Background(), wallet.UserId, "USD")
 
 	assert.NoError(t, err)
-	assert.Equal(t, int64(1000), balance)
+	assert.Equal(t, int64(1000), balance.Amount)
+}
+
+func TestGetWalletsPerCurrency(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	model := &Model{
+		db: db,
+	}
+
+	wallets := []Wallet{
+		{UserId: 42, Currency: "USD", Balance: NewMoney(1000, "USD")},
+		{UserId: 42, Currency: "EUR", Balance: NewMoney(500, "EUR")},
+	}
+	assert.NoError(t, db.Create(&wallets).Error)
+
+	// Only one wallet per user and currency
+	err := db.Create(&Wallet{UserId: 42, Currency: "EUR"}).Error
+	assert.Error(t, err)
+
+	result, err := model.GetWallets(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, "EUR", result[0].Currency)
+	assert.Equal(t, "USD", result[1].Currency)
+
+	balance, err := model.GetWalletBalance(context.Background(), 42, "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), balance.Amount)
 }
@@ -2,30 +2,78 @@ package model
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"js-centralized-wallet/pkg/trace"
+	"sync"
+	"time"
 )
 
 type TransferJob struct {
 	Ctx          context.Context
+	Id           uint64
+	ClaimToken   string
+	Attempts     int
 	SourceUserId uint64
 	DestUserId   uint64
-	Amount       int64
+	Amount       Money
+	Memo         string
+}
+
+type TransferService interface {
+	ClaimTransfers(ctx context.Context, limit int, lease time.Duration) ([]TransferJob, error)
+	ExecuteTransfer(ctx context.Context, job TransferJob) error
+	FailTransfer(ctx context.Context, job TransferJob, cause error) error
+	RetryTransfer(ctx context.Context, job TransferJob, delay time.Duration, cause error) error
+	InvalidateWalletCache(ctx context.Context, userIds ...uint64)
+}
+
+// Where jobs that exhausted their retries end up
+type DeadLetterStore interface {
+	DeadLetterTransfer(ctx context.Context, job TransferJob, cause error) error
+}
+
+// Only transient errors are retried, see IsTransientError
+type RetryPolicy struct {
+	// Including the first attempt, 1 means no retry
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Exponential backoff, doubles on every attempt up to MaxDelay
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < attempt && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, r.MaxDelay)
 }
 
 type TransferWorkerPool struct {
-	model      TransferService
-	jobChan    <-chan TransferJob
-	numWorkers int
+	model        TransferService
+	numWorkers   int
+	pollInterval time.Duration
+	lease        time.Duration
+	retryPolicy  RetryPolicy
+	deadLetter   DeadLetterStore
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
 }
 
 type TransferWorkerOption func(*TransferWorkerPool)
 
-func NewTransferWorkerPool(model TransferService, jobChan <-chan TransferJob, opts ...TransferWorkerOption) *TransferWorkerPool {
+func NewTransferWorkerPool(model TransferService, opts ...TransferWorkerOption) *TransferWorkerPool {
 	pool := &TransferWorkerPool{
-		model:      model,
-		jobChan:    jobChan,
-		numWorkers: 3,
+		model:        model,
+		numWorkers:   3,
+		pollInterval: 500 * time.Millisecond,
+		lease:        time.Minute,
+		retryPolicy:  RetryPolicy{MaxAttempts: 1},
+		stop:         make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(pool)
@@ -39,25 +87,132 @@ func WithNumWorkers(n int) TransferWorkerOption {
 	}
 }
 
+// How long an idle worker waits before checking for pending transfers again
+func WithPollInterval(d time.Duration) TransferWorkerOption {
+	return func(p *TransferWorkerPool) {
+		p.pollInterval = d
+	}
+}
+
+// How long a claimed transfer stays with a worker, before it is delivered to another worker
+// Should be well above the time a transfer takes
+func WithLease(d time.Duration) TransferWorkerOption {
+	return func(p *TransferWorkerPool) {
+		p.lease = d
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) TransferWorkerOption {
+	return func(p *TransferWorkerPool) {
+		p.retryPolicy = policy
+	}
+}
+
+// Without a dead letter store, jobs that exhausted their retries are only marked as failed
+func WithDeadLetter(store DeadLetterStore) TransferWorkerOption {
+	return func(p *TransferWorkerPool) {
+		p.deadLetter = store
+	}
+}
+
 func (p *TransferWorkerPool) Start() {
 
 	_, lg := trace.Logger(context.Background())
 
 	for i := range p.numWorkers {
+		p.wg.Add(1)
 		go func(id int) {
-			for job := range p.jobChan {
-				err := p.model.TransferBalance(job.Ctx, job.SourceUserId, job.DestUserId, job.Amount)
+			defer p.wg.Done()
+
+			for {
+				select {
+				case <-p.stop:
+					return
+				default:
+				}
+
+				jobs, err := p.model.ClaimTransfers(context.Background(), 1, p.lease)
 				if err != nil {
-					// TODO:
-					// 1) Add retry mechanism in the future
-					// OR
-					// 2) Push into persistent storage to notify users that the transaction fails
-					lg.Info(fmt.Sprintf("[worker %d] transfer failed - FROM USER %d TO USER %d, AMOUNT %d ERR: %v", id, job.SourceUserId, job.DestUserId, job.Amount, err))
-				} else {
-					p.model.InvalidateWalletCache(job.Ctx, job.SourceUserId, job.DestUserId)
-					lg.Info(fmt.Sprintf("[worker %d] transfer success - FROM USER %d TO USER %d, AMOUNT %d", id, job.SourceUserId, job.DestUserId, job.Amount))
+					lg.Error(fmt.Sprintf("[worker %d] failed to claim transfers: %v", id, err))
+				}
+
+				if len(jobs) == 0 {
+					select {
+					case <-p.stop:
+						return
+					case <-time.After(p.pollInterval):
+					}
+					continue
+				}
+
+				for _, job := range jobs {
+					p.process(id, job)
 				}
 			}
 		}(i)
 	}
 }
+
+// Stops claiming new transfers and waits for in-flight transfers to finish
+// Unclaimed transfers stay pending in the outbox, picked up on the next start
+func (p *TransferWorkerPool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to stop transfer workers: %w", ctx.Err())
+	}
+}
+
+func (p *TransferWorkerPool) process(id int, job TransferJob) {
+
+	ctx, lg := trace.Logger(job.Ctx)
+
+	err := p.model.ExecuteTransfer(ctx, job)
+	if err == nil {
+		p.model.InvalidateWalletCache(ctx, job.SourceUserId, job.DestUserId)
+		lg.Info(fmt.Sprintf("[worker %d] transfer success - FROM USER %d TO USER %d, AMOUNT %s", id, job.SourceUserId, job.DestUserId, job.Amount))
+		return
+	}
+
+	lg.Info(fmt.Sprintf("[worker %d] transfer failed - FROM USER %d TO USER %d, AMOUNT %s, ATTEMPT %d ERR: %v", id, job.SourceUserId, job.DestUserId, job.Amount, job.Attempts, err))
+
+	// Another worker owns the job now, leave it to them
+	if errors.Is(err, ErrTransferClaimLost) {
+		return
+	}
+
+	transient := IsTransientError(err)
+
+	if transient && job.Attempts < p.retryPolicy.MaxAttempts {
+		delay := p.retryPolicy.Backoff(job.Attempts)
+		if err := p.model.RetryTransfer(ctx, job, delay, err); err != nil {
+			lg.Error(fmt.Sprintf("[worker %d] failed to retry transfer %d: %v", id, job.Id, err))
+		}
+		return
+	}
+
+	// Client errors such as insufficient balance will fail again, no point keeping them around
+	clientErr := &ClientError{}
+	if p.deadLetter != nil && !errors.As(err, &clientErr) {
+		if err := p.deadLetter.DeadLetterTransfer(ctx, job, err); err != nil {
+			lg.Error(fmt.Sprintf("[worker %d] failed to dead letter transfer %d: %v", id, job.Id, err))
+		}
+		return
+	}
+
+	// Persisted as failed with the reason, so users can see it on their transfer status
+	if err := p.model.FailTransfer(ctx, job, err); err != nil {
+		lg.Error(fmt.Sprintf("[worker %d] failed to ack failed transfer %d: %v", id, job.Id, err))
+	}
+}
@@ -2,63 +2,273 @@ package model
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
-	"gorm.io/gorm"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type FakeTransferModel struct {
-	db         *gorm.DB
-	Transfers  []TransferJob
-	CacheCalls []TransferJob
+	mu          sync.Mutex
+	Pending     []TransferJob
+	Transfers   []TransferJob
+	Failed      []TransferJob
+	Retried     []TransferJob
+	Delays      []time.Duration
+	DeadLetters []TransferJob
+	CacheCalls  []TransferJob
+	Err         error
 }
 
-func (f *FakeTransferModel) TransferBalance(ctx context.Context, source, dest uint64, amount int64) error {
-	f.Transfers = append(f.Transfers, TransferJob{ctx, source, dest, amount})
+func (f *FakeTransferModel) ClaimTransfers(ctx context.Context, limit int, lease time.Duration) ([]TransferJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := min(limit, len(f.Pending))
+	jobs := f.Pending[:n]
+	f.Pending = f.Pending[n:]
+	return jobs, nil
+}
+
+func (f *FakeTransferModel) ExecuteTransfer(ctx context.Context, job TransferJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Transfers = append(f.Transfers, job)
 	return nil
 }
 
-func (f *FakeTransferModel) InvalidateWalletCache(ctx context.Context, userIds ...uint64) {
-	f.CacheCalls = append(f.CacheCalls, TransferJob{ctx, userIds[0], userIds[1], 0})
+func (f *FakeTransferModel) FailTransfer(ctx context.Context, job TransferJob, cause error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Failed = append(f.Failed, job)
+	return nil
 }
 
-func TestTransferWorkerPool(t *testing.T) {
-	jobChan := make(chan TransferJob, 1)
+// Retried jobs go back to pending straight away, ignoring the delay
+func (f *FakeTransferModel) RetryTransfer(ctx context.Context, job TransferJob, delay time.Duration, cause error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Retried = append(f.Retried, job)
+	f.Delays = append(f.Delays, delay)
+	job.Attempts++
+	f.Pending = append(f.Pending, job)
+	return nil
+}
 
-	db, cleanup := setupTestDB(t)
-	defer cleanup()
+func (f *FakeTransferModel) DeadLetterTransfer(ctx context.Context, job TransferJob, cause error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
+	f.DeadLetters = append(f.DeadLetters, job)
+	return nil
+}
+
+func (f *FakeTransferModel) InvalidateWalletCache(ctx context.Context, userIds ...uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.CacheCalls = append(f.CacheCalls, TransferJob{Ctx: ctx, SourceUserId: userIds[0], DestUserId: userIds[1]})
+}
+
+func TestTransferWorkerPool(t *testing.T) {
 	fakeTransferModel := &FakeTransferModel{
-		db: db,
+		Pending: []TransferJob{
+			{
+				Ctx:          context.Background(),
+				Id:           1,
+				SourceUserId: 1,
+				DestUserId:   2,
+				Amount:       NewMoney(100, "USD"),
+			},
+		},
 	}
 
 	pool := NewTransferWorkerPool(
 		fakeTransferModel,
-		jobChan,
 		WithNumWorkers(1),
+		WithPollInterval(10*time.Millisecond),
 	)
 
 	pool.Start()
 
-	job := TransferJob{
-		Ctx:          context.Background(),
-		SourceUserId: 1,
-		DestUserId:   2,
-		Amount:       100,
-	}
-	jobChan <- job
-
 	time.Sleep(100 * time.Millisecond)
 
+	fakeTransferModel.mu.Lock()
+	defer fakeTransferModel.mu.Unlock()
+
 	if len(fakeTransferModel.Transfers) != 1 {
 		t.Fatalf("expected 1 transfer call, got %d", len(fakeTransferModel.Transfers))
 	}
-	if fakeTransferModel.Transfers[0].Amount != 100 {
-		t.Errorf("expected amount 100, got %d", fakeTransferModel.Transfers[0].Amount)
+	if fakeTransferModel.Transfers[0].Amount.Amount != 100 {
+		t.Errorf("expected amount 100, got %d", fakeTransferModel.Transfers[0].Amount.Amount)
 	}
 
 	if len(fakeTransferModel.CacheCalls) != 1 {
 		t.Fatalf("expected 1 cache invalidation, got %d", len(fakeTransferModel.CacheCalls))
 	}
 }
+
+func TestTransferWorkerPoolAcksFailedTransfer(t *testing.T) {
+	fakeTransferModel := &FakeTransferModel{
+		Pending: []TransferJob{
+			{Ctx: context.Background(), Id: 1, Attempts: 1, SourceUserId: 1, DestUserId: 2, Amount: NewMoney(100, "USD")},
+		},
+		Err: ErrBalanceInsufficient,
+	}
+
+	pool := NewTransferWorkerPool(
+		fakeTransferModel,
+		WithNumWorkers(1),
+		WithPollInterval(10*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}),
+		WithDeadLetter(fakeTransferModel),
+	)
+
+	pool.Start()
+
+	time.Sleep(100 * time.Millisecond)
+
+	fakeTransferModel.mu.Lock()
+	defer fakeTransferModel.mu.Unlock()
+
+	if len(fakeTransferModel.Failed) != 1 {
+		t.Fatalf("expected 1 failed ack, got %d", len(fakeTransferModel.Failed))
+	}
+	if len(fakeTransferModel.CacheCalls) != 0 {
+		t.Fatalf("expected no cache invalidation, got %d", len(fakeTransferModel.CacheCalls))
+	}
+
+	// Client errors are never retried nor dead lettered
+	if len(fakeTransferModel.Retried) != 0 {
+		t.Fatalf("expected no retry, got %d", len(fakeTransferModel.Retried))
+	}
+	if len(fakeTransferModel.DeadLetters) != 0 {
+		t.Fatalf("expected no dead letter, got %d", len(fakeTransferModel.DeadLetters))
+	}
+}
+
+func TestTransferWorkerPoolRetriesTransientError(t *testing.T) {
+	fakeTransferModel := &FakeTransferModel{
+		Pending: []TransferJob{
+			{Ctx: context.Background(), Id: 1, Attempts: 1, SourceUserId: 1, DestUserId: 2, Amount: NewMoney(100, "USD")},
+		},
+		Err: &pgconn.PgError{Code: "40P01"},
+	}
+
+	pool := NewTransferWorkerPool(
+		fakeTransferModel,
+		WithNumWorkers(1),
+		WithPollInterval(10*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second}),
+		WithDeadLetter(fakeTransferModel),
+	)
+
+	pool.Start()
+
+	time.Sleep(200 * time.Millisecond)
+
+	fakeTransferModel.mu.Lock()
+	defer fakeTransferModel.mu.Unlock()
+
+	if len(fakeTransferModel.Retried) != 2 {
+		t.Fatalf("expected 2 retries, got %d", len(fakeTransferModel.Retried))
+	}
+	if fakeTransferModel.Delays[0] != 10*time.Millisecond || fakeTransferModel.Delays[1] != 20*time.Millisecond {
+		t.Errorf("expected exponential backoff, got %v", fakeTransferModel.Delays)
+	}
+	if len(fakeTransferModel.DeadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(fakeTransferModel.DeadLetters))
+	}
+	if fakeTransferModel.DeadLetters[0].Attempts != 3 {
+		t.Errorf("expected dead letter after 3 attempts, got %d", fakeTransferModel.DeadLetters[0].Attempts)
+	}
+	if len(fakeTransferModel.Failed) != 0 {
+		t.Fatalf("expected no failed ack, got %d", len(fakeTransferModel.Failed))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: expected %v, got %v", tt.attempt, tt.want, got)
+		}
+	}
+}
+
+type SlowTransferModel struct {
+	FakeTransferModel
+	started chan struct{}
+}
+
+func (f *SlowTransferModel) ExecuteTransfer(ctx context.Context, job TransferJob) error {
+	close(f.started)
+	time.Sleep(100 * time.Millisecond)
+	return f.FakeTransferModel.ExecuteTransfer(ctx, job)
+}
+
+func TestTransferWorkerPoolStopWaitsForInFlightTransfer(t *testing.T) {
+	slowTransferModel := &SlowTransferModel{
+		FakeTransferModel: FakeTransferModel{
+			Pending: []TransferJob{
+				{Ctx: context.Background(), Id: 1, Attempts: 1, SourceUserId: 1, DestUserId: 2, Amount: NewMoney(100, "USD")},
+			},
+		},
+		started: make(chan struct{}),
+	}
+
+	pool := NewTransferWorkerPool(
+		slowTransferModel,
+		WithNumWorkers(2),
+		WithPollInterval(10*time.Millisecond),
+	)
+
+	pool.Start()
+
+	<-slowTransferModel.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := pool.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop pool: %v", err)
+	}
+
+	slowTransferModel.mu.Lock()
+	if len(slowTransferModel.Transfers) != 1 {
+		t.Fatalf("expected in-flight transfer to finish, got %d", len(slowTransferModel.Transfers))
+	}
+
+	// Nothing is claimed after stopping
+	slowTransferModel.Pending = append(slowTransferModel.Pending, TransferJob{Ctx: context.Background(), Id: 2, Attempts: 1})
+	slowTransferModel.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	slowTransferModel.mu.Lock()
+	defer slowTransferModel.mu.Unlock()
+
+	if len(slowTransferModel.Pending) != 1 {
+		t.Fatalf("expected transfer to stay pending after stop, got %d pending", len(slowTransferModel.Pending))
+	}
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ADMIN_CTX_SECONDS = 10
+)
+
+type AdminWalletResp struct {
+	WalletId     uint64      `json:"wallet_id"`
+	Currency     string      `json:"currency"`
+	Balance      model.Money `json:"balance"`
+	Status       string      `json:"status"`
+	StatusReason string      `json:"status_reason,omitempty"`
+}
+
+type AdminUserResp struct {
+	UserId    uint64            `json:"user_id"`
+	Name      string            `json:"name"`
+	Email     string            `json:"email"`
+	Role      string            `json:"role"`
+	Tier      string            `json:"tier"`
+	CreatedAt time.Time         `json:"created_at"`
+	Wallets   []AdminWalletResp `json:"wallets,omitempty"`
+}
+
+type SearchUsersResp struct {
+	Users    []AdminUserResp `json:"users"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+type CreateUserReq struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type SetUserPasswordReq struct {
+	Password string `json:"password"`
+}
+
+// Positive amounts credit the wallet, negative amounts debit it
+type AdjustBalanceReq struct {
+	Currency string      `json:"currency"`
+	Amount   model.Money `json:"amount"`
+	Reason   string      `json:"reason"`
+}
+
+type AdjustBalanceResp struct {
+	TransactionUUID string      `json:"transaction_uuid"`
+	WalletId        uint64      `json:"wallet_id"`
+	Currency        string      `json:"currency"`
+	Amount          model.Money `json:"amount"`
+	Balance         model.Money `json:"balance"`
+}
+
+type PostingResp struct {
+	WalletId             uint64      `json:"wallet_id"`
+	CounterpartyWalletId uint64      `json:"counterparty_wallet_id"`
+	Currency             string      `json:"currency"`
+	Amount               model.Money `json:"amount"`
+	Memo                 string      `json:"memo,omitempty"`
+}
+
+type JournalEntryResp struct {
+	TransactionUUID string        `json:"transaction_uuid"`
+	TransactionType string        `json:"transaction_type"`
+	ActorUserId     uint64        `json:"actor_user_id,omitempty"`
+	ReversalOf      string        `json:"reversal_of,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Postings        []PostingResp `json:"postings"`
+}
+
+type ReverseTransactionReq struct {
+	Reason string `json:"reason"`
+}
+
+type GetDeadLetterTransfersResp struct {
+	DeadLetters []model.DeadLetterTransfer `json:"dead_letters"`
+}
+
+type WalletStatusReq struct {
+	Reason string `json:"reason"`
+}
+
+type WalletStatusResp struct {
+	WalletId     uint64 `json:"wallet_id"`
+	UserId       uint64 `json:"user_id"`
+	Currency     string `json:"currency"`
+	Status       string `json:"status"`
+	StatusReason string `json:"status_reason"`
+}
+
+type WalletStatusEventResp struct {
+	FromStatus  string    `json:"from_status"`
+	ToStatus    string    `json:"to_status"`
+	Reason      string    `json:"reason"`
+	ActorUserId uint64    `json:"actor_user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type GetWalletStatusEventsResp struct {
+	Events []WalletStatusEventResp `json:"events"`
+}
+
+func (s *Server) freezeWallet(w http.ResponseWriter, r *http.Request) {
+	s.setWalletStatus(w, r, s.model.FreezeWallet)
+}
+
+func (s *Server) unfreezeWallet(w http.ResponseWriter, r *http.Request) {
+	s.setWalletStatus(w, r, s.model.UnfreezeWallet)
+}
+
+// The acting admin is the authenticated user, recorded with the reason in the wallet's status events
+func (s *Server) setWalletStatus(w http.ResponseWriter, r *http.Request, set func(ctx context.Context, walletId, actorUserId uint64, reason string) (model.Wallet, error)) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	actorUserId, err := utils.GetUserIdFromCtx(adminCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	walletId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	var req WalletStatusReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	wallet, err := set(adminCtx, walletId, actorUserId, req.Reason)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	s.model.InvalidateWalletCache(adminCtx, wallet.UserId)
+
+	respondJSON(w, r, WalletStatusResp{
+		WalletId:     wallet.Id,
+		UserId:       wallet.UserId,
+		Currency:     wallet.Currency,
+		Status:       wallet.Status.String(),
+		StatusReason: wallet.StatusReason,
+	})
+}
+
+func (s *Server) getWalletStatusEvents(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	walletId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	events, err := s.model.GetWalletStatusEvents(adminCtx, walletId)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	resp := GetWalletStatusEventsResp{
+		Events: make([]WalletStatusEventResp, len(events)),
+	}
+	for i, event := range events {
+		resp.Events[i] = WalletStatusEventResp{
+			FromStatus:  event.FromStatus.String(),
+			ToStatus:    event.ToStatus.String(),
+			Reason:      event.Reason,
+			ActorUserId: event.ActorUserId,
+			CreatedAt:   event.CreatedAt,
+		}
+	}
+
+	respondJSON(w, r, resp)
+}
+
+func (s *Server) searchUsers(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	q := r.URL.Query()
+	pageInfo := model.PageInfo{
+		Page:     utils.GetQueryInt(q, "page", 1),
+		PageSize: utils.GetQueryInt(q, "page_size", 30),
+	}
+
+	users, err := s.model.SearchUsers(adminCtx, q.Get("q"), pageInfo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	resp := SearchUsersResp{
+		Users:    make([]AdminUserResp, len(users)),
+		Page:     pageInfo.Page,
+		PageSize: pageInfo.PageSize,
+	}
+	for i, user := range users {
+		resp.Users[i] = adminUserResp(user)
+	}
+
+	respondJSON(w, r, resp)
+}
+
+// Wallet inspection, every wallet of the user with its balance and status
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	user, err := s.model.GetUser(adminCtx, userId)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, adminUserResp(user))
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	var req CreateUserReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	user, err := s.model.CreateUser(adminCtx, req.Name, req.Email, req.Password)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, adminUserResp(user))
+}
+
+// Users created before login existed have no password until an admin sets one
+func (s *Server) setUserPassword(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	var req SetUserPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	if err := s.model.SetUserPassword(adminCtx, userId, req.Password); err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminUserResp(user model.User) AdminUserResp {
+	resp := AdminUserResp{
+		UserId:    user.Id,
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      user.Role,
+		Tier:      user.Tier,
+		CreatedAt: user.CreatedAt,
+	}
+
+	for _, wallet := range user.Wallets {
+		resp.Wallets = append(resp.Wallets, AdminWalletResp{
+			WalletId:     wallet.Id,
+			Currency:     wallet.Currency,
+			Balance:      wallet.Balance,
+			Status:       wallet.Status.String(),
+			StatusReason: wallet.StatusReason,
+		})
+	}
+
+	return resp
+}
+
+func (s *Server) adjustBalance(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	actorUserId, err := utils.GetUserIdFromCtx(adminCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	walletId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	var req AdjustBalanceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	// Empty currency means the wallet's own
+	if req.Currency != "" {
+		currency, err := model.ParseCurrency(req.Currency)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+		req.Currency = currency.Code
+	}
+
+	entry, wallet, err := s.model.AdjustBalance(adminCtx, walletId, actorUserId, model.NewMoney(req.Amount.Amount, req.Currency), req.Reason)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(adminCtx, wallet.UserId)
+
+	respondJSON(w, r, AdjustBalanceResp{
+		TransactionUUID: entry.TransactionUUID,
+		WalletId:        wallet.Id,
+		Currency:        wallet.Currency,
+		Amount:          model.NewMoney(req.Amount.Amount, wallet.Currency),
+		Balance:         wallet.Balance,
+	})
+}
+
+func (s *Server) getJournalEntry(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	entry, postings, err := s.model.GetJournalEntry(adminCtx, r.PathValue("uuid"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, journalEntryResp(entry, postings))
+}
+
+func journalEntryResp(entry model.JournalEntry, postings []model.Transaction) JournalEntryResp {
+	resp := JournalEntryResp{
+		TransactionUUID: entry.TransactionUUID,
+		TransactionType: entry.Type.String(),
+		ActorUserId:     entry.ActorUserId,
+		ReversalOf:      entry.ReversalOf,
+		CreatedAt:       entry.CreatedAt,
+		Postings:        make([]PostingResp, len(postings)),
+	}
+	for i, posting := range postings {
+		resp.Postings[i] = PostingResp{
+			WalletId:             posting.DestWalletId,
+			CounterpartyWalletId: posting.SourceWalletId,
+			Currency:             posting.Currency,
+			Amount:               posting.Amount,
+			Memo:                 posting.Memo,
+		}
+	}
+
+	return resp
+}
+
+// Responds with the reversal and its postings
+func (s *Server) reverseTransaction(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	actorUserId, err := utils.GetUserIdFromCtx(adminCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req ReverseTransactionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	reversal, wallets, err := s.model.ReverseTransaction(adminCtx, r.PathValue("uuid"), actorUserId, req.Reason)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	for _, wallet := range wallets {
+		s.model.InvalidateWalletCache(adminCtx, wallet.UserId)
+	}
+
+	reversal, postings, err := s.model.GetJournalEntry(adminCtx, reversal.TransactionUUID)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, journalEntryResp(reversal, postings))
+}
+
+func (s *Server) getDeadLetterTransfers(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	q := r.URL.Query()
+	deadLetters, err := s.model.GetDeadLetterTransfers(adminCtx, model.PageInfo{
+		Page:     utils.GetQueryInt(q, "page", 1),
+		PageSize: utils.GetQueryInt(q, "page_size", 30),
+	})
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, GetDeadLetterTransfersResp{DeadLetters: deadLetters})
+}
+
+func (s *Server) replayDeadLetterTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	adminCtx, cancel := context.WithTimeout(ctx, ADMIN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	deadLetterId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	if err := s.model.ReplayDeadLetterTransfer(adminCtx, deadLetterId); err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
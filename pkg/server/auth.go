@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ISSUE_TOKEN_CTX_SECONDS = 10
+	ACCESS_TOKEN_TTL        = 1 * time.Hour
+)
+
+type IssueTokenReq struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type IssueTokenResp struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	issueTokenCtx, cancel := context.WithTimeout(ctx, ISSUE_TOKEN_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	var req IssueTokenReq
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	if req.Email == "" || req.Password == "" {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	user, err := s.model.AuthenticateUser(issueTokenCtx, req.Email, req.Password)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	accessToken, claims, err := s.keyring.Issue(strconv.FormatUint(user.Id, 10), ACCESS_TOKEN_TTL, user.Role)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, IssueTokenResp{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresAt:   time.Unix(claims.ExpiresAt, 0).UTC(),
+	})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	CREATE_FX_QUOTE_CTX_SECONDS = 10
+	TRANSFER_FX_CTX_SECONDS     = 15
+)
+
+type CreateFxQuoteReq struct {
+	SourceCurrency string      `json:"source_currency"`
+	DestCurrency   string      `json:"dest_currency"`
+	Amount         model.Money `json:"amount"`
+}
+
+type FxQuoteResp struct {
+	QuoteId        string      `json:"quote_id"`
+	SourceCurrency string      `json:"source_currency"`
+	DestCurrency   string      `json:"dest_currency"`
+	Rate           string      `json:"rate"`
+	SpreadBps      int64       `json:"spread_bps"`
+	SourceAmount   model.Money `json:"source_amount"`
+	SpreadAmount   model.Money `json:"spread_amount"`
+	DestAmount     model.Money `json:"dest_amount"`
+	ExpiresAt      time.Time   `json:"expires_at"`
+}
+
+type TransferFxReq struct {
+	DestinationUserId uint64 `json:"destination_user_id"`
+	QuoteId           string `json:"quote_id"`
+}
+
+type TransferFxResp struct {
+	Success         bool        `json:"success"`
+	TransactionUUID string      `json:"transaction_uuid"`
+	SourceCurrency  string      `json:"source_currency"`
+	SourceAmount    model.Money `json:"source_amount"`
+	DestCurrency    string      `json:"dest_currency"`
+	DestAmount      model.Money `json:"dest_amount"`
+}
+
+func (s *Server) createFxQuote(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	createFxQuoteCtx, cancel := context.WithTimeout(ctx, CREATE_FX_QUOTE_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(createFxQuoteCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req CreateFxQuoteReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	sourceAmount := model.NewMoney(req.Amount.Amount, req.SourceCurrency)
+
+	quote, err := s.model.CreateFxQuote(createFxQuoteCtx, userId, sourceAmount, req.DestCurrency)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, FxQuoteResp{
+		QuoteId:        quote.QuoteUUID,
+		SourceCurrency: quote.SourceCurrency,
+		DestCurrency:   quote.DestCurrency,
+		Rate:           quote.Rate,
+		SpreadBps:      quote.SpreadBps,
+		SourceAmount:   quote.SourceAmount,
+		SpreadAmount:   quote.SpreadAmount,
+		DestAmount:     quote.DestAmount,
+		ExpiresAt:      quote.ExpiresAt,
+	})
+}
+
+func (s *Server) transferFx(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	transferFxCtx, cancel := context.WithTimeout(ctx, TRANSFER_FX_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(transferFxCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req TransferFxReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.QuoteId == "" {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	// Self transfer prohibited
+	if userId == req.DestinationUserId {
+		respondErr(w, r, model.ErrSelfTransferInvalid)
+		return
+	}
+
+	quote, err := s.model.TransferFx(transferFxCtx, userId, req.DestinationUserId, req.QuoteId)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(transferFxCtx, userId, req.DestinationUserId)
+
+	respondJSON(w, r, TransferFxResp{
+		Success:         true,
+		TransactionUUID: quote.TransactionUUID,
+		SourceCurrency:  quote.SourceCurrency,
+		SourceAmount:    quote.SourceAmount,
+		DestCurrency:    quote.DestCurrency,
+		DestAmount:      quote.DestAmount,
+	})
+}
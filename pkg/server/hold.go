@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	HOLD_CTX_SECONDS = 10
+)
+
+type PlaceHoldReq struct {
+	MerchantUserId uint64      `json:"merchant_user_id"`
+	Currency       string      `json:"currency"`
+	Amount         model.Money `json:"amount"`
+	Memo           string      `json:"memo"`
+
+	// Seconds until the hold expires and is released, defaults to model.HOLD_DEFAULT_TTL
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type CaptureHoldReq struct {
+	// Defaults to the whole hold, anything less releases the rest
+	Amount model.Money `json:"amount"`
+}
+
+type HoldResp struct {
+	HoldId          string      `json:"hold_id"`
+	UserId          uint64      `json:"user_id"`
+	MerchantUserId  uint64      `json:"merchant_user_id"`
+	Currency        string      `json:"currency"`
+	Amount          model.Money `json:"amount"`
+	CapturedAmount  model.Money `json:"captured_amount"`
+	Memo            string      `json:"memo,omitempty"`
+	Status          string      `json:"status"`
+	TransactionUUID string      `json:"transaction_uuid,omitempty"`
+	ExpiresAt       time.Time   `json:"expires_at"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+func (s *Server) placeHold(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	holdCtx, cancel := context.WithTimeout(ctx, HOLD_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(holdCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req PlaceHoldReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ExpiresIn < 0 {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	ttl := model.HOLD_DEFAULT_TTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	amount := model.NewMoney(req.Amount.Amount, req.Currency)
+
+	hold, err := s.model.PlaceHold(holdCtx, userId, req.MerchantUserId, amount, time.Now().Add(ttl), req.Memo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(holdCtx, userId)
+
+	respondJSON(w, r, holdResp(hold))
+}
+
+func (s *Server) getHold(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	holdCtx, cancel := context.WithTimeout(ctx, HOLD_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(holdCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	hold, err := s.model.GetHold(holdCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, holdResp(hold))
+}
+
+func (s *Server) captureHold(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	holdCtx, cancel := context.WithTimeout(ctx, HOLD_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(holdCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	// No body captures the whole hold
+	var req CaptureHoldReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	hold, err := s.model.CaptureHold(holdCtx, userId, r.PathValue("id"), req.Amount)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(holdCtx, hold.UserId, hold.MerchantUserId)
+
+	respondJSON(w, r, holdResp(hold))
+}
+
+func (s *Server) releaseHold(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	holdCtx, cancel := context.WithTimeout(ctx, HOLD_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(holdCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	hold, err := s.model.ReleaseHold(holdCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(holdCtx, hold.UserId)
+
+	respondJSON(w, r, holdResp(hold))
+}
+
+func holdResp(hold model.Hold) HoldResp {
+	return HoldResp{
+		HoldId:          hold.HoldUUID,
+		UserId:          hold.UserId,
+		MerchantUserId:  hold.MerchantUserId,
+		Currency:        hold.Currency,
+		Amount:          hold.Amount,
+		CapturedAmount:  hold.CapturedAmount,
+		Memo:            hold.Memo,
+		Status:          hold.Status.String(),
+		TransactionUUID: hold.TransactionUUID,
+		ExpiresAt:       hold.ExpiresAt,
+		CreatedAt:       hold.CreatedAt,
+		UpdatedAt:       hold.UpdatedAt,
+	}
+}
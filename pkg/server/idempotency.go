@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"js-centralized-wallet/pkg/utils/problem"
+	"net/http"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER      = "Idempotency-Key"
+	IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed"
+	IDEMPOTENCY_KEY_MAX_LENGTH  = 255
+)
+
+// Captures the response so it can be stored against the idempotency key
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+type idempotencyCtxKey struct{}
+
+// Whether the wrapped handler got as far as committing its write
+type idempotencyState struct {
+	committed bool
+}
+
+// Handlers behind idempotent call it once their model call succeeded
+// From then on the key is kept whatever the response, a retry must not move money again
+func markCommitted(r *http.Request) {
+	if state, ok := r.Context().Value(idempotencyCtxKey{}).(*idempotencyState); ok {
+		state.committed = true
+	}
+}
+
+// Requests with an Idempotency-Key header are processed once per user and key
+// A retry gets the stored response back, the same key with a different request is rejected
+// Must be wrapped by AuthMiddleware, keys are scoped per user
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		ctx, lg := trace.Logger(r.Context())
+
+		if len(key) > IDEMPOTENCY_KEY_MAX_LENGTH {
+			respondErr(w, r, model.ErrBadInput)
+			return
+		}
+
+		userId, err := utils.GetUserIdFromCtx(ctx)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondErr(w, r, model.ErrBadInput)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		record, err := s.model.ReserveIdempotencyKey(ctx, userId, key, requestHash(r, body))
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+
+		if record.StatusCode != 0 {
+			lg.Info("replaying idempotent response", "key", key)
+
+			contentType := "application/json"
+			if record.StatusCode >= http.StatusBadRequest {
+				contentType = problem.CONTENT_TYPE
+			}
+
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set(IDEMPOTENCY_REPLAYED_HEADER, "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Response)
+			return
+		}
+
+		// Request context might be cancelled by the time the key is settled
+		settleCtx := context.WithoutCancel(ctx)
+
+		state := &idempotencyState{}
+		ctx = context.WithValue(ctx, idempotencyCtxKey{}, state)
+
+		// Nothing committed, the client can retry with the same key
+		release := func() {
+			if err := s.model.ReleaseIdempotencyKey(settleCtx, record); err != nil {
+				lg.Error("failed to release idempotency key", "key", key, "err", err)
+			}
+		}
+
+		// A panic after the commit leaves the key in progress, retries are rejected rather than run twice
+		defer func() {
+			if p := recover(); p != nil {
+				if !state.committed {
+					release()
+				}
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, r.WithContext(ctx))
+
+		if recorder.statusCode >= http.StatusInternalServerError && !state.committed {
+			release()
+			return
+		}
+
+		if err := s.model.CompleteIdempotencyKey(settleCtx, record, recorder.statusCode, recorder.body.Bytes()); err != nil {
+			lg.Error("failed to complete idempotency key", "key", key, "err", err)
+		}
+	}
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	PAYMENT_REQUEST_CTX_SECONDS = 10
+)
+
+type CreatePaymentRequestReq struct {
+	PayerUserId uint64      `json:"payer_user_id"`
+	Currency    string      `json:"currency"`
+	Amount      model.Money `json:"amount"`
+	Memo        string      `json:"memo"`
+
+	// Seconds until the request expires, defaults to model.PAYMENT_REQUEST_DEFAULT_TTL
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type PaymentRequestResp struct {
+	RequestId       string      `json:"request_id"`
+	RequesterUserId uint64      `json:"requester_user_id"`
+	PayerUserId     uint64      `json:"payer_user_id"`
+	Currency        string      `json:"currency"`
+	Amount          model.Money `json:"amount"`
+	Memo            string      `json:"memo,omitempty"`
+	Status          string      `json:"status"`
+	TransactionUUID string      `json:"transaction_uuid,omitempty"`
+	ExpiresAt       time.Time   `json:"expires_at"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+type GetPaymentRequestsResp struct {
+	PaymentRequests []PaymentRequestResp `json:"payment_requests"`
+}
+
+func (s *Server) createPaymentRequest(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	paymentRequestCtx, cancel := context.WithTimeout(ctx, PAYMENT_REQUEST_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(paymentRequestCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req CreatePaymentRequestReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ExpiresIn < 0 {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	ttl := model.PAYMENT_REQUEST_DEFAULT_TTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	amount := model.NewMoney(req.Amount.Amount, req.Currency)
+
+	request, err := s.model.CreatePaymentRequest(paymentRequestCtx, userId, req.PayerUserId, amount, time.Now().Add(ttl), req.Memo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	respondJSON(w, r, paymentRequestResp(request))
+}
+
+// role is incoming (to pay) or outgoing (to be paid), both by default
+func (s *Server) getPaymentRequests(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	paymentRequestCtx, cancel := context.WithTimeout(ctx, PAYMENT_REQUEST_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(paymentRequestCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+
+	role, err := model.ParsePaymentRequestRole(q.Get("role"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var status model.PaymentRequestStatus
+	if value := q.Get("status"); value != "" {
+		status, err = model.ParsePaymentRequestStatus(value)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+	}
+
+	pageInfo := model.PageInfo{
+		Page:     utils.GetQueryInt(q, "page", 1),
+		PageSize: utils.GetQueryInt(q, "page_size", 30),
+	}
+
+	requests, err := s.model.GetPaymentRequests(paymentRequestCtx, userId, role, status, pageInfo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	resp := GetPaymentRequestsResp{
+		PaymentRequests: make([]PaymentRequestResp, len(requests)),
+	}
+	for i := range requests {
+		resp.PaymentRequests[i] = paymentRequestResp(requests[i])
+	}
+
+	respondJSON(w, r, resp)
+}
+
+func (s *Server) getPaymentRequest(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	paymentRequestCtx, cancel := context.WithTimeout(ctx, PAYMENT_REQUEST_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(paymentRequestCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	request, err := s.model.GetPaymentRequest(paymentRequestCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, paymentRequestResp(request))
+}
+
+func (s *Server) acceptPaymentRequest(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	paymentRequestCtx, cancel := context.WithTimeout(ctx, TRANSFER_BALANCE_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(paymentRequestCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	request, err := s.model.AcceptPaymentRequest(paymentRequestCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	s.model.InvalidateWalletCache(paymentRequestCtx, request.PayerUserId, request.RequesterUserId)
+
+	respondJSON(w, r, paymentRequestResp(request))
+}
+
+func (s *Server) declinePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	s.closePaymentRequest(w, r, s.model.DeclinePaymentRequest)
+}
+
+func (s *Server) cancelPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	s.closePaymentRequest(w, r, s.model.CancelPaymentRequest)
+}
+
+func (s *Server) closePaymentRequest(w http.ResponseWriter, r *http.Request, closeRequest func(ctx context.Context, userId uint64, requestUUID string) (model.PaymentRequest, error)) {
+
+	ctx, _ := trace.Logger(r.Context())
+	paymentRequestCtx, cancel := context.WithTimeout(ctx, PAYMENT_REQUEST_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(paymentRequestCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	request, err := closeRequest(paymentRequestCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, paymentRequestResp(request))
+}
+
+func paymentRequestResp(request model.PaymentRequest) PaymentRequestResp {
+	return PaymentRequestResp{
+		RequestId:       request.RequestUUID,
+		RequesterUserId: request.RequesterUserId,
+		PayerUserId:     request.PayerUserId,
+		Currency:        request.Currency,
+		Amount:          request.Amount,
+		Memo:            request.Memo,
+		Status:          request.Status.String(),
+		TransactionUUID: request.TransactionUUID,
+		ExpiresAt:       request.ExpiresAt,
+		CreatedAt:       request.CreatedAt,
+		UpdatedAt:       request.UpdatedAt,
+	}
+}
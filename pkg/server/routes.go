@@ -1,6 +1,7 @@
 package server
 
 import (
+	"js-centralized-wallet/pkg/model"
 	"js-centralized-wallet/pkg/utils/middlewares"
 	"net/http"
 )
@@ -9,21 +10,70 @@ func (s *Server) apiRoutes(next http.HandlerFunc) http.HandlerFunc {
 	r := middlewares.Router(next)
 	r.HandleFunc("GET /api/ping/v1", middlewares.ThrottleMiddleware(s.model.GetRedis(), s.ping))
 
-	{ // Test get all users
-		r.HandleFunc("GET /api/users/v1", s.getAllUsers)
-	}
+	r.HandleFunc("POST /api/auth/token/v1", middlewares.ThrottleMiddleware(s.model.GetRedis(), s.issueToken))
 
 	{
-		r.HandleFunc("GET /api/wallet/balance/v1", middlewares.AuthMiddleware(s.getWalletBalance))
-		r.HandleFunc("GET /api/transactions/v1", middlewares.AuthMiddleware(s.getTransactionHistory))
-		r.HandleFunc("POST /api/deposit/v1", middlewares.AuthMiddleware(s.deposit))
-		r.HandleFunc("POST /api/withdraw/v1", middlewares.AuthMiddleware(s.withdraw))
+		r.HandleFunc("GET /api/wallet/balance/v1", s.auth(s.getWalletBalance))
+		r.HandleFunc("GET /api/transactions/v1", s.auth(s.getTransactionHistory))
+		r.HandleFunc("GET /api/statements/v1", s.auth(s.getStatement))
+		r.HandleFunc("POST /api/deposit/v1", s.auth(s.idempotent(s.deposit)))
+		r.HandleFunc("POST /api/withdraw/v1", s.auth(s.idempotent(s.withdraw)))
 
-		r.HandleFunc("POST /api/transfer/v1", middlewares.ThrottleMiddleware(s.model.GetRedis(), middlewares.AuthMiddleware(s.transferBalance)))
+		r.HandleFunc("POST /api/transfer/v1", middlewares.ThrottleMiddleware(s.model.GetRedis(), s.auth(s.idempotent(s.transferBalance))))
 
 		// No throttle for testing
-		r.HandleFunc("POST /api/transfer/v2", middlewares.AuthMiddleware(s.transferBalanceV2))
+		r.HandleFunc("POST /api/transfer/v2", s.auth(s.idempotent(s.transferBalanceV2)))
+		r.HandleFunc("GET /api/transfers/{id}/v1", s.auth(s.getTransfer))
+
+		r.HandleFunc("POST /api/fx/quotes/v1", s.auth(s.createFxQuote))
+		r.HandleFunc("POST /api/transfer/fx/v1", middlewares.ThrottleMiddleware(s.model.GetRedis(), s.auth(s.idempotent(s.transferFx))))
+
+		r.HandleFunc("POST /api/holds/v1", s.auth(s.idempotent(s.placeHold)))
+		r.HandleFunc("GET /api/holds/{id}/v1", s.auth(s.getHold))
+		r.HandleFunc("POST /api/holds/{id}/capture/v1", s.auth(s.idempotent(s.captureHold)))
+		r.HandleFunc("POST /api/holds/{id}/release/v1", s.auth(s.idempotent(s.releaseHold)))
+
+		r.HandleFunc("POST /api/scheduled-transfers/v1", s.auth(s.idempotent(s.createScheduledTransfer)))
+		r.HandleFunc("GET /api/scheduled-transfers/v1", s.auth(s.getScheduledTransfers))
+		r.HandleFunc("GET /api/scheduled-transfers/{id}/v1", s.auth(s.getScheduledTransfer))
+		r.HandleFunc("PUT /api/scheduled-transfers/{id}/v1", s.auth(s.updateScheduledTransfer))
+		r.HandleFunc("DELETE /api/scheduled-transfers/{id}/v1", s.auth(s.cancelScheduledTransfer))
+		r.HandleFunc("GET /api/scheduled-transfers/{id}/runs/v1", s.auth(s.getScheduledTransferRuns))
+
+		r.HandleFunc("POST /api/payment-requests/v1", s.auth(s.idempotent(s.createPaymentRequest)))
+		r.HandleFunc("GET /api/payment-requests/v1", s.auth(s.getPaymentRequests))
+		r.HandleFunc("GET /api/payment-requests/{id}/v1", s.auth(s.getPaymentRequest))
+		r.HandleFunc("POST /api/payment-requests/{id}/accept/v1", s.auth(s.idempotent(s.acceptPaymentRequest)))
+		r.HandleFunc("POST /api/payment-requests/{id}/decline/v1", s.auth(s.declinePaymentRequest))
+		r.HandleFunc("POST /api/payment-requests/{id}/cancel/v1", s.auth(s.cancelPaymentRequest))
+	}
+
+	{ // Admin only
+		r.HandleFunc("GET /api/admin/users/v1", s.admin(s.searchUsers))
+		r.HandleFunc("POST /api/admin/users/v1", s.admin(s.createUser))
+		r.HandleFunc("GET /api/admin/users/{id}/v1", s.admin(s.getUser))
+		r.HandleFunc("PUT /api/admin/users/{id}/password/v1", s.admin(s.setUserPassword))
+
+		r.HandleFunc("POST /api/admin/wallets/{id}/adjustments/v1", s.admin(s.idempotent(s.adjustBalance)))
+		r.HandleFunc("POST /api/admin/wallets/{id}/freeze/v1", s.admin(s.freezeWallet))
+		r.HandleFunc("POST /api/admin/wallets/{id}/unfreeze/v1", s.admin(s.unfreezeWallet))
+		r.HandleFunc("GET /api/admin/wallets/{id}/events/v1", s.admin(s.getWalletStatusEvents))
+
+		r.HandleFunc("GET /api/admin/transactions/{uuid}/v1", s.admin(s.getJournalEntry))
+		r.HandleFunc("POST /api/admin/transactions/{uuid}/reversal/v1", s.admin(s.idempotent(s.reverseTransaction)))
+
+		r.HandleFunc("GET /api/admin/dead-letters/v1", s.admin(s.getDeadLetterTransfers))
+		r.HandleFunc("POST /api/admin/dead-letters/{id}/replay/v1", s.admin(s.replayDeadLetterTransfer))
 	}
 
 	return r.ServeHTTP
 }
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return middlewares.AuthMiddleware(s.keyring, next)
+}
+
+// Authenticated users who currently hold USER_ROLE_ADMIN, whatever their token says
+func (s *Server) admin(next http.HandlerFunc) http.HandlerFunc {
+	return s.auth(middlewares.RoleMiddleware(model.USER_ROLE_ADMIN, s.model.GetUserRoles, next))
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"time"
+)
+
+const (
+	SCHEDULED_TRANSFER_CTX_SECONDS = 10
+)
+
+type ScheduledTransferReq struct {
+	DestinationUserId uint64      `json:"destination_user_id"`
+	Currency          string      `json:"currency"`
+	Amount            model.Money `json:"amount"`
+	Memo              string      `json:"memo"`
+
+	// Exactly one of them, e.g. "0 9 1 * *" for 09:00 UTC on the 1st of every month
+	Cron            string `json:"cron"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+
+	StartAt time.Time  `json:"start_at"`
+	EndsAt  *time.Time `json:"ends_at"`
+	MaxRuns int        `json:"max_runs"`
+}
+
+type ScheduledTransferResp struct {
+	ScheduleId        string      `json:"schedule_id"`
+	DestinationUserId uint64      `json:"destination_user_id"`
+	Currency          string      `json:"currency"`
+	Amount            model.Money `json:"amount"`
+	Memo              string      `json:"memo,omitempty"`
+	Cron              string      `json:"cron,omitempty"`
+	IntervalSeconds   int64       `json:"interval_seconds,omitempty"`
+	Status            string      `json:"status"`
+	NextRunAt         *time.Time  `json:"next_run_at"`
+	LastRunAt         *time.Time  `json:"last_run_at"`
+	EndsAt            *time.Time  `json:"ends_at,omitempty"`
+	MaxRuns           int         `json:"max_runs,omitempty"`
+	RunCount          int         `json:"run_count"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+}
+
+type GetScheduledTransfersResp struct {
+	ScheduledTransfers []ScheduledTransferResp `json:"scheduled_transfers"`
+}
+
+type ScheduledTransferRunResp struct {
+	ScheduledFor  time.Time `json:"scheduled_for"`
+	TransferId    string    `json:"transfer_id"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type GetScheduledTransferRunsResp struct {
+	Runs []ScheduledTransferRunResp `json:"runs"`
+}
+
+func (s *Server) createScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req ScheduledTransferReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	scheduled, err := s.model.CreateScheduledTransfer(scheduledCtx, userId, req.spec())
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	markCommitted(r)
+
+	respondJSON(w, r, scheduledTransferResp(scheduled))
+}
+
+func (s *Server) getScheduledTransfers(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+	pageInfo := model.PageInfo{
+		Page:     utils.GetQueryInt(q, "page", 1),
+		PageSize: utils.GetQueryInt(q, "page_size", 30),
+	}
+
+	scheduled, err := s.model.GetScheduledTransfers(scheduledCtx, userId, pageInfo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	resp := GetScheduledTransfersResp{
+		ScheduledTransfers: make([]ScheduledTransferResp, len(scheduled)),
+	}
+	for i := range scheduled {
+		resp.ScheduledTransfers[i] = scheduledTransferResp(scheduled[i])
+	}
+
+	respondJSON(w, r, resp)
+}
+
+func (s *Server) getScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	scheduled, err := s.model.GetScheduledTransfer(scheduledCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, scheduledTransferResp(scheduled))
+}
+
+func (s *Server) updateScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	var req ScheduledTransferReq
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	scheduled, err := s.model.UpdateScheduledTransfer(scheduledCtx, userId, r.PathValue("id"), req.spec())
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, scheduledTransferResp(scheduled))
+}
+
+func (s *Server) cancelScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	scheduled, err := s.model.CancelScheduledTransfer(scheduledCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	respondJSON(w, r, scheduledTransferResp(scheduled))
+}
+
+func (s *Server) getScheduledTransferRuns(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	scheduledCtx, cancel := context.WithTimeout(ctx, SCHEDULED_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(scheduledCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+	pageInfo := model.PageInfo{
+		Page:     utils.GetQueryInt(q, "page", 1),
+		PageSize: utils.GetQueryInt(q, "page_size", 30),
+	}
+
+	runs, err := s.model.GetScheduledTransferRuns(scheduledCtx, userId, r.PathValue("id"), pageInfo)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	resp := GetScheduledTransferRunsResp{
+		Runs: make([]ScheduledTransferRunResp, len(runs)),
+	}
+	for i, run := range runs {
+		resp.Runs[i] = ScheduledTransferRunResp{
+			ScheduledFor:  run.ScheduledFor,
+			TransferId:    run.TransferUUID,
+			Status:        run.Status.String(),
+			FailureReason: run.FailureReason,
+			CreatedAt:     run.CreatedAt,
+		}
+	}
+
+	respondJSON(w, r, resp)
+}
+
+func (req ScheduledTransferReq) spec() model.ScheduledTransferSpec {
+	return model.ScheduledTransferSpec{
+		DestUserId: req.DestinationUserId,
+		Amount:     model.NewMoney(req.Amount.Amount, req.Currency),
+		Memo:       req.Memo,
+		CronExpr:   req.Cron,
+		Interval:   time.Duration(req.IntervalSeconds) * time.Second,
+		StartAt:    req.StartAt,
+		EndsAt:     req.EndsAt,
+		MaxRuns:    req.MaxRuns,
+	}
+}
+
+func scheduledTransferResp(scheduled model.ScheduledTransfer) ScheduledTransferResp {
+	return ScheduledTransferResp{
+		ScheduleId:        scheduled.ScheduleUUID,
+		DestinationUserId: scheduled.DestUserId,
+		Currency:          scheduled.Currency,
+		Amount:            scheduled.Amount,
+		Memo:              scheduled.Memo,
+		Cron:              scheduled.CronExpr,
+		IntervalSeconds:   scheduled.IntervalSeconds,
+		Status:            scheduled.Status.String(),
+		NextRunAt:         scheduled.NextRunAt,
+		LastRunAt:         scheduled.LastRunAt,
+		EndsAt:            scheduled.EndsAt,
+		MaxRuns:           scheduled.MaxRuns,
+		RunCount:          scheduled.RunCount,
+		CreatedAt:         scheduled.CreatedAt,
+		UpdatedAt:         scheduled.UpdatedAt,
+	}
+}
@@ -12,15 +12,97 @@ import (
 func (s *Server) StartScheduler() error {
 	c := cron.New()
 
-	// Run at every minute
+	// Run at midnight everyday, each wallet is bounded on its own so the sweep gets the hour
 	_, err := c.AddFunc("0 0 * * *", func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
 		defer cancel()
 
-		err := s.model.SyncWalletSnapshots(ctx)
+		discrepancies, err := s.model.SyncWalletSnapshots(ctx)
 		if err != nil {
 			slog.Error(fmt.Sprintf("failed to take wallet snapshot: %v", err))
 		}
+
+		for _, d := range discrepancies {
+			slog.Error(fmt.Sprintf("wallet %d balance drifted by %d from ledger, balance = %d, ledger = %d", d.WalletId, d.Drift, d.WalletBalance, d.LedgerAmount), "discrepancy_id", d.Id)
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
+	}
+
+	// Idempotency keys are only honoured for a day, purge them every hour
+	_, err = c.AddFunc("0 * * * *", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		purged, err := s.model.PurgeIdempotencyKeys(ctx, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to purge idempotency keys: %v", err))
+			return
+		}
+
+		slog.Info(fmt.Sprintf("purged %d idempotency keys", purged))
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
+	}
+
+	// Holds past their expiry go back to the payer's available balance, release them every minute
+	_, err = c.AddFunc("* * * * *", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		userIds, err := s.model.ExpireHolds(ctx, time.Now())
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to expire holds: %v", err))
+			return
+		}
+
+		// Cached balances still show the held funds as unavailable
+		if len(userIds) > 0 {
+			s.model.InvalidateWalletCache(ctx, userIds...)
+			slog.Info(fmt.Sprintf("expired holds of %d users", len(userIds)))
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
+	}
+
+	// Scheduled transfers are enqueued for TransferWorkerPool, runs missed while we were down are each caught up on
+	_, err = c.AddFunc("* * * * *", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		dispatched, err := s.model.DispatchScheduledTransfers(ctx, time.Now())
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to dispatch scheduled transfers: %v", err))
+			return
+		}
+
+		if dispatched > 0 {
+			slog.Info(fmt.Sprintf("dispatched %d scheduled transfers", dispatched))
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to add cron job: %w", err)
+	}
+
+	// Pending payment requests past their expiry reserve nothing, marking them expired every hour is enough
+	_, err = c.AddFunc("0 * * * *", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		expired, err := s.model.ExpirePaymentRequests(ctx, time.Now())
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to expire payment requests: %v", err))
+			return
+		}
+
+		slog.Info(fmt.Sprintf("expired %d payment requests", expired))
 	})
 
 	if err != nil {
@@ -28,6 +110,7 @@ func (s *Server) StartScheduler() error {
 	}
 
 	c.Start()
+	s.cron = c
 
 	return nil
 }
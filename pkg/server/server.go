@@ -1,49 +1,101 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"js-centralized-wallet/pkg/model"
 	"js-centralized-wallet/pkg/trace"
 	"js-centralized-wallet/pkg/utils/middlewares"
+	"js-centralized-wallet/pkg/utils/problem"
+	"js-centralized-wallet/pkg/utils/token"
 	"net"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
 type Server struct {
-	model   *model.Model
-	jobChan chan model.TransferJob
+	model        *model.Model
+	keyring      *token.Keyring
+	transferPool *model.TransferWorkerPool
+	httpServer   *http.Server
+	cron         *cron.Cron
 }
 
-func NewServer(m *model.Model) *Server {
-	jobChan := make(chan model.TransferJob, 100)
-
-	transferPool := model.NewTransferWorkerPool(m, jobChan,
+func NewServer(m *model.Model, keyring *token.Keyring) *Server {
+	transferPool := model.NewTransferWorkerPool(m,
 		model.WithNumWorkers(10),
+		model.WithRetryPolicy(model.RetryPolicy{
+			MaxAttempts: 5,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Minute,
+		}),
+		model.WithDeadLetter(m),
 	)
 
 	transferPool.Start()
 
 	return &Server{
-		model:   m,
-		jobChan: jobChan,
+		model:        m,
+		keyring:      keyring,
+		transferPool: transferPool,
+		httpServer:   &http.Server{},
 	}
 }
 
+// Blocks until the server is shut down
 func (s *Server) Run() error {
 	l, err := net.Listen("tcp", os.Getenv("LISTEN_ADDR"))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	return http.Serve(l, middlewares.ComposeMiddlewares(
+	s.httpServer.Handler = middlewares.ComposeMiddlewares(
 		middlewares.AccessLog,
 		middlewares.AllowAllOrigins,
 		middlewares.GzipMiddleware,
 		s.apiRoutes,
-	)(http.NewServeMux().ServeHTTP))
+	)(http.NewServeMux().ServeHTTP)
+
+	err = s.httpServer.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Stops accepting requests, waits for in-flight requests and transfers, then stops the cron scheduler
+// Everything has to finish before ctx is done, otherwise whatever is left is abandoned
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	// Waits for in-flight handlers, including synchronous v1 transfers
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown http server: %w", err))
+	}
+
+	if err := s.transferPool.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	if s.cron != nil {
+		select {
+		case <-s.cron.Stop().Done():
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("failed to stop scheduler: %w", ctx.Err()))
+		}
+	}
+
+	if err := s.model.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
 func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
@@ -59,24 +111,24 @@ func respondJSON(w http.ResponseWriter, r *http.Request, data interface{}) {
 	}
 }
 
+// Client errors carry their own status and safe message, anything else is a 500 with the details only in the logs
 func respondErr(w http.ResponseWriter, r *http.Request, err error) {
 	_, l := trace.Logger(r.Context())
 
 	clientErr := &model.ClientError{}
 	if errors.As(err, &clientErr) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
+		status := clientErr.Status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
 
-		_ = json.NewEncoder(w).Encode(struct {
-			Code    string `json:"code"`
-			Message string `json:"message"`
-		}{clientErr.Code, err.Error()})
+		problem.Write(w, r, status, clientErr.Code, clientErr.Message)
 
 		l.Warn("client error", "code", clientErr.Code, "err", err)
 		return
 	}
 
-	http.Error(w, err.Error(), http.StatusInternalServerError)
+	problem.Write(w, r, http.StatusInternalServerError, problem.CODE_INTERNAL_ERROR, "Internal server error")
 
 	l.Error("internal error", "err", err)
 }
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"js-centralized-wallet/pkg/model"
+	"js-centralized-wallet/pkg/trace"
+	"js-centralized-wallet/pkg/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GET_STATEMENT_CTX_SECONDS = 60
+
+	STATEMENT_FORMAT_CSV  = "csv"
+	STATEMENT_FORMAT_JSON = "json"
+	STATEMENT_FORMAT_OFX  = "ofx"
+)
+
+type StatementItem struct {
+	TransactionUUID  string      `json:"transaction_uuid"`
+	CreatedAt        time.Time   `json:"created_at"`
+	TransactionType  string      `json:"transaction_type"`
+	Description      string      `json:"desc"`
+	Memo             string      `json:"memo,omitempty"`
+	CounterpartyName string      `json:"counterparty_name,omitempty"`
+	Amount           model.Money `json:"amount"`
+
+	// Running balance after this transaction
+	Balance model.Money `json:"balance"`
+}
+
+// Writes a statement as it streams, header first, then items oldest first
+type statementWriter interface {
+	contentType() string
+	writeHeader(statement model.Statement) error
+	writeItem(item StatementItem) error
+	writeFooter(statement model.Statement) error
+}
+
+func newStatementWriter(format string, w io.Writer, currency model.Currency) (statementWriter, error) {
+	switch format {
+	case "", STATEMENT_FORMAT_CSV:
+		return &csvStatementWriter{w: csv.NewWriter(w)}, nil
+	case STATEMENT_FORMAT_JSON:
+		return &jsonStatementWriter{w: w}, nil
+	case STATEMENT_FORMAT_OFX:
+		return &ofxStatementWriter{w: w, currency: currency}, nil
+	default:
+		return nil, model.ErrBadInput
+	}
+}
+
+func (s *Server) getStatement(w http.ResponseWriter, r *http.Request) {
+
+	ctx, lg := trace.Logger(r.Context())
+	getStatementCtx, cancel := context.WithTimeout(ctx, GET_STATEMENT_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(getStatementCtx)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+	currency, err := model.ParseCurrency(q.Get("currency"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	from, err := parseQueryTime(q, "from")
+	if err != nil || from == nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	to, err := parseQueryTime(q, "to")
+	if err != nil || to == nil {
+		respondErr(w, r, model.ErrBadInput)
+		return
+	}
+
+	format := strings.ToLower(q.Get("format"))
+	writer, err := newStatementWriter(format, w, currency)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	if format == "" {
+		format = STATEMENT_FORMAT_CSV
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	var statement model.Statement
+	var balance model.Money
+	started := false
+
+	start := func(st model.Statement) error {
+		statement, balance, started = st, st.OpeningBalance, true
+
+		w.Header().Set("Content-Type", writer.contentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s-%s-%s.%s"`,
+			currency.Code, from.Format("20060102"), to.Format("20060102"), format))
+
+		// Status is sent with the first write, errors from here on can only be logged
+		return writer.writeHeader(statement)
+	}
+
+	err = s.model.StreamStatement(getStatementCtx, userId, currency.Code, *from, *to, start, func(transactions []model.Transaction) error {
+
+		// One lookup per page
+		counterpartyWalletIds := make([]uint64, len(transactions))
+		for i, transaction := range transactions {
+			counterpartyWalletIds[i] = transaction.SourceWalletId
+		}
+
+		counterparties, err := s.model.GetCounterparties(getStatementCtx, counterpartyWalletIds)
+		if err != nil {
+			return err
+		}
+
+		for _, transaction := range transactions {
+			counterparty := counterparties[transaction.SourceWalletId]
+
+			balance, err = balance.Add(transaction.Amount)
+			if err != nil {
+				return err
+			}
+
+			if err := writer.writeItem(StatementItem{
+				TransactionUUID:  transaction.TransactionUUID,
+				CreatedAt:        transaction.CreatedAt,
+				TransactionType:  transaction.Type.String(),
+				Description:      describeTransaction(transaction, counterparty),
+				Memo:             transaction.Memo,
+				CounterpartyName: counterparty.Name,
+				Amount:           transaction.Amount,
+				Balance:          balance,
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Rows reach the client page by page, through the gzip middleware as well
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	})
+	// Nothing is written until the statement is read, its errors still get a response
+	if err != nil && !started {
+		respondErr(w, r, err)
+		return
+	}
+	if err != nil {
+		lg.Error("failed to stream statement", "err", err)
+		return
+	}
+
+	if err := writer.writeFooter(statement); err != nil {
+		lg.Error("failed to write statement footer", "err", err)
+	}
+}
+
+// Opening and closing balances are rows of their own, so the file stays a single table
+type csvStatementWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvStatementWriter) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (c *csvStatementWriter) writeHeader(statement model.Statement) error {
+	if err := c.w.Write([]string{"date", "transaction_uuid", "type", "description", "memo", "counterparty", "amount", "balance"}); err != nil {
+		return err
+	}
+
+	if err := c.w.Write([]string{statement.From.Format(time.RFC3339), "", "Opening Balance", "", "", "", "", strconv.FormatInt(statement.OpeningBalance.Amount, 10)}); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvStatementWriter) writeItem(item StatementItem) error {
+	err := c.w.Write([]string{
+		item.CreatedAt.Format(time.RFC3339),
+		item.TransactionUUID,
+		item.TransactionType,
+		item.Description,
+		item.Memo,
+		item.CounterpartyName,
+		strconv.FormatInt(item.Amount.Amount, 10),
+		strconv.FormatInt(item.Balance.Amount, 10),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Flushed into the response writer, so the http flusher has something to send
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvStatementWriter) writeFooter(statement model.Statement) error {
+	if err := c.w.Write([]string{statement.To.Format(time.RFC3339), "", "Closing Balance", "", "", "", "", strconv.FormatInt(statement.ClosingBalance.Amount, 10)}); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Statement fields first, then the transactions array written one item at a time
+type jsonStatementWriter struct {
+	w     io.Writer
+	items int
+}
+
+func (j *jsonStatementWriter) contentType() string {
+	return "application/json"
+}
+
+func (j *jsonStatementWriter) writeHeader(statement model.Statement) error {
+	header, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	// Leaves the object open for the transactions array
+	if _, err := j.w.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(j.w, `,"transactions":[`)
+	return err
+}
+
+func (j *jsonStatementWriter) writeItem(item StatementItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if j.items > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.items++
+
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonStatementWriter) writeFooter(statement model.Statement) error {
+	_, err := io.WriteString(j.w, "]}\n")
+	return err
+}
+
+// OFX 2 bank statement, amounts in major units as OFX expects
+// Opening and closing balances go in BALLIST, closing balance in LEDGERBAL as well
+type ofxStatementWriter struct {
+	w        io.Writer
+	currency model.Currency
+}
+
+const ofxDateFormat = "20060102150405"
+
+func (o *ofxStatementWriter) contentType() string {
+	return "application/x-ofx"
+}
+
+func (o *ofxStatementWriter) writeHeader(statement model.Statement) error {
+	_, err := fmt.Fprintf(o.w, `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>0</TRNUID>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS>
+<CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>js-centralized-wallet</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>%s</DTSTART>
+<DTEND>%s</DTEND>
+`, statement.Currency, statement.WalletId, statement.From.UTC().Format(ofxDateFormat), statement.To.UTC().Format(ofxDateFormat))
+
+	return err
+}
+
+func (o *ofxStatementWriter) writeItem(item StatementItem) error {
+	trnType := "CREDIT"
+	if item.Amount.IsNegative() {
+		trnType = "DEBIT"
+	}
+
+	name := item.CounterpartyName
+	if name == "" {
+		name = item.TransactionType
+	}
+
+	memo := item.Memo
+	if memo == "" {
+		memo = item.Description
+	}
+
+	_, err := fmt.Fprintf(o.w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n",
+		trnType, item.CreatedAt.UTC().Format(ofxDateFormat), o.currency.FormatAmount(item.Amount.Amount), xmlEscape(item.TransactionUUID), xmlEscape(name), xmlEscape(memo))
+
+	return err
+}
+
+func (o *ofxStatementWriter) writeFooter(statement model.Statement) error {
+	_, err := fmt.Fprintf(o.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+<BALLIST>
+<BAL><NAME>Opening Balance</NAME><DESC>Balance before DTSTART</DESC><BALTYPE>DOLLAR</BALTYPE><VALUE>%s</VALUE><DTASOF>%s</DTASOF></BAL>
+<BAL><NAME>Closing Balance</NAME><DESC>Balance before DTEND</DESC><BALTYPE>DOLLAR</BALTYPE><VALUE>%s</VALUE><DTASOF>%s</DTASOF></BAL>
+</BALLIST>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`, o.currency.FormatAmount(statement.ClosingBalance.Amount), statement.To.UTC().Format(ofxDateFormat),
+		o.currency.FormatAmount(statement.OpeningBalance.Amount), statement.From.UTC().Format(ofxDateFormat),
+		o.currency.FormatAmount(statement.ClosingBalance.Amount), statement.To.UTC().Format(ofxDateFormat))
+
+	return err
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
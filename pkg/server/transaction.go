@@ -8,6 +8,8 @@ import (
 	"js-centralized-wallet/pkg/trace"
 	"js-centralized-wallet/pkg/utils"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -18,49 +20,71 @@ const (
 	GET_TRANSCITON_HISTORY_CTX_SECONDS = 10
 )
 
-type TransactionHistoryReq struct {
-	TransactionType int `json:"type"`
-	Page            int `json:"page"`
-	PageSize        int `json:"page_size"`
-}
-
 type TransactionItem struct {
 	TransactionUUID       string                `json:"transaction_uuid"`
-	Amount                int64                 `json:"amount"`
+	Currency              string                `json:"currency"`
+	Amount                model.Money           `json:"amount"`
 	TransactionType       model.TransactionType `json:"type"`
 	TransactionTypeString string                `json:"transaction_type"`
 	Description           string                `json:"desc"`
+	Memo                  string                `json:"memo,omitempty"`
 	CreatedAt             time.Time             `json:"created_at"`
+
+	// Empty for deposits and withdrawals, which have no user on the other side
+	CounterpartyUserId uint64 `json:"counterparty_user_id,omitempty"`
+	CounterpartyName   string `json:"counterparty_name,omitempty"`
+
+	// Only for reversals, the transaction whose funds were moved back
+	ReversalOf string `json:"reversal_of,omitempty"`
+
+	// Only for transfers paying a payment request
+	PaymentRequestId string `json:"payment_request_id,omitempty"`
 }
 
 type TransactionHistoryResp struct {
-	StatementBalance int64             `json:"statement_balance"`
+	Currency         string            `json:"currency"`
+	StatementBalance model.Money       `json:"statement_balance"`
 	Transactions     []TransactionItem `json:"transactions"`
+
+	// Pass as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor"`
 }
 
 type TransferBalanceReq struct {
-	DestinationUserId uint64 `json:"destination_user_id"`
-	Amount            int64  `json:"amount"`
+	DestinationUserId uint64      `json:"destination_user_id"`
+	Currency          string      `json:"currency"`
+	Amount            model.Money `json:"amount"`
+
+	// Optional, shown to both users in their transaction history
+	Memo string `json:"memo"`
 }
 
 type TransferBalanceResp struct {
 	Success bool `json:"success"`
+
+	// Only for async transfers, to look up the transfer status
+	TransferId string `json:"transfer_id,omitempty"`
+	Status     string `json:"status,omitempty"`
 }
 
 type DepositReq struct {
-	Amount int64 `json:"amount"`
+	Currency string      `json:"currency"`
+	Amount   model.Money `json:"amount"`
 }
 
 type DepositResp struct {
-	Balance int64 `json:"balance"`
+	Currency string      `json:"currency"`
+	Balance  model.Money `json:"balance"`
 }
 
 type WithdrawReq struct {
-	Amount int64 `json:"amount"`
+	Currency string      `json:"currency"`
+	Amount   model.Money `json:"amount"`
 }
 
 type WithdrawResp struct {
-	Balance int64 `json:"balance"`
+	Currency string      `json:"currency"`
+	Balance  model.Money `json:"balance"`
 }
 
 func (s *Server) getTransactionHistory(w http.ResponseWriter, r *http.Request) {
@@ -76,14 +100,24 @@ func (s *Server) getTransactionHistory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	q := r.URL.Query()
-	transactionType := utils.GetQueryInt(q, "type", 0)
-	page := utils.GetQueryInt(q, "page", 1)
-	pageSize := utils.GetQueryInt(q, "page_size", 30)
+	currency, err := model.ParseCurrency(q.Get("currency"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+	filter, err := parseTransactionFilter(q)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
 
+	// All pages of a user live in one hash, so they are invalidated together
+	// Encode sorts query parameters, the same filters always map to the same field
 	redis := s.model.GetRedis()
-	transactionHistoryKey := fmt.Sprintf("transaction_history:%d-%d-%d-%d", userId, transactionType, page, pageSize)
+	transactionHistoryKey := model.TransactionHistoryCacheKey(userId)
+	transactionHistoryField := fmt.Sprintf("%s-%s", currency.Code, q.Encode())
 
-	historyStr, err := redis.Get(ctx, transactionHistoryKey).Result()
+	historyStr, err := redis.HGet(ctx, transactionHistoryKey, transactionHistoryField).Result()
 	if err == nil && historyStr != "" {
 		var resp TransactionHistoryResp
 		err = json.Unmarshal([]byte(historyStr), &resp)
@@ -95,52 +129,185 @@ func (s *Server) getTransactionHistory(w http.ResponseWriter, r *http.Request) {
 
 	lg.Info("Get transaction history cache miss, getting from DB")
 
-	transactions, err := s.model.GetTransactionHistory(getTransactionHistoryCtx, userId, transactionType, model.PageInfo{
-		Page:     page,
-		PageSize: pageSize,
-	})
+	transactions, nextCursor, err := s.model.GetTransactionHistory(getTransactionHistoryCtx, userId, currency.Code, filter)
+	if err != nil {
+		respondErr(w, r, err)
+		return
+	}
+
+	// One lookup for the whole page
+	counterpartyWalletIds := make([]uint64, len(transactions))
+	for i, transaction := range transactions {
+		counterpartyWalletIds[i] = transaction.SourceWalletId
+	}
+
+	counterparties, err := s.model.GetCounterparties(getTransactionHistoryCtx, counterpartyWalletIds)
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
 	transactionResp := make([]TransactionItem, len(transactions))
-	var filteredBalance int64
+	filteredBalance := model.Money{Currency: currency.Code}
 
 	for i, transaction := range transactions {
+		counterparty := counterparties[transaction.SourceWalletId]
 
 		transactionResp[i] = TransactionItem{
 			TransactionUUID:       transaction.TransactionUUID,
+			Currency:              transaction.Currency,
 			Amount:                transaction.Amount,
 			TransactionType:       transaction.Type,
 			TransactionTypeString: transaction.Type.String(),
+			Memo:                  transaction.Memo,
 			CreatedAt:             transaction.CreatedAt,
+			CounterpartyUserId:    counterparty.UserId,
+			CounterpartyName:      counterparty.Name,
+			ReversalOf:            transaction.ReversalOf,
+			PaymentRequestId:      transaction.PaymentRequestUUID,
 		}
 
-		if transaction.Amount > 0 {
-			transactionResp[i].Description = fmt.Sprintf("Received $%d", transaction.Amount)
-		} else {
-			transactionResp[i].Description = fmt.Sprintf("Sent $%d", transaction.Amount*-1)
-		}
+		transactionResp[i].Description = describeTransaction(transaction, counterparty)
 
-		filteredBalance += transaction.Amount
+		filteredBalance, err = filteredBalance.Add(transaction.Amount)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
 	}
 
 	resp := TransactionHistoryResp{
+		Currency:         currency.Code,
 		Transactions:     transactionResp,
 		StatementBalance: filteredBalance,
+		NextCursor:       nextCursor,
 	}
 
 	redisData, err := json.Marshal(resp)
 	if err != nil {
 		lg.Error("failed to marshal resp into redis", "error", err)
 	} else {
-		_ = redis.Set(ctx, transactionHistoryKey, redisData, 5*time.Minute).Err()
+		pipe := redis.TxPipeline()
+		pipe.HSet(ctx, transactionHistoryKey, transactionHistoryField, redisData)
+		pipe.Expire(ctx, transactionHistoryKey, 5*time.Minute)
+		_, _ = pipe.Exec(ctx)
 	}
 
 	respondJSON(w, r, resp)
 }
 
+func describeTransaction(transaction model.Transaction, counterparty model.Counterparty) string {
+	switch transaction.Type {
+	case model.TRANSACTION_TYPE_ADJUSTMENT:
+		return fmt.Sprintf("Balance adjusted by %s %d", transaction.Currency, transaction.Amount.Amount)
+	case model.TRANSACTION_TYPE_REVERSAL:
+		return fmt.Sprintf("Reversal of %s: %s", transaction.ReversalOf, describeMovement(transaction, counterparty))
+	case model.TRANSACTION_TYPE_TRANSFER:
+		if transaction.PaymentRequestUUID != "" {
+			return fmt.Sprintf("Payment request %s: %s", transaction.PaymentRequestUUID, describeMovement(transaction, counterparty))
+		}
+		return describeMovement(transaction, counterparty)
+	default:
+		return describeMovement(transaction, counterparty)
+	}
+}
+
+func describeMovement(transaction model.Transaction, counterparty model.Counterparty) string {
+	if transaction.Amount.IsPositive() {
+		if counterparty.UserId == 0 {
+			return fmt.Sprintf("Received %s %d", transaction.Currency, transaction.Amount.Amount)
+		}
+		return fmt.Sprintf("Received %s %d from %s", transaction.Currency, transaction.Amount.Amount, counterparty.Name)
+	}
+
+	// Only MinInt64 cannot be negated, never a valid posting
+	sent, err := transaction.Amount.Neg()
+	if err != nil {
+		sent = transaction.Amount
+	}
+
+	if counterparty.UserId == 0 {
+		return fmt.Sprintf("Sent %s %d", transaction.Currency, sent.Amount)
+	}
+	return fmt.Sprintf("Sent %s %d to %s", transaction.Currency, sent.Amount, counterparty.Name)
+}
+
+// Timestamps are RFC 3339, amounts are absolute in minor units
+func parseTransactionFilter(q url.Values) (model.TransactionFilter, error) {
+	var filter model.TransactionFilter
+	var err error
+
+	filter.Type = model.TransactionType(utils.GetQueryInt(q, "type", 0))
+
+	if filter.From, err = parseQueryTime(q, "from"); err != nil {
+		return filter, err
+	}
+
+	if filter.To, err = parseQueryTime(q, "to"); err != nil {
+		return filter, err
+	}
+
+	if filter.MinAmount, err = parseQueryAmount(q, "min_amount"); err != nil {
+		return filter, err
+	}
+
+	if filter.MaxAmount, err = parseQueryAmount(q, "max_amount"); err != nil {
+		return filter, err
+	}
+
+	if value := q.Get("counterparty_user_id"); value != "" {
+		filter.CounterpartyUserId, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, model.ErrBadInput
+		}
+	}
+
+	filter.Direction, err = model.ParseTransactionDirection(q.Get("direction"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Sort, err = model.ParseSortOrder(q.Get("sort"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Cursor = q.Get("cursor")
+	filter.PageSize = utils.GetQueryInt(q, "page_size", 30)
+
+	return filter, nil
+}
+
+// Nil when the parameter is not given
+func parseQueryTime(q url.Values, key string) (*time.Time, error) {
+	value := q.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, model.ErrBadInput
+	}
+
+	return &t, nil
+}
+
+// Nil when the parameter is not given
+func parseQueryAmount(q url.Values, key string) (*int64, error) {
+	value := q.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, model.ErrInvalidAmount
+	}
+
+	return &amount, nil
+}
+
 func (s *Server) deposit(w http.ResponseWriter, r *http.Request) {
 
 	ctx, _ := trace.Logger(r.Context())
@@ -160,21 +327,25 @@ func (s *Server) deposit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Amount == 0 {
-		respondErr(w, r, model.ErrInvalidAmount)
+	currency, err := model.ParseCurrency(req.Currency)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	newBalance, err := s.model.Deposit(depositCtx, userId, req.Amount)
+	newBalance, err := s.model.Deposit(depositCtx, userId, model.NewMoney(req.Amount.Amount, currency.Code))
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
+	markCommitted(r)
+
 	s.model.InvalidateWalletCache(depositCtx, userId)
 
 	respondJSON(w, r, DepositResp{
-		Balance: newBalance,
+		Currency: currency.Code,
+		Balance:  newBalance,
 	})
 }
 
@@ -190,28 +361,32 @@ func (s *Server) withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req DepositReq
+	var req WithdrawReq
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		respondErr(w, r, model.ErrBadInput)
 		return
 	}
 
-	if req.Amount == 0 {
-		respondErr(w, r, model.ErrInvalidAmount)
+	currency, err := model.ParseCurrency(req.Currency)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	newBalance, err := s.model.Withdraw(withdrawCtx, userId, req.Amount)
+	newBalance, err := s.model.Withdraw(withdrawCtx, userId, model.NewMoney(req.Amount.Amount, currency.Code))
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
+	markCommitted(r)
+
 	s.model.InvalidateWalletCache(withdrawCtx, userId)
 
 	respondJSON(w, r, WithdrawResp{
-		Balance: newBalance,
+		Currency: currency.Code,
+		Balance:  newBalance,
 	})
 }
 
@@ -234,23 +409,29 @@ func (s *Server) transferBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Amount == 0 {
-		respondErr(w, r, model.ErrInvalidAmount)
+	currency, err := model.ParseCurrency(req.Currency)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	// Self transfer prohibited
-	if userId == req.DestinationUserId {
-		respondErr(w, r, model.ErrSelfTransferInvalid)
+	amount := model.NewMoney(req.Amount.Amount, currency.Code)
+
+	// Amount, self transfer, destination and balance, before anything is moved or queued
+	err = s.model.ValidateTransfer(transferBalanceCtx, userId, req.DestinationUserId, amount)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	err = s.model.TransferBalance(transferBalanceCtx, userId, req.DestinationUserId, req.Amount)
+	err = s.model.TransferBalance(transferBalanceCtx, userId, req.DestinationUserId, amount, req.Memo)
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
+	markCommitted(r)
+
 	s.model.InvalidateWalletCache(transferBalanceCtx, userId, req.DestinationUserId)
 
 	respondJSON(w, r, TransferBalanceResp{
@@ -10,6 +10,22 @@ import (
 	"time"
 )
 
+const (
+	GET_TRANSFER_CTX_SECONDS = 10
+)
+
+type TransferStatusResp struct {
+	TransferId        string      `json:"transfer_id"`
+	DestinationUserId uint64      `json:"destination_user_id"`
+	Currency          string      `json:"currency"`
+	Amount            model.Money `json:"amount"`
+	Memo              string      `json:"memo,omitempty"`
+	Status            string      `json:"status"`
+	FailureReason     string      `json:"failure_reason,omitempty"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+}
+
 func (s *Server) transferBalanceV2(w http.ResponseWriter, r *http.Request) {
 
 	ctx, _ := trace.Logger(r.Context())
@@ -29,36 +45,64 @@ func (s *Server) transferBalanceV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Amount == 0 {
-		respondErr(w, r, model.ErrInvalidAmount)
+	currency, err := model.ParseCurrency(req.Currency)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	// Self transfer prohibited
-	if userId == req.DestinationUserId {
-		respondErr(w, r, model.ErrSelfTransferInvalid)
+	amount := model.NewMoney(req.Amount.Amount, currency.Code)
+
+	// Amount, self transfer, destination and balance, before anything is moved or queued
+	err = s.model.ValidateTransfer(transferBalanceCtx, userId, req.DestinationUserId, amount)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	sourceBalance, err := s.model.GetWalletBalance(ctx, userId)
+	// Persisted before responding, picked up by TransferWorkerPool even if we restart
+	transfer, err := s.model.EnqueueTransfer(transferBalanceCtx, userId, req.DestinationUserId, amount, req.Memo)
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
-	if sourceBalance < req.Amount {
-		respondErr(w, r, model.ErrBalanceInsufficient)
+	markCommitted(r)
+
+	respondJSON(w, r, TransferBalanceResp{
+		Success:    err == nil,
+		TransferId: transfer.TransferUUID,
+		Status:     transfer.Status.String(),
+	})
+}
+
+func (s *Server) getTransfer(w http.ResponseWriter, r *http.Request) {
+
+	ctx, _ := trace.Logger(r.Context())
+	getTransferCtx, cancel := context.WithTimeout(ctx, GET_TRANSFER_CTX_SECONDS*time.Second)
+	defer cancel()
+
+	userId, err := utils.GetUserIdFromCtx(getTransferCtx)
+	if err != nil {
+		respondErr(w, r, err)
 		return
 	}
 
-	s.jobChan <- model.TransferJob{
-		Ctx:          context.Background(),
-		SourceUserId: userId,
-		DestUserId:   req.DestinationUserId,
-		Amount:       req.Amount,
+	transfer, err := s.model.GetTransfer(getTransferCtx, userId, r.PathValue("id"))
+	if err != nil {
+		respondErr(w, r, err)
+		return
 	}
 
-	respondJSON(w, r, TransferBalanceResp{
-		Success: err == nil,
+	respondJSON(w, r, TransferStatusResp{
+		TransferId:        transfer.TransferUUID,
+		DestinationUserId: transfer.DestUserId,
+		Currency:          transfer.Currency,
+		Amount:            transfer.Amount,
+		Memo:              transfer.Memo,
+		Status:            transfer.Status.String(),
+		FailureReason:     transfer.FailureReason,
+		CreatedAt:         transfer.CreatedAt,
+		UpdatedAt:         transfer.UpdatedAt,
 	})
 }
@@ -2,11 +2,12 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"js-centralized-wallet/pkg/model"
 	"js-centralized-wallet/pkg/trace"
 	"js-centralized-wallet/pkg/utils"
 	"net/http"
-	"strconv"
 	"time"
 )
 
@@ -15,8 +16,22 @@ const (
 	GET_WALLET_HISTORY_CTX_SECONDS = 10
 )
 
+type CurrencyBalance struct {
+	Currency string      `json:"currency"`
+	Balance  model.Money `json:"balance"`
+
+	// Balance less active holds, what can be withdrawn or transferred
+	AvailableBalance model.Money `json:"available_balance"`
+
+	// Number of decimal places in Balance, e.g. 2 means 1050 is 10.50
+	MinorUnits int `json:"minor_units"`
+
+	// Only active wallets can deposit, withdraw or transfer
+	Status string `json:"status"`
+}
+
 type GetBalanceResp struct {
-	Balance int64 `json:"balance"`
+	Balances []CurrencyBalance `json:"balances"`
 }
 
 func (s *Server) getWalletBalance(w http.ResponseWriter, r *http.Request) {
@@ -36,25 +51,47 @@ func (s *Server) getWalletBalance(w http.ResponseWriter, r *http.Request) {
 
 	balanceStr, err := redis.Get(ctx, balanceKey).Result()
 	if err == nil && balanceStr != "" {
-		balance, convErr := strconv.ParseInt(balanceStr, 10, 64)
-		if convErr == nil {
-			respondJSON(w, r, GetBalanceResp{
-				Balance: balance,
-			})
+		var resp GetBalanceResp
+		err = json.Unmarshal([]byte(balanceStr), &resp)
+		if err == nil {
+			respondJSON(w, r, resp)
 			return
 		}
 	}
 
 	lg.Info("Get wallet balance cache miss, getting from DB")
-	balance, err := s.model.GetWalletBalance(getWalletCtx, userId)
+	wallets, err := s.model.GetWallets(getWalletCtx, userId)
 	if err != nil {
 		respondErr(w, r, err)
 		return
 	}
 
-	_ = redis.Set(ctx, balanceKey, fmt.Sprintf("%d", balance), 5*time.Minute).Err()
+	resp := GetBalanceResp{
+		Balances: make([]CurrencyBalance, 0, len(wallets)),
+	}
+
+	for _, wallet := range wallets {
+		currency, err := model.ParseCurrency(wallet.Currency)
+		if err != nil {
+			respondErr(w, r, err)
+			return
+		}
+
+		resp.Balances = append(resp.Balances, CurrencyBalance{
+			Currency:         currency.Code,
+			Balance:          wallet.Balance,
+			AvailableBalance: wallet.AvailableBalance,
+			MinorUnits:       currency.MinorUnits,
+			Status:           wallet.Status.String(),
+		})
+	}
+
+	redisData, err := json.Marshal(resp)
+	if err != nil {
+		lg.Error("failed to marshal resp into redis", "error", err)
+	} else {
+		_ = redis.Set(ctx, balanceKey, redisData, 5*time.Minute).Err()
+	}
 
-	respondJSON(w, r, GetBalanceResp{
-		Balance: balance,
-	})
+	respondJSON(w, r, resp)
 }
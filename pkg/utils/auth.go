@@ -14,3 +14,8 @@ func GetUserIdFromCtx(ctx context.Context) (uint64, error) {
 	}
 	return userId, nil
 }
+
+func GetRolesFromCtx(ctx context.Context) []string {
+	roles, _ := ctx.Value(middlewares.ROLES_KEY).([]string)
+	return roles
+}
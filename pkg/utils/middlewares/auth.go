@@ -2,26 +2,74 @@ package middlewares
 
 import (
 	"context"
+	"js-centralized-wallet/pkg/utils/problem"
+	"js-centralized-wallet/pkg/utils/token"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type ctxKey string
 
 const (
 	USER_ID_KEY ctxKey = "userId"
+	ROLES_KEY   ctxKey = "roles"
 )
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// Expects "Authorization: Bearer <token>", the token subject is the user id
+// Roles come from the token claims, RoleMiddleware checks them against the users table
+func AuthMiddleware(keyring *token.Keyring, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userIdStr := r.Header.Get("Authorization")
-		userId, err := strconv.ParseUint(userIdStr, 10, 64)
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized", "Authentication is required")
+			return
+		}
+
+		claims, err := keyring.Verify(bearer)
+		if err != nil {
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized", "Authentication is required")
+			return
+		}
+
+		userId, err := strconv.ParseUint(claims.Subject, 10, 64)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized", "Authentication is required")
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), USER_ID_KEY, userId)
+		ctx = context.WithValue(ctx, ROLES_KEY, claims.Roles)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// Current roles of the user, e.g. from the users table
+type RoleLookup func(ctx context.Context, userId uint64) ([]string, error)
+
+// Must run after AuthMiddleware, responds 403 unless the user currently holds the role
+// Roles are looked up on every request rather than taken from the token, so a revoked role applies at once
+func RoleMiddleware(role string, lookup RoleLookup, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := r.Context().Value(USER_ID_KEY).(uint64)
+		if !ok {
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized", "Authentication is required")
+			return
+		}
+
+		roles, err := lookup(r.Context(), userId)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CODE_INTERNAL_ERROR, "Internal server error")
+			return
+		}
+
+		if !slices.Contains(roles, role) {
+			problem.Write(w, r, http.StatusForbidden, "forbidden", "Not allowed to access this resource")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ROLES_KEY, roles)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -3,7 +3,9 @@ package middlewares
 import (
 	"context"
 	"fmt"
+	"js-centralized-wallet/pkg/utils/problem"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -23,26 +25,27 @@ func ThrottleMiddleware(redis *redis.Client, next http.HandlerFunc) http.Handler
 		if err != nil {
 			_, err = redis.Set(ctx, key, 0, window).Result()
 			if err != nil {
-				http.Error(w, "Error creating counter", http.StatusInternalServerError)
+				problem.Write(w, r, http.StatusInternalServerError, problem.CODE_INTERNAL_ERROR, "Internal server error")
 				return
 			}
 			count = 0
 		}
 
 		if count >= int64(limit) {
-			http.Error(w, "Rate limited", http.StatusTooManyRequests)
+			w.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+			problem.Write(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later")
 			return
 		}
 
 		_, err = redis.Incr(ctx, key).Result()
 		if err != nil {
-			http.Error(w, "Error increasing counter", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusInternalServerError, problem.CODE_INTERNAL_ERROR, "Internal server error")
 			return
 		}
 
 		_, err = redis.Expire(ctx, key, window).Result()
 		if err != nil {
-			http.Error(w, "Error setting expiration for rate limit", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusInternalServerError, problem.CODE_INTERNAL_ERROR, "Internal server error")
 			return
 		}
 
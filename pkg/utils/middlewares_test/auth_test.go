@@ -0,0 +1,153 @@
+package middlewares_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"js-centralized-wallet/pkg/utils/middlewares"
+	"js-centralized-wallet/pkg/utils/problem"
+	"js-centralized-wallet/pkg/utils/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	keyring, err := token.NewKeyring("wallet", "k1", map[string][]byte{
+		"k1": []byte("test-secret-test-secret-test-secret"),
+	})
+	assert.NoError(t, err)
+
+	handler := middlewares.AuthMiddleware(keyring, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(middlewares.USER_ID_KEY))
+	})
+
+	validToken, _, err := keyring.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	expiredToken, _, err := keyring.Issue("42", -time.Second)
+	assert.NoError(t, err)
+
+	t.Run("Valid bearer token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/wallet/balance/v1", nil)
+		req.Header.Set("Authorization", "Bearer "+validToken)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "42", rr.Body.String())
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"Missing header", ""},
+		{"Raw user id", "42"},
+		{"Missing bearer prefix", validToken},
+		{"Expired token", "Bearer " + expiredToken},
+		{"Garbage token", "Bearer abc.def.ghi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/wallet/balance/v1", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+			assert.Equal(t, problem.CONTENT_TYPE, rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), `"code":"unauthorized"`)
+		})
+	}
+}
+
+func TestRoleMiddleware(t *testing.T) {
+	keyring, err := token.NewKeyring("wallet", "k1", map[string][]byte{
+		"k1": []byte("test-secret-test-secret-test-secret"),
+	})
+	assert.NoError(t, err)
+
+	// As in the users table, user 7 was an admin when their token was issued
+	roles := map[uint64][]string{
+		1:  {"admin"},
+		7:  {"user"},
+		42: {"user"},
+	}
+	lookup := func(ctx context.Context, userId uint64) ([]string, error) {
+		if userId == 500 {
+			return nil, errors.New("database is down")
+		}
+		return roles[userId], nil
+	}
+
+	handler := middlewares.AuthMiddleware(keyring, middlewares.RoleMiddleware("admin", lookup, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(middlewares.ROLES_KEY))
+	}))
+
+	adminToken, _, err := keyring.Issue("1", time.Minute, "admin")
+	assert.NoError(t, err)
+
+	userToken, _, err := keyring.Issue("42", time.Minute, "user")
+	assert.NoError(t, err)
+
+	roleless, _, err := keyring.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	revoked, _, err := keyring.Issue("7", time.Minute, "admin")
+	assert.NoError(t, err)
+
+	unknown, _, err := keyring.Issue("99", time.Minute, "admin")
+	assert.NoError(t, err)
+
+	failing, _, err := keyring.Issue("500", time.Minute, "admin")
+	assert.NoError(t, err)
+
+	t.Run("Admin token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/users/v1", nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "[admin]", rr.Body.String())
+	})
+
+	for name, bearer := range map[string]string{
+		"User token":            userToken,
+		"Token without roles":   roleless,
+		"Admin role revoked":    revoked,
+		"User no longer exists": unknown,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/admin/users/v1", nil)
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusForbidden, rr.Code)
+			assert.Equal(t, problem.CONTENT_TYPE, rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+		})
+	}
+
+	t.Run("Lookup fails", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/users/v1", nil)
+		req.Header.Set("Authorization", "Bearer "+failing)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Equal(t, problem.CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	})
+}
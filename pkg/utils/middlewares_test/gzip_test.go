@@ -0,0 +1,44 @@
+package middlewares_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"js-centralized-wallet/pkg/utils/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipMiddlewareFlush(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	handler := middlewares.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first row\n"))
+
+		flusher, ok := w.(http.Flusher)
+		assert.True(t, ok, "expected gzip writer to be a http.Flusher")
+		flusher.Flush()
+
+		// Flushed rows reach the client before the handler returns
+		assert.True(t, rr.Flushed)
+		assert.NotZero(t, rr.Body.Len())
+
+		_, _ = w.Write([]byte("second row\n"))
+	})
+
+	req := httptest.NewRequest("GET", "/api/statements/v1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "first row\nsecond row\n", string(body))
+}
@@ -0,0 +1,37 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	CONTENT_TYPE = "application/problem+json"
+
+	CODE_INTERNAL_ERROR = "internal_error"
+)
+
+// RFC 7807 problem details, Code is an extension member with the stable error code clients match on
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// Problem types are not documented at their own URIs, so type is about:blank and title the status text
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
@@ -0,0 +1,185 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ALGORITHM = "HS256"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	// Snapshot of the subject's roles when the token was issued
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Signs tokens with a single key, verifies with any of the active keys
+// To rotate: add the new key, switch signing key to it, remove the old key once tokens signed by it expired
+type Keyring struct {
+	issuer       string
+	signingKeyId string
+	keys         map[string][]byte
+}
+
+func NewKeyring(issuer, signingKeyId string, keys map[string][]byte) (*Keyring, error) {
+	if issuer == "" {
+		return nil, fmt.Errorf("issuer is required")
+	}
+
+	if _, ok := keys[signingKeyId]; !ok {
+		return nil, fmt.Errorf("signing key %q not found in keys", signingKeyId)
+	}
+
+	for keyId, key := range keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("key %q must be at least 32 bytes", keyId)
+		}
+	}
+
+	return &Keyring{
+		issuer:       issuer,
+		signingKeyId: signingKeyId,
+		keys:         keys,
+	}, nil
+}
+
+// Parses keys in the form of "kid1:secret1,kid2:secret2"
+func ParseKeys(s string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		keyId, secret, ok := strings.Cut(pair, ":")
+		if !ok || keyId == "" || secret == "" {
+			return nil, fmt.Errorf("invalid key %q, expected kid:secret", pair)
+		}
+
+		keys[keyId] = []byte(secret)
+	}
+
+	return keys, nil
+}
+
+func (k *Keyring) Issue(subject string, ttl time.Duration, roles ...string) (string, Claims, error) {
+	now := time.Now()
+	claims := Claims{
+		Issuer:    k.issuer,
+		Subject:   subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Roles:     roles,
+	}
+
+	headerJSON, err := json.Marshal(header{Algorithm: ALGORITHM, Type: "JWT", KeyId: k.signingKeyId})
+	if err != nil {
+		return "", claims, fmt.Errorf("failed to marshal token header: %w", err)
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, fmt.Errorf("failed to marshal token claims: %w", err)
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+	signature := sign(k.keys[k.signingKeyId], signingInput)
+
+	return signingInput + "." + encode(signature), claims, nil
+}
+
+func (k *Keyring) Verify(token string) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	// Never trust "alg" blindly, only HS256 is accepted
+	if h.Algorithm != ALGORITHM {
+		return claims, ErrInvalidToken
+	}
+
+	key, ok := k.keys[h.KeyId]
+	if !ok {
+		return claims, ErrInvalidToken
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
+		return claims, ErrInvalidToken
+	}
+
+	claimsJSON, err := decode(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if claims.Issuer != k.issuer || claims.Subject == "" {
+		return claims, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+func sign(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
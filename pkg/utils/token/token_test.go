@@ -0,0 +1,125 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oldKey = []byte("old-secret-old-secret-old-secret")
+	newKey = []byte("new-secret-new-secret-new-secret")
+)
+
+func TestIssueAndVerify(t *testing.T) {
+	keyring, err := NewKeyring("wallet", "k1", map[string][]byte{"k1": oldKey})
+	assert.NoError(t, err)
+
+	token, issued, err := keyring.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := keyring.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, issued, claims)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "wallet", claims.Issuer)
+	assert.Empty(t, claims.Roles)
+
+	token, _, err = keyring.Issue("1", time.Minute, "admin")
+	assert.NoError(t, err)
+
+	claims, err = keyring.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, claims.Roles)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	keyring, err := NewKeyring("wallet", "k1", map[string][]byte{"k1": oldKey})
+	assert.NoError(t, err)
+
+	token, _, err := keyring.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+
+	otherIssuer, err := NewKeyring("other", "k1", map[string][]byte{"k1": oldKey})
+	assert.NoError(t, err)
+	otherIssuerToken, _, err := otherIssuer.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	unknownKey, err := NewKeyring("wallet", "k2", map[string][]byte{"k2": newKey})
+	assert.NoError(t, err)
+	unknownKeyToken, _, err := unknownKey.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"raw user id", "42", ErrInvalidToken},
+		{"tampered claims", parts[0] + "." + encode([]byte(`{"iss":"wallet","sub":"1","exp":9999999999}`)) + "." + parts[2], ErrInvalidToken},
+		{"none algorithm", encode([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + ".", ErrInvalidToken},
+		{"other issuer", otherIssuerToken, ErrInvalidToken},
+		{"unknown key", unknownKeyToken, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keyring.Verify(tt.token)
+			assert.True(t, errors.Is(err, tt.want), "expected %v, got %v", tt.want, err)
+		})
+	}
+}
+
+func TestVerifyRejectsExpiredToken(t *testing.T) {
+	keyring, err := NewKeyring("wallet", "k1", map[string][]byte{"k1": oldKey})
+	assert.NoError(t, err)
+
+	token, _, err := keyring.Issue("42", -time.Second)
+	assert.NoError(t, err)
+
+	_, err = keyring.Verify(token)
+	assert.True(t, errors.Is(err, ErrTokenExpired), "expected ErrTokenExpired, got %v", err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	before, err := NewKeyring("wallet", "k1", map[string][]byte{"k1": oldKey})
+	assert.NoError(t, err)
+
+	oldToken, _, err := before.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	// Signs with the new key, still verifies tokens signed by the old key
+	during, err := NewKeyring("wallet", "k2", map[string][]byte{"k1": oldKey, "k2": newKey})
+	assert.NoError(t, err)
+
+	newToken, _, err := during.Issue("42", time.Minute)
+	assert.NoError(t, err)
+
+	_, err = during.Verify(oldToken)
+	assert.NoError(t, err)
+	_, err = during.Verify(newToken)
+	assert.NoError(t, err)
+
+	// Old key removed
+	after, err := NewKeyring("wallet", "k2", map[string][]byte{"k2": newKey})
+	assert.NoError(t, err)
+
+	_, err = after.Verify(oldToken)
+	assert.True(t, errors.Is(err, ErrInvalidToken), "expected ErrInvalidToken, got %v", err)
+	_, err = after.Verify(newToken)
+	assert.NoError(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("k1:secret1, k2:secret:with:colons")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"k1": []byte("secret1"), "k2": []byte("secret:with:colons")}, keys)
+
+	_, err = ParseKeys("k1")
+	assert.Error(t, err)
+}